@@ -25,7 +25,7 @@ func init() {
 }
 
 func includeBaseFlags(cmd *cobra.Command) {
-	cmd.PersistentFlags().StringVarP(&remoteName, "remote", "r", "local", "Name of the remote which will be used to build the base image.")
+	cmd.PersistentFlags().StringVarP(&remoteName, "remote", "r", "", "Name of the remote which will be used to build the base image - defaults to build_remote in the config, or local [OPTIONAL]")
 }
 
 func buildBase(cmd *cobra.Command, args []string) {
@@ -50,16 +50,7 @@ func buildBase(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	remote, err := platform.LoadRemoteSettings(remoteName)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	host.Remote = remote
-
-	if remote.Name != "local" {
-		host.Settings.StoragePool.Name = remote.Storage
-	}
+	selectBuildRemote()
 
 	err = host.BuildImage(*bravefile)
 	if err != nil {
@@ -15,14 +15,30 @@ var braveBuild = &cobra.Command{
 	Run:   build,
 }
 
+var braveBuildLogs = &cobra.Command{
+	Use:   "logs IMAGE",
+	Short: "Show the log of the most recent build of an image",
+	Long:  `Show the full output and per-step report of the most recent build of IMAGE, as stored under ~/.bravetools/builds.`,
+	Args:  cobra.ExactArgs(1),
+	Run:   buildLogs,
+}
+
 var bravefilePath string
+var buildNoCache bool
+var buildPlatforms string
+var buildCompression string
 
 func init() {
+	braveBuild.AddCommand(braveBuildLogs)
 	includePathFlags(braveBuild)
+	braveBuild.Flags().StringVar(&buildPlatforms, "platform", "", "Comma-separated architectures to build for, e.g. amd64,arm64 - each is built on a remote of that architecture [OPTIONAL]")
+	braveBuild.Flags().BoolVar(&buildNoCache, "no-cache", false, "Rebuild all steps without using the build cache [OPTIONAL]")
+	braveBuild.Flags().StringVar(&buildCompression, "compression", "", "Image compression: 'gzip', 'xz', 'zstd' or 'none' - defaults to compression in the config [OPTIONAL]")
 }
 
 func includePathFlags(cmd *cobra.Command) {
-	cmd.Flags().StringVarP(&remoteName, "remote", "r", "local", "Name of a Bravetools remote that will build the image.")
+	cmd.Flags().StringVarP(&remoteName, "remote", "r", "", "Name of a Bravetools remote that will build the image - defaults to build_remote in the config, or local [OPTIONAL]")
+	cmd.Flags().StringVar(&remoteName, "on", "", "Alias for --remote [OPTIONAL]")
 	cmd.Flags().StringVarP(&bravefilePath, "path", "p", "", "Absolute path to Bravefile [OPTIONAL]")
 }
 
@@ -41,15 +57,25 @@ func build(cmd *cobra.Command, args []string) {
 		log.Fatal("failed to load Bravefile: ", err)
 	}
 
-	remote, err := platform.LoadRemoteSettings(remoteName)
-	if err != nil {
-		log.Fatal(err)
+	selectBuildRemote()
+
+	if buildNoCache {
+		host.Settings.Build.NoCache = true
 	}
 
-	host.Remote = remote
+	setCompression(buildCompression)
+
+	if buildPlatforms != "" {
+		archs, err := platform.ParsePlatforms(buildPlatforms)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	if remote.Name != "local" {
-		host.Settings.StoragePool.Name = remote.Storage
+		err = host.BuildImagePlatforms(*bravefile, archs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	err = host.BuildImage(*bravefile)
@@ -62,3 +88,23 @@ func build(cmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 }
+
+// selectBuildRemote selects the remote given with --remote. Without it, builds use build_remote from the config or the local remote.
+func selectBuildRemote() {
+	if remoteName == "" {
+		return
+	}
+
+	remote, err := platform.LoadRemoteSettings(remoteName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	host.SelectRemote(remote)
+}
+
+func buildLogs(cmd *cobra.Command, args []string) {
+	err := host.PrintBuildLog(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"log"
+
+	"github.com/bravetools/bravetools/platform"
+	"github.com/spf13/cobra"
+)
+
+var braveCache = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the build cache",
+	Long: `Bravefile builds cache the result of each package, copy and run step on the build remote.
+Rebuilding an image with unchanged steps resumes from the last cached step.`,
+}
+
+var braveCacheList = &cobra.Command{
+	Use:   "ls",
+	Short: "List build cache images",
+	Long:  ``,
+	Args:  cobra.NoArgs,
+	Run:   cacheList,
+}
+
+var braveCachePrune = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete build cache images",
+	Long:  `Delete all build cache images from the remote, or only those created while building --image.`,
+	Args:  cobra.NoArgs,
+	Run:   cachePrune,
+}
+
+var cacheRemoteName string
+var cacheImageName string
+
+func init() {
+	braveCache.AddCommand(braveCacheList)
+	braveCache.AddCommand(braveCachePrune)
+	braveCache.PersistentFlags().StringVarP(&cacheRemoteName, "remote", "r", "local", "Name of the Bravetools remote storing the build cache")
+	braveCachePrune.Flags().StringVarP(&cacheImageName, "image", "i", "", "Only delete cache images created while building this image [OPTIONAL]")
+}
+
+func loadCacheRemote() {
+	remote, err := platform.LoadRemoteSettings(cacheRemoteName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	host.Remote = remote
+}
+
+func cacheList(cmd *cobra.Command, args []string) {
+	checkBackend()
+	loadCacheRemote()
+
+	err := host.PrintBuildCache()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func cachePrune(cmd *cobra.Command, args []string) {
+	checkBackend()
+	loadCacheRemote()
+
+	err := host.PruneBuildCache(cacheImageName)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -49,6 +49,14 @@ func init() {
 	BravetoolsCmd.AddCommand(remoteCmd)
 	BravetoolsCmd.AddCommand(braveTemplateCmd)
 	BravetoolsCmd.AddCommand(braveExportImage)
+	BravetoolsCmd.AddCommand(braveCache)
+	BravetoolsCmd.AddCommand(braveImage)
+	BravetoolsCmd.AddCommand(braveDiff)
+	BravetoolsCmd.AddCommand(braveRegistry)
+	BravetoolsCmd.AddCommand(braveExec)
+	BravetoolsCmd.AddCommand(braveShell)
+	BravetoolsCmd.AddCommand(braveLogs)
+	BravetoolsCmd.AddCommand(braveCp)
 
 	BravetoolsCmd.CompletionOptions.HiddenDefaultCmd = true
 
@@ -72,6 +80,17 @@ func checkBackend() {
 	}
 }
 
+// setCompression overrides the configured image compression with the one given by a flag
+func setCompression(compression string) {
+	if compression == "" {
+		return
+	}
+	if err := platform.ValidateCompression(compression); err != nil {
+		log.Fatal(err)
+	}
+	host.Settings.Compression = compression
+}
+
 func createBraveHome(userHome string) error {
 	err := shared.CreateDirectory(path.Join(userHome, shared.BraveHome))
 	if err != nil {
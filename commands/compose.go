@@ -1,22 +1,66 @@
 package commands
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/bravetools/bravetools/shared"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 var braveCompose = &cobra.Command{
 	Use:   "compose",
 	Short: "Compose a system from a set of images",
-	Long:  ``,
-	Run:   compose,
+	Long: `Compose builds and deploys a set of services defined in a brave-compose.yaml file.
+
+Several compose files can be layered with repeated --file flags - settings in later files override earlier ones.
+If no files are given, brave-compose.override.yaml is applied on top of brave-compose.yaml when present.`,
+	Run: compose,
 }
 
-func compose(cmd *cobra.Command, args []string) {
+var braveComposeConfig = &cobra.Command{
+	Use:   "config [DIR]",
+	Short: "Print the effective compose configuration",
+	Long: `Print the compose configuration that will be deployed as YAML, after merging all compose files,
+interpolating environment variables and loading service settings from Bravefiles.`,
+	Args: cobra.RangeArgs(0, 1),
+	Run:  composeConfig,
+}
+
+var braveComposeLogs = &cobra.Command{
+	Use:   "logs [DIR]",
+	Short: "Show the logs of all compose services",
+	Long:  `Interleave the logs of the Units of all services in the compose configuration, prefixing each line with its service name.`,
+	Args:  cobra.RangeArgs(0, 1),
+	Run:   composeLogs,
+}
+
+var composeFilePaths []string
+
+func init() {
+	braveCompose.AddCommand(braveComposeConfig)
+	braveCompose.AddCommand(braveComposeLogs)
+	includeLogFlags(braveComposeLogs)
+	includeComposeFlags(braveCompose)
+}
+
+func includeComposeFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringSliceVarP(&composeFilePaths, "file", "f", []string{}, "Compose files to layer, in order of priority [OPTIONAL]")
+}
+
+// resolveComposeFiles returns the compose files to load. Explicitly provided files are used as-is,
+// otherwise the default compose file and its override file (if present) are located in the provided dir or path.
+func resolveComposeFiles(args []string) ([]string, error) {
+	if len(composeFilePaths) > 0 {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("cannot use compose path %q together with --file", args[0])
+		}
+		return composeFilePaths, nil
+	}
+
 	var composefilePath string
 	baseDir := "."
 
@@ -24,39 +68,83 @@ func compose(cmd *cobra.Command, args []string) {
 	if len(args) > 0 {
 		stat, err := os.Stat(args[0])
 		if err != nil {
-			log.Fatalf("unable to find resolve path %q\n", args[0])
+			return nil, fmt.Errorf("unable to find resolve path %q", args[0])
 		}
 		if stat.IsDir() {
 			baseDir = args[0]
 		} else {
-			composefilePath = args[0]
+			return []string{args[0]}, nil
 		}
 	}
 
-	// If composefile not set, then user-provided path is a dir. Attempt to find composefile in dir.
-	if composefilePath == "" {
-		// Load composefile from directory. Favour ".yaml" over ".yml" but accept both.
-		if shared.FileExists(filepath.Join(baseDir, shared.ComposefileName)) {
-			composefilePath = filepath.Join(baseDir, shared.ComposefileName)
-		} else {
-			if shared.FileExists(filepath.Join(baseDir, shared.ComposefileAlias)) {
-				composefilePath = filepath.Join(baseDir, shared.ComposefileAlias)
-			}
+	// Attempt to find composefile in dir. Favour ".yaml" over ".yml" but accept both.
+	if shared.FileExists(filepath.Join(baseDir, shared.ComposefileName)) {
+		composefilePath = filepath.Join(baseDir, shared.ComposefileName)
+	} else {
+		if shared.FileExists(filepath.Join(baseDir, shared.ComposefileAlias)) {
+			composefilePath = filepath.Join(baseDir, shared.ComposefileAlias)
 		}
 	}
 
 	// If composefile path still not set it was not found - fail with err
 	if composefilePath == "" {
-		log.Fatalf("composefile %q not found at %q", shared.ComposefileName, baseDir)
+		return nil, fmt.Errorf("composefile %q not found at %q", shared.ComposefileName, baseDir)
 	}
 
-	err := composefile.Load(composefilePath)
+	files := []string{composefilePath}
 
+	// Pick up override file automatically if it sits next to the composefile
+	if shared.FileExists(filepath.Join(baseDir, shared.ComposefileOverrideName)) {
+		files = append(files, filepath.Join(baseDir, shared.ComposefileOverrideName))
+	} else if shared.FileExists(filepath.Join(baseDir, shared.ComposefileOverrideAlias)) {
+		files = append(files, filepath.Join(baseDir, shared.ComposefileOverrideAlias))
+	}
+
+	return files, nil
+}
+
+func loadComposeFile(args []string) {
+	files, err := resolveComposeFiles(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Compose files can be rendered without an initialized host
+	if composefile == nil {
+		composefile = shared.NewComposeFile()
+	}
+
+	err = composefile.Load(files...)
 	if err != nil {
 		log.Fatal("failed to load compose file: ", err)
 	}
+}
+
+func compose(cmd *cobra.Command, args []string) {
+	loadComposeFile(args)
+
+	err := host.Compose(backend, composefile)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func composeConfig(cmd *cobra.Command, args []string) {
+	loadComposeFile(args)
+
+	config, err := yaml.Marshal(composefile)
+	if err != nil {
+		log.Fatal("failed to render compose file: ", err)
+	}
+
+	fmt.Print(string(config))
+}
+
+func composeLogs(cmd *cobra.Command, args []string) {
+	checkBackend()
+	loadComposeFile(args)
 
-	err = host.Compose(backend, composefile)
+	err := host.ComposeLogs(composefile, logOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
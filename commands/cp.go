@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var braveCp = &cobra.Command{
+	Use:   "cp SRC DST",
+	Short: "Copy files between the host and Units",
+	Long: `Copy files and directories between the host and a Unit, or between two Units.
+Either side can be a host path or [<remote>:]<instance>:/path. Directories are copied recursively, preserving
+mode and ownership. If DST is an existing directory, SRC is copied into it.`,
+	Run: copyPath,
+}
+
+func copyPath(cmd *cobra.Command, args []string) {
+	checkBackend()
+	if len(args) != 2 {
+		log.Fatal("expected a source and a destination, e.g. brave cp ./config.yaml UNIT:/etc/app/")
+	}
+
+	err := host.CopyPath(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
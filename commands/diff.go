@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var braveDiff = &cobra.Command{
+	Use:   "diff [<remote>:]<unit>",
+	Short: "Show files changed in a unit since it was deployed",
+	Long: `Compare the filesystem of a running unit with the image it was deployed from and list files
+added (+), removed (-) or changed (~) to spot configuration drift. Files are compared by type, mode,
+owner, size and modification time. /dev, /proc, /sys, /run and /tmp are not compared.`,
+	Args: cobra.ExactArgs(1),
+	Run:  diffUnit,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return host.GetUnitNames(), cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+func diffUnit(cmd *cobra.Command, args []string) {
+	checkBackend()
+
+	err := host.DiffUnit(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bravetools/bravetools/platform"
+	"github.com/spf13/cobra"
+)
+
+var braveExec = &cobra.Command{
+	Use:   "exec [<remote>:]<instance> -- COMMAND [ARG...]",
+	Short: "Run a command in a Unit",
+	Long: `Run a command in a running Unit and exit with its exit code.
+An interactive terminal is allocated when stdin and stdout are terminals - use --tty or --no-tty to override.
+Arguments after -- are passed to the command unchanged.`,
+	Run:               execUnit,
+	ValidArgsFunction: unitNameCompletion,
+}
+
+var braveShell = &cobra.Command{
+	Use:               "shell [<remote>:]<instance>",
+	Short:             "Open a shell in a Unit",
+	Long:              `Open an interactive login shell in a running Unit as root or the user given with --user.`,
+	Run:               shellUnit,
+	ValidArgsFunction: unitNameCompletion,
+}
+
+var execEnv []string
+var execOptions = platform.ExecOptions{}
+var execTTY, execNoTTY bool
+
+func init() {
+	includeExecFlags(braveExec)
+	includeExecFlags(braveShell)
+	braveExec.Flags().BoolVarP(&execTTY, "tty", "t", false, "Always allocate an interactive terminal")
+	braveExec.Flags().BoolVarP(&execNoTTY, "no-tty", "T", false, "Never allocate an interactive terminal")
+	// Flags after the unit name belong to the command
+	braveExec.Flags().SetInterspersed(false)
+}
+
+func includeExecFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&execEnv, "env", "e", []string{}, "Set an environment variable (e.g. KEY=VALUE) [OPTIONAL]")
+	cmd.Flags().StringVarP(&execOptions.User, "user", "u", "", "User to run as, given as NAME or UID[:GID] [OPTIONAL]")
+	cmd.Flags().StringVarP(&execOptions.Workdir, "workdir", "w", "", "Working directory inside the Unit [OPTIONAL]")
+}
+
+func unitNameCompletion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return host.GetUnitNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func parseExecFlags() {
+	execOptions.Env = map[string]string{}
+	for _, env := range execEnv {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			log.Fatalf("invalid environment variable %q - expected KEY=VALUE", env)
+		}
+		execOptions.Env[kv[0]] = kv[1]
+	}
+
+	if execTTY && execNoTTY {
+		log.Fatal("--tty and --no-tty cannot be used together")
+	}
+	switch {
+	case execTTY:
+		execOptions.Terminal = platform.TerminalAlways
+	case execNoTTY:
+		execOptions.Terminal = platform.TerminalNever
+	}
+}
+
+func execUnit(cmd *cobra.Command, args []string) {
+	checkBackend()
+	if len(args) == 0 {
+		log.Fatal("missing name - please provide unit name")
+	}
+	if dash := cmd.ArgsLenAtDash(); dash > 1 {
+		log.Fatal("expected a single unit name before --")
+	}
+
+	// Flag parsing stops at the unit name, so a separating -- is passed through with the command
+	command := args[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		log.Fatal("missing command - please provide a command after the unit name, e.g. brave exec UNIT -- ls -l")
+	}
+	parseExecFlags()
+
+	exitCode, err := host.ExecUnit(args[0], command, execOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(exitCode)
+}
+
+func shellUnit(cmd *cobra.Command, args []string) {
+	checkBackend()
+	if len(args) != 1 {
+		log.Fatal("missing name - please provide unit name")
+	}
+	parseExecFlags()
+
+	exitCode, err := host.ShellUnit(args[0], execOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(exitCode)
+}
@@ -10,8 +10,13 @@ import (
 var braveExportImage = &cobra.Command{
 	Use:   "export <image> [<image>...]",
 	Short: "Export bravetools image from local image store as LXD tarball",
-	Long:  ``,
-	Run:   exportImage,
+	Long: `Export bravetools images from the local image store as LXD tarballs.
+With --format oci or --format docker, the image is converted into an OCI image layout archive or a docker-archive
+that can be loaded by Podman or Docker. The ports, environment and command of the Bravefile service given
+with --bravefile, or of the Bravefile embedded in the image, are carried into the image config.`,
+	Example: `  brave export cowsay/1.0
+  brave export --format docker --bravefile Bravefile cowsay/1.0 && docker load -i cowsay_1.0_x86_64.docker.tar`,
+	Run: exportImage,
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) != 0 {
 			return nil, cobra.ShellCompDirectiveNoFileComp
@@ -28,9 +33,15 @@ var braveExportImage = &cobra.Command{
 }
 
 var imageExportDir string
+var imageExportFormat string
+var imageExportBravefile string
+var imageExportCompression string
 
 func init() {
 	braveExportImage.Flags().StringVarP(&imageExportDir, "out", "o", "", "Directory to export images to [OPTIONAL]")
+	braveExportImage.Flags().StringVar(&imageExportFormat, "format", platform.ExportFormatLXD, "Export format: 'lxd', 'oci' or 'docker'")
+	braveExportImage.Flags().StringVar(&imageExportCompression, "compression", "", "Recompress lxd exports with 'gzip', 'xz', 'zstd' or 'none' - defaults to compression in the config, or the compression of the stored image [OPTIONAL]")
+	braveExportImage.Flags().StringVar(&imageExportBravefile, "bravefile", "", "Bravefile whose service ports, environment and command are added to OCI config - defaults to the Bravefile embedded in the image [OPTIONAL]")
 }
 
 func exportImage(cmd *cobra.Command, args []string) {
@@ -40,8 +51,18 @@ func exportImage(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	setCompression(imageExportCompression)
+	if imageExportCompression != "" && imageExportFormat != platform.ExportFormatLXD {
+		log.Fatal("--compression only applies to lxd exports")
+	}
+
 	for _, arg := range args {
-		err := platform.ExportBravetoolsImage(arg, imageExportDir)
+		var err error
+		if imageExportFormat == platform.ExportFormatLXD {
+			err = platform.ExportBravetoolsImage(arg, imageExportDir, host.Settings.Compression)
+		} else {
+			err = platform.ExportOCIImage(arg, imageExportDir, imageExportFormat, imageExportBravefile)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,329 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bravetools/bravetools/platform"
+	"github.com/spf13/cobra"
+)
+
+var braveImage = &cobra.Command{
+	Use:   "image",
+	Short: "Manage images in the local image store",
+	Long:  ``,
+}
+
+var braveImageSBOM = &cobra.Command{
+	Use:   "sbom IMAGE",
+	Short: "Show the software bill of materials of an image",
+	Long: `Show the system packages installed in IMAGE, as recorded when it was built.
+The SBOM is stored next to the image in CycloneDX JSON format - use --json to print the document.`,
+	Args: cobra.ExactArgs(1),
+	Run:  imageSBOM,
+}
+
+var braveImageDiffPackages = &cobra.Command{
+	Use:   "diff-packages IMAGE_A IMAGE_B",
+	Short: "Compare the system packages of two images",
+	Long:  `List packages added (+), removed (-) or changed (~) in IMAGE_B compared to IMAGE_A.`,
+	Args:  cobra.ExactArgs(2),
+	Run:   imageDiffPackages,
+}
+
+var braveImageDiff = &cobra.Command{
+	Use:   "diff IMAGE_A IMAGE_B",
+	Short: "Compare the files of two images",
+	Long: `List files added (+), removed (-) or changed (~) in the root filesystem of IMAGE_B compared to IMAGE_A,
+with their sizes. Files are compared by type, mode, owner and content.`,
+	Args: cobra.ExactArgs(2),
+	Run:  imageDiff,
+}
+
+var braveImagePrune = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete images matching filters from the local image store",
+	Long: `Delete images from the local image store that match every provided filter.
+With --leftovers, images imported into remote LXD image stores by interrupted deploys are also deleted.
+A summary of the images to delete and the space reclaimed is shown before asking for confirmation.`,
+	Example: `  brave image prune --untagged
+  brave image prune --keep-last 3 --older-than 30d
+  brave image prune --unused --dry-run
+  brave image prune --leftovers`,
+	Args: cobra.NoArgs,
+	Run:  imagePrune,
+}
+
+var braveImageTag = &cobra.Command{
+	Use:   "tag SRC DST",
+	Short: "Create an alias for an image",
+	Long: `Create an alias DST for the image SRC without copying it. The alias can be used anywhere an image is referenced.
+If SRC has images for several architectures an alias is created for each. Tagging an existing alias moves it.`,
+	Example: `  brave image tag api/1.4.2 api/stable`,
+	Args:    cobra.ExactArgs(2),
+	Run:     imageTag,
+}
+
+var braveImageUntag = &cobra.Command{
+	Use:   "untag ALIAS",
+	Short: "Remove an image alias",
+	Long:  `Remove an alias created with brave image tag. The image it points at is kept.`,
+	Args:  cobra.ExactArgs(1),
+	Run:   imageUntag,
+}
+
+var braveImageSign = &cobra.Command{
+	Use:   "sign IMAGE",
+	Short: "Sign an image with an ed25519 key",
+	Long: `Sign IMAGE with the ed25519 private key given by --key. The detached signature is stored next to the image
+and travels with it on brave export and brave import. --key accepts a PEM file or the name of a key created with brave image keygen.`,
+	Example: `  brave image sign api/1.4.2 --key release`,
+	Args:    cobra.ExactArgs(1),
+	Run:     imageSign,
+}
+
+var braveImageKeygen = &cobra.Command{
+	Use:   "keygen NAME",
+	Short: "Create an ed25519 key pair for signing images",
+	Long: `Create an ed25519 key pair NAME in the bravetools key store. The public key is trusted automatically
+and can be shared with other hosts to be trusted there with brave image trust.`,
+	Args: cobra.ExactArgs(1),
+	Run:  imageKeygen,
+}
+
+var braveImageTrust = &cobra.Command{
+	Use:   "trust PUBLIC_KEY",
+	Short: "Trust a public key to sign images",
+	Long:  `Add a PEM encoded ed25519 public key to the set of keys trusted when verifying image signatures.`,
+	Args:  cobra.ExactArgs(1),
+	Run:   imageTrust,
+}
+
+var braveImagePush = &cobra.Command{
+	Use:   "push IMAGE REMOTE | REMOTE:IMAGE",
+	Short: "Copy a local image to the image store of a remote",
+	Long: `Upload IMAGE from the local image store to the LXD image store or bravetools registry of REMOTE, aliased by its full name.
+The upload is skipped if the remote already has an image with the same fingerprint.`,
+	Example: `  brave image push api/1.4.2 prod
+  brave image push team:api/1.4.2`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  imagePush,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 1 {
+			remoteNames, _ := platform.ListRemotes()
+			return remoteNames, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+var braveImagePull = &cobra.Command{
+	Use:   "pull REMOTE:IMAGE",
+	Short: "Copy an image from the image store of a remote",
+	Long: `Download IMAGE from the LXD image store or bravetools registry of REMOTE into the local image store without building it.
+The architecture of this host is used if IMAGE does not specify one. The download is skipped if an image
+with the same fingerprint already exists locally.`,
+	Example: `  brave image pull prod:api/1.4.2`,
+	Args:    cobra.ExactArgs(1),
+	Run:     imagePull,
+}
+
+var braveImageInspect = &cobra.Command{
+	Use:   "inspect IMAGE",
+	Short: "Show how an image was built",
+	Long: `Show the digest, size, architecture and creation date of IMAGE along with the base images it was built from,
+its metadata.yaml properties and the Bravefile embedded in it at build time.`,
+	Args: cobra.ExactArgs(1),
+	Run:  imageInspect,
+}
+
+var braveImageVerify = &cobra.Command{
+	Use:   "verify [IMAGE...]",
+	Short: "Check images in the local image store for corruption",
+	Long: `Recompute the sha256 digest of each IMAGE, or of every image with --all, and read its archive in full.
+Images whose digest no longer matches the image store index, or whose archive is truncated, are reported as corrupted.`,
+	Example: `  brave image verify api/1.4.2
+  brave image verify --all`,
+	Run: imageVerify,
+}
+
+var sbomJSON bool
+var inspectJSON bool
+var verifyAll bool
+
+var signKey string
+
+var pruneOlderThan string
+var pruneFilter platform.ImagePruneFilter
+var pruneDryRun bool
+var pruneYes bool
+
+func init() {
+	braveImage.AddCommand(braveImageInspect)
+	braveImageInspect.Flags().BoolVar(&inspectJSON, "json", false, "Print image details as JSON")
+	braveImage.AddCommand(braveImageSBOM)
+	braveImage.AddCommand(braveImageDiffPackages)
+	braveImage.AddCommand(braveImageDiff)
+	braveImageSBOM.Flags().BoolVar(&sbomJSON, "json", false, "Print the CycloneDX JSON document")
+
+	braveImage.AddCommand(braveImageTag)
+	braveImage.AddCommand(braveImageUntag)
+	braveImage.AddCommand(braveImageSign)
+	braveImageSign.Flags().StringVar(&signKey, "key", "", "Private key file or name of a key in the key store")
+	braveImageSign.MarkFlagRequired("key")
+	braveImage.AddCommand(braveImageKeygen)
+	braveImage.AddCommand(braveImageTrust)
+	braveImage.AddCommand(braveImagePush)
+	braveImage.AddCommand(braveImagePull)
+	braveImage.AddCommand(braveImagePrune)
+	braveImage.AddCommand(braveImageVerify)
+	braveImageVerify.Flags().BoolVar(&verifyAll, "all", false, "Verify every image in the local image store")
+	braveImagePrune.Flags().StringVar(&pruneOlderThan, "older-than", "", "Only prune images created longer ago than this, e.g. 30d, 2w or 12h")
+	braveImagePrune.Flags().IntVar(&pruneFilter.KeepLast, "keep-last", 0, "Keep the newest N images of each name")
+	braveImagePrune.Flags().BoolVar(&pruneFilter.Untagged, "untagged", false, "Only prune untagged images")
+	braveImagePrune.Flags().BoolVar(&pruneFilter.Unused, "unused", false, "Only prune images not used by any unit")
+	braveImagePrune.Flags().BoolVar(&pruneFilter.Leftovers, "leftovers", false, "Also delete images left in remote LXD image stores by interrupted deploys")
+	braveImagePrune.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show what would be deleted without deleting anything")
+	braveImagePrune.Flags().BoolVarP(&pruneYes, "yes", "y", false, "Do not ask for confirmation")
+}
+
+func imageInspect(cmd *cobra.Command, args []string) {
+	err := host.InspectImage(args[0], inspectJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageVerify(cmd *cobra.Command, args []string) {
+	err := host.VerifyImages(args, verifyAll)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageSBOM(cmd *cobra.Command, args []string) {
+	err := host.PrintSBOM(args[0], sbomJSON)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageDiffPackages(cmd *cobra.Command, args []string) {
+	err := host.DiffPackages(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageDiff(cmd *cobra.Command, args []string) {
+	err := host.DiffImages(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageTag(cmd *cobra.Command, args []string) {
+	err := host.TagImage(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageUntag(cmd *cobra.Command, args []string) {
+	err := host.UntagImage(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageSign(cmd *cobra.Command, args []string) {
+	err := host.SignImage(args[0], signKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imageKeygen(cmd *cobra.Command, args []string) {
+	privatePath, publicPath, err := platform.GenerateSigningKey(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Private key:", privatePath)
+	fmt.Println("Public key:", publicPath)
+}
+
+func imageTrust(cmd *cobra.Command, args []string) {
+	err := platform.TrustKey(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imagePush(cmd *cobra.Command, args []string) {
+	image := args[0]
+	var remoteName string
+	if len(args) == 2 {
+		remoteName = args[1]
+	} else if strings.Contains(image, ":") {
+		remoteName, image = platform.ParseRemoteName(image)
+	} else {
+		log.Fatalf("no remote provided in %q - expected IMAGE REMOTE or REMOTE:IMAGE", image)
+	}
+
+	err := host.PushImage(image, remoteName)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imagePull(cmd *cobra.Command, args []string) {
+	err := host.PullImage(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func imagePrune(cmd *cobra.Command, args []string) {
+	if pruneOlderThan != "" {
+		age, err := platform.ParseAge(pruneOlderThan)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pruneFilter.OlderThan = age
+	}
+
+	plan, err := host.PlanImagePrune(pruneFilter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	host.PrintImagePrunePlan(plan)
+	if pruneDryRun || plan.Empty() {
+		return
+	}
+
+	if !pruneYes && !confirm("Delete these images?") {
+		return
+	}
+
+	err = host.PruneImages(plan)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// confirm asks the user a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("\n%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
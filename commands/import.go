@@ -9,8 +9,22 @@ import (
 var braveImportImage = &cobra.Command{
 	Use:   "import <file> [<file>...]",
 	Short: "Import LXD image tarballs into local Bravetools image repository",
-	Long:  ``,
-	Run:   importImage,
+	Long: `Import LXD image tarballs into the local Bravetools image repository.
+With --oci, OCI image layouts and docker save archives are converted to LXD images. The environment,
+exposed ports and entrypoint of the image are written to a generated Bravefile service section.`,
+	Example: `  brave import alpine-base_1.0_x86_64.tar.gz
+  docker save nginx:1.25 -o nginx.tar && brave import --oci nginx.tar`,
+	Run: importImage,
+}
+
+var importOCI bool
+var importOCIName string
+var importOCIBravefile string
+
+func init() {
+	braveImportImage.Flags().BoolVar(&importOCI, "oci", false, "Import OCI image layouts or docker save archives")
+	braveImportImage.Flags().StringVar(&importOCIName, "name", "", "Image name to import an OCI image as - defaults to the name and tag of the image")
+	braveImportImage.Flags().StringVar(&importOCIBravefile, "bravefile", "Bravefile", "Path to write the Bravefile generated for an OCI image to - empty to skip")
 }
 
 func importImage(cmd *cobra.Command, args []string) {
@@ -20,8 +34,20 @@ func importImage(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if !importOCI && cmd.Flags().Changed("name") {
+		log.Fatal("--name can only be used with --oci")
+	}
+	if importOCI && importOCIName != "" && len(args) > 1 {
+		log.Fatal("--name can only be used when importing a single OCI image")
+	}
+
 	for _, arg := range args {
-		err := host.ImportLocalImage(arg)
+		var err error
+		if importOCI {
+			err = host.ImportOCIImage(arg, importOCIName, importOCIBravefile)
+		} else {
+			err = host.ImportLocalImage(arg)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
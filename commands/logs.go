@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"log"
+
+	"github.com/bravetools/bravetools/platform"
+	"github.com/spf13/cobra"
+)
+
+var braveLogs = &cobra.Command{
+	Use:   "logs [<remote>:]<instance>",
+	Short: "Show Unit logs",
+	Long: `Show the console log of a Unit, followed by the output of its services.
+Service output is read from the log files set in the logs field of the Unit's service, or from journalctl
+if no log files are configured and the Unit runs systemd.`,
+	Run:               unitLogs,
+	ValidArgsFunction: unitNameCompletion,
+}
+
+var logOptions = platform.LogOptions{}
+
+func init() {
+	includeLogFlags(braveLogs)
+}
+
+func includeLogFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&logOptions.Follow, "follow", "f", false, "Stream new log output until interrupted")
+	cmd.Flags().IntVarP(&logOptions.Tail, "tail", "n", -1, "Number of lines to show from the end of each log - all lines by default")
+}
+
+func unitLogs(cmd *cobra.Command, args []string) {
+	checkBackend()
+	if len(args) != 1 {
+		log.Fatal("missing name - please provide unit name")
+	}
+
+	err := host.UnitLogs(args[0], logOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,11 +7,12 @@ import (
 )
 
 var publishImageNames []string
+var publishCompression string
 
 var bravePublish = &cobra.Command{
 	Use:   "publish <instance> [<instance>...]",
 	Short: "Publish deployed Units as images",
-	Long:  `Published Units will be saved in the current working directory as *.tar.gz file`,
+	Long:  `Published Units will be saved in the current working directory as *.tar.gz file, or with the extension of the selected compression`,
 	Run:   publish,
 	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) != 0 {
@@ -23,6 +24,7 @@ var bravePublish = &cobra.Command{
 
 func init() {
 	bravePublish.Flags().StringSliceVar(&publishImageNames, "image_name", []string{}, "Image names to apply to exported units")
+	bravePublish.Flags().StringVar(&publishCompression, "compression", "", "Image compression: 'gzip', 'xz', 'zstd' or 'none' - defaults to compression in the config [OPTIONAL]")
 }
 
 func publish(cmd *cobra.Command, args []string) {
@@ -31,6 +33,8 @@ func publish(cmd *cobra.Command, args []string) {
 		log.Fatal("missing name - please provide unit name")
 	}
 
+	setCompression(publishCompression)
+
 	for i, name := range args {
 		imageName := ""
 		if i < len(publishImageNames) {
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"log"
+	"os"
+
+	"github.com/bravetools/bravetools/platform"
+	"github.com/bravetools/bravetools/shared"
+	"github.com/spf13/cobra"
+)
+
+var braveRegistry = &cobra.Command{
+	Use:   "registry",
+	Short: "Serve and query bravetools image registries",
+	Long: `A bravetools registry serves an image store over HTTP so images can be shared with push and pull.
+Add a registry as a remote with the bravetools protocol to use it:
+  brave remote add team http://registry.example.com:8700 --protocol bravetools --token TOKEN`,
+}
+
+var braveRegistryServe = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve an image store as a registry",
+	Long: `Serve the local image store, or the image store in --dir, as a bravetools registry.
+If a token is given with --token or the ` + platform.RegistryTokenEnv + ` environment variable, every request must present it
+and images can be pushed. Without a token images are readable by anyone and pushes are refused.
+The registry listens on ` + platform.DefaultRegistryAddress + ` by default - use --listen to serve other hosts.`,
+	Example: `  ` + platform.RegistryTokenEnv + `=secret brave registry serve --dir /srv/brave-images --listen :8700`,
+	Args:    cobra.NoArgs,
+	Run:     registryServe,
+}
+
+var braveRegistrySimplestreams = &cobra.Command{
+	Use:   "simplestreams",
+	Short: "Write a simplestreams index of an image store",
+	Long: `Write streams/v1/index.json and streams/v1/images.json describing the local image store, or the image store in --dir.
+Serving the image store directory with any HTTPS server makes its images available to LXD as a simplestreams remote.
+Indexed image archives are made readable by all users so the web server can read them.
+Run it again after images are added or removed. brave registry serve --public-simplestreams generates the index on the fly instead.`,
+	Example: `  brave registry simplestreams --dir /srv/brave-images
+  lxc remote add brave https://mirror.example.com --protocol simplestreams`,
+	Args: cobra.NoArgs,
+	Run:  registrySimplestreams,
+}
+
+var braveRegistrySearch = &cobra.Command{
+	Use:     "search REMOTE [TERM]",
+	Short:   "List images in a registry",
+	Long:    `List the images of registry REMOTE, or only those whose name contains TERM.`,
+	Example: `  brave registry search team nginx`,
+	Args:    cobra.RangeArgs(1, 2),
+	Run:     registrySearch,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		remoteNames, _ := platform.ListRemotes()
+		return remoteNames, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+var registryDir string
+var registryListen string
+var registryToken string
+var registryTLSCert string
+var registryTLSKey string
+var registryServeSimplestreams bool
+var registryAllowPublic bool
+var registryMaxUploadSize string
+
+func init() {
+	braveRegistry.AddCommand(braveRegistryServe)
+	braveRegistry.AddCommand(braveRegistrySearch)
+	braveRegistry.AddCommand(braveRegistrySimplestreams)
+	braveRegistrySimplestreams.Flags().StringVar(&registryDir, "dir", "", "Image store directory to index - defaults to the local image store [OPTIONAL]")
+	braveRegistryServe.Flags().StringVar(&registryDir, "dir", "", "Image store directory to serve - defaults to the local image store [OPTIONAL]")
+	braveRegistryServe.Flags().StringVar(&registryListen, "listen", platform.DefaultRegistryAddress, "Address to listen on")
+	braveRegistryServe.Flags().StringVar(&registryToken, "token", "", "Token clients must present - defaults to $"+platform.RegistryTokenEnv+" [OPTIONAL]")
+	braveRegistryServe.Flags().StringVar(&registryTLSCert, "tls-cert", "", "Certificate to serve HTTPS with [OPTIONAL]")
+	braveRegistryServe.Flags().StringVar(&registryTLSKey, "tls-key", "", "Key of the HTTPS certificate [OPTIONAL]")
+	braveRegistryServe.Flags().BoolVar(&registryServeSimplestreams, "public-simplestreams", false, "Also serve the images to LXD as a simplestreams remote, readable without a token [OPTIONAL]")
+	braveRegistryServe.Flags().BoolVar(&registryAllowPublic, "allow-public", false, "Confirm that --public-simplestreams makes images readable without the token [OPTIONAL]")
+	braveRegistryServe.Flags().StringVar(&registryMaxUploadSize, "max-upload-size", platform.DefaultRegistryMaxUploadSize, "Largest image archive accepted, e.g. 500MB or 20GB")
+}
+
+func registryServe(cmd *cobra.Command, args []string) {
+	token := registryToken
+	if token == "" {
+		token = os.Getenv(platform.RegistryTokenEnv)
+	}
+
+	maxUploadSize, err := shared.SizeCountToInt(registryMaxUploadSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = platform.ServeRegistry(platform.RegistryConfig{
+		Root:                registryDir,
+		Address:             registryListen,
+		Token:               token,
+		TLSCert:             registryTLSCert,
+		TLSKey:              registryTLSKey,
+		PublicSimplestreams: registryServeSimplestreams,
+		AllowPublic:         registryAllowPublic,
+		MaxUploadSize:       maxUploadSize,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func registrySimplestreams(cmd *cobra.Command, args []string) {
+	err := platform.GenerateSimplestreams(registryDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func registrySearch(cmd *cobra.Command, args []string) {
+	search := ""
+	if len(args) > 1 {
+		search = args[1]
+	}
+
+	err := host.SearchRegistry(args[0], search)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
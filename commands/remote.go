@@ -79,6 +79,27 @@ var remoteListCmd = &cobra.Command{
 	Run:   remoteList,
 }
 
+var remoteSetPolicyCmd = &cobra.Command{
+	Use:   "set-policy NAME POLICY",
+	Short: "Set the image signature policy of a remote",
+	Long: `Set the image signature policy applied when deploying units to remote NAME:
+  permissive - deploy unsigned images and warn about images signed by untrusted keys (default)
+  enforce    - refuse to deploy images that are not signed by a trusted key`,
+	Example: `  brave remote set-policy prod enforce`,
+	Args:    cobra.ExactArgs(2),
+	Run:     remoteSetPolicy,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			remoteNames, _ := platform.ListRemotes()
+			return remoteNames, cobra.ShellCompDirectiveNoFileComp
+		case 1:
+			return []string{platform.SignaturePolicyPermissive, platform.SignaturePolicyEnforce}, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
 var remoteArgs = &platform.Remote{}
 var remotePassword = ""
 
@@ -87,16 +108,19 @@ func init() {
 	remoteCmd.AddCommand(remoteRemoveCmd)
 	remoteCmd.AddCommand(remoteGetCmd)
 	remoteCmd.AddCommand(remoteListCmd)
+	remoteCmd.AddCommand(remoteSetPolicyCmd)
 	includeRemoteAddFlags(remoteAddCmd)
 }
 
 func includeRemoteAddFlags(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&remoteArgs.Protocol, "protocol", "lxd", "LXD server protocol to connect with (e.g. 'lxd', 'simplestreams')")
+	cmd.Flags().StringVar(&remoteArgs.Protocol, "protocol", "lxd", "Protocol to connect with (e.g. 'lxd', 'simplestreams', or 'bravetools' for bravetools registries)")
 	cmd.Flags().BoolVar(&remoteArgs.Public, "public", false, "Publicly available server with no authentication")
 	cmd.Flags().StringVar(&remoteArgs.Profile, "profile", "default", "Name of LXD profile to use with this remote.")
 	cmd.Flags().StringVar(&remoteArgs.Network, "network", "lxdbr0", "LXD-managed bridge to use for networking containers")
 	cmd.Flags().StringVar(&remoteArgs.Storage, "storage", "default", "Name of LXD storage pool to use for container")
 	cmd.Flags().StringVar(&remotePassword, "password", "", "Trusted password to use when communicating with remote")
+	cmd.Flags().StringVar(&remoteArgs.Token, "token", "", "Token to authenticate with a bravetools registry")
+	cmd.Flags().StringVar(&remoteArgs.SignaturePolicy, "signature-policy", "", "Image signature policy for deploys to this remote ('permissive' or 'enforce')")
 }
 
 func remoteAdd(cmd *cobra.Command, args []string) {
@@ -104,11 +128,26 @@ func remoteAdd(cmd *cobra.Command, args []string) {
 	remoteArgs.Name = args[0]
 	remoteArgs.URL = args[1]
 
-	err := platform.SaveRemote(*remoteArgs)
+	err := platform.ValidateSignaturePolicy(remoteArgs.SignaturePolicy)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	err = platform.SaveRemote(*remoteArgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Registries authenticate with a token instead of certs
+	if remoteArgs.Protocol == platform.RegistryProtocol {
+		err = platform.PingRegistry(*remoteArgs)
+		if err != nil {
+			platform.RemoveRemote(remoteArgs.Name)
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Need to generate certs for non-public remotes
 	if !remoteArgs.Public && !(remoteArgs.Protocol == "unix") {
 
@@ -136,6 +175,13 @@ func remoteAdd(cmd *cobra.Command, args []string) {
 	}
 }
 
+func remoteSetPolicy(cmd *cobra.Command, args []string) {
+	err := platform.SetRemoteSignaturePolicy(args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func remoteRemove(cmd *cobra.Command, args []string) {
 	for _, arg := range args {
 		err := platform.RemoveRemote(arg)
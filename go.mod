@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/briandowns/spinner v1.20.0
 	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/lxc/lxd v0.0.0-20230106232521-08767d3fdc67
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/mitchellh/go-ps v1.0.0
@@ -13,6 +14,7 @@ require (
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.5.0 // indirect
+	golang.org/x/sys v0.4.0
 	golang.org/x/tools v0.5.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 	modernc.org/memory v1.5.0 // indirect
@@ -0,0 +1,149 @@
+package platform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// LXD reports kernel architecture names - common distribution names are mapped onto them
+var architectureAliases = map[string]string{
+	"amd64":   "x86_64",
+	"x64":     "x86_64",
+	"arm64":   "aarch64",
+	"armhf":   "armv7l",
+	"arm":     "armv7l",
+	"i386":    "i686",
+	"386":     "i686",
+	"ppc64el": "ppc64le",
+}
+
+// normalizeArchitecture returns the kernel architecture name LXD uses for an architecture
+func normalizeArchitecture(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if kernelArch, ok := architectureAliases[arch]; ok {
+		return kernelArch
+	}
+	return arch
+}
+
+// sameArchitecture reports whether two architecture names refer to the same architecture
+func sameArchitecture(a string, b string) bool {
+	return normalizeArchitecture(a) == normalizeArchitecture(b)
+}
+
+// ParsePlatforms parses a comma-separated list of architectures, removing duplicates
+func ParsePlatforms(platforms string) (archs []string, err error) {
+	seen := make(map[string]bool)
+	for _, arch := range strings.Split(platforms, ",") {
+		arch = normalizeArchitecture(arch)
+		if arch == "" {
+			return nil, fmt.Errorf("invalid platform list %q", platforms)
+		}
+		if seen[arch] {
+			continue
+		}
+		seen[arch] = true
+		archs = append(archs, arch)
+	}
+	return archs, nil
+}
+
+// findBuildRemote returns a remote able to build images for the provided architecture.
+// The preferred remote is used if it matches, otherwise all other configured remotes are tried in name order.
+func findBuildRemote(preferred Remote, arch string) (Remote, error) {
+	candidates := []Remote{preferred}
+
+	names, err := ListRemotes()
+	if err != nil {
+		return Remote{}, err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == preferred.Name {
+			continue
+		}
+		remote, err := LoadRemoteSettings(name)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, remote)
+	}
+
+	for _, remote := range candidates {
+		// Image servers cannot run build units
+		if remote.Public || remote.Protocol == "simplestreams" {
+			continue
+		}
+
+		// Skip unreachable remotes - another remote may still provide the architecture
+		lxdServer, err := GetLXDInstanceServer(remote)
+		if err != nil {
+			continue
+		}
+		remoteArch, err := GetLXDServerArch(lxdServer)
+		if err != nil {
+			continue
+		}
+
+		if sameArchitecture(remoteArch, arch) {
+			return remote, nil
+		}
+	}
+
+	return Remote{}, fmt.Errorf("no reachable remote with architecture %q - add one with `brave remote add`", arch)
+}
+
+// BuildImagePlatforms builds a Bravefile once for each architecture, dispatching each build to a remote of that architecture.
+// All resulting images are stored locally under the same name and version.
+func (bh *BraveHost) BuildImagePlatforms(bravefile shared.Bravefile, archs []string) error {
+	imageString := bravefile.Image
+	if imageString == "" {
+		imageString = bravefile.PlatformService.Image
+	}
+	_, imageString = ParseRemoteName(imageString)
+	if !bravefile.IsLegacy() {
+		image, err := ParseImageString(imageString)
+		if err != nil {
+			return err
+		}
+		if image.Architecture != "" {
+			return fmt.Errorf("image %q sets an architecture - remove it to build for multiple platforms", imageString)
+		}
+	}
+
+	if err := bh.resolveBuildRemote(); err != nil {
+		return err
+	}
+
+	// Resolve all build remotes before building so a missing architecture fails fast
+	remotes := make([]Remote, len(archs))
+	for i, arch := range archs {
+		remote, err := findBuildRemote(bh.Remote, arch)
+		if err != nil {
+			return err
+		}
+		remotes[i] = remote
+	}
+
+	for i, arch := range archs {
+		fmt.Println(shared.Info(fmt.Sprintf("Building %s for %s on remote %s", imageString, arch, remotes[i].Name)))
+
+		archHost := *bh
+		archHost.SelectRemote(remotes[i])
+
+		err := archHost.BuildImage(bravefile)
+		switch errType := err.(type) {
+		case nil:
+		case *ImageExistsError:
+			fmt.Printf("image %q already exists - skipping\n", errType.Name)
+		default:
+			return fmt.Errorf("failed to build %s image: %s", arch, err)
+		}
+	}
+
+	return nil
+}
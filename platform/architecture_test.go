@@ -0,0 +1,31 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	archs, err := ParsePlatforms("amd64, arm64,x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"x86_64", "aarch64"}
+	if !reflect.DeepEqual(archs, expected) {
+		t.Fatalf("expected platforms %v, found %v", expected, archs)
+	}
+
+	if _, err = ParsePlatforms("amd64,,arm64"); err == nil {
+		t.Fatal("expected err for empty platform")
+	}
+}
+
+func TestSameArchitecture(t *testing.T) {
+	if !sameArchitecture("amd64", "x86_64") {
+		t.Error("expected amd64 to match x86_64")
+	}
+	if sameArchitecture("arm64", "x86_64") {
+		t.Error("expected arm64 not to match x86_64")
+	}
+}
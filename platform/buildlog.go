@@ -0,0 +1,238 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+)
+
+const buildLogTimeFormat = "20060102-150405"
+
+// stepRecord is the outcome of a single build step
+type stepRecord struct {
+	description string
+	cached      bool
+	exitCode    int
+	duration    time.Duration
+	sizeDelta   int64
+	err         error
+}
+
+// buildLog captures the full output of a build along with a per-step report in a file under the build log store
+type buildLog struct {
+	path    string
+	file    *os.File
+	output  io.Writer
+	steps   []stepRecord
+	started time.Time
+}
+
+// newBuildLog creates a log file for a build of the provided image
+func newBuildLog(image BravetoolsImage, remote string) (*buildLog, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	logDir := filepath.Join(homeDir, shared.BuildLogStore)
+	err = os.MkdirAll(logDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build log directory: %s", err)
+	}
+
+	started := time.Now()
+	logPath := filepath.Join(logDir, image.ToBasename()+"_"+started.Format(buildLogTimeFormat)+".log")
+
+	f, err := os.Create(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build log: %s", err)
+	}
+
+	l := &buildLog{
+		path:    logPath,
+		file:    f,
+		output:  io.MultiWriter(os.Stdout, f),
+		started: started,
+	}
+
+	fmt.Fprintf(f, "Image: %s\nRemote: %s\nStarted: %s\n\n", image.String(), remote, started.Format(time.RFC3339))
+
+	return l, nil
+}
+
+// beginStep writes a step header to the log
+func (l *buildLog) beginStep(index int, total int, step buildStep) {
+	fmt.Fprintf(l.file, "\n--- Step %d/%d: %s\n", index+1, total, step.description)
+}
+
+// cachedStep records a step restored from the build cache
+func (l *buildLog) cachedStep(step buildStep) {
+	l.steps = append(l.steps, stepRecord{description: step.description, cached: true})
+}
+
+// endStep records the outcome of an executed step
+func (l *buildLog) endStep(step buildStep, duration time.Duration, sizeDelta int64, err error) {
+	record := stepRecord{
+		description: step.description,
+		duration:    duration,
+		sizeDelta:   sizeDelta,
+		err:         err,
+	}
+
+	var exitErr *exitCodeError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		record.exitCode = exitErr.code
+	default:
+		record.exitCode = -1
+	}
+
+	l.steps = append(l.steps, record)
+	fmt.Fprintf(l.file, "--- Exit code %s, took %s, size %s\n", formatExitCode(record), duration.Round(time.Millisecond), formatSizeDelta(sizeDelta))
+}
+
+// failedStep returns the index and record of the step that failed the build, if any
+func (l *buildLog) failedStep() (int, *stepRecord) {
+	for i := range l.steps {
+		if l.steps[i].err != nil {
+			return i, &l.steps[i]
+		}
+	}
+	return -1, nil
+}
+
+// close writes the step report and build result to the log.
+// The step report is also printed to the terminal.
+func (l *buildLog) close(buildErr error) {
+	if len(l.steps) > 0 {
+		fmt.Fprintln(l.output)
+		l.report(l.output)
+	}
+
+	fmt.Fprintf(l.file, "\nFinished: %s (%s)\n", time.Now().Format(time.RFC3339), time.Since(l.started).Round(time.Second))
+	if buildErr != nil {
+		fmt.Fprintf(l.file, "Result: failed: %s\n", buildErr)
+	} else {
+		fmt.Fprintln(l.file, "Result: success")
+	}
+
+	l.file.Close()
+}
+
+// report renders the per-step timing table
+func (l *buildLog) report(w io.Writer) {
+	table := newPlainTable(w, []string{"Step", "Command", "Exit", "Duration", "Size"})
+
+	for i, step := range l.steps {
+		duration := step.duration.Round(time.Millisecond).String()
+		if step.cached {
+			duration = "cached"
+		}
+		r := []string{strconv.Itoa(i + 1), shared.TruncateStringLeft(step.description, 48), formatExitCode(step), duration, formatSizeDelta(step.sizeDelta)}
+		table.Append(r)
+	}
+
+	table.Render()
+}
+
+func formatExitCode(step stepRecord) string {
+	switch {
+	case step.cached:
+		return "-"
+	case step.exitCode < 0:
+		return "error"
+	}
+	return strconv.Itoa(step.exitCode)
+}
+
+func formatSizeDelta(delta int64) string {
+	switch {
+	case delta > 0:
+		return "+" + shared.FormatByteCountSI(delta)
+	case delta < 0:
+		return "-" + shared.FormatByteCountSI(-delta)
+	}
+	return "0 B"
+}
+
+// unitDiskUsage returns the root disk usage of a unit, or 0 if the storage driver does not report it
+func unitDiskUsage(lxdServer lxd.InstanceServer, unitName string) int64 {
+	state, _, err := lxdServer.GetInstanceState(unitName)
+	if err != nil {
+		return 0
+	}
+	return state.Disk["root"].Usage
+}
+
+// latestBuildLog returns the path of the most recent build log for an image
+func latestBuildLog(image BravetoolsImage) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	var fields []string
+	for _, field := range []string{image.Name, image.Version, image.Architecture} {
+		if field == "" {
+			field = "*"
+		}
+		fields = append(fields, field)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(homeDir, shared.BuildLogStore, strings.Join(fields, "_")+"_*.log"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no build logs found for image %q", image.String())
+	}
+
+	// Log names end with the build start time, so the latest build sorts last
+	sort.Slice(matches, func(i, j int) bool {
+		return buildLogTime(matches[i]) < buildLogTime(matches[j])
+	})
+
+	return matches[len(matches)-1], nil
+}
+
+// buildLogTime returns the build start time suffix of a build log path
+func buildLogTime(logPath string) string {
+	name := strings.TrimSuffix(filepath.Base(logPath), ".log")
+	if len(name) < len(buildLogTimeFormat) {
+		return name
+	}
+	return name[len(name)-len(buildLogTimeFormat):]
+}
+
+// PrintBuildLog prints the most recent build log of an image
+func (bh *BraveHost) PrintBuildLog(imageName string) error {
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+
+	logPath, err := latestBuildLog(image)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Build log: %s\n\n", logPath)
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
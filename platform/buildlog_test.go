@@ -0,0 +1,54 @@
+package platform
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+func TestBuildLog(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	image := BravetoolsImage{Name: "cowsay", Version: "1.0", Architecture: "x86_64"}
+	buildLog, err := newBuildLog(image, shared.BravetoolsRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	install := buildStep{description: "apt python3"}
+	run := buildStep{description: "false"}
+	buildLog.cachedStep(install)
+	buildLog.beginStep(1, 2, run)
+	buildLog.endStep(run, time.Second, 1000, &exitCodeError{code: 2, message: "non-zero exit code 2"})
+
+	i, failed := buildLog.failedStep()
+	if failed == nil || i != 1 || failed.exitCode != 2 {
+		t.Fatalf("expected step 2 to fail with exit code 2, found %+v", failed)
+	}
+	buildLog.close(errors.New("build failed"))
+
+	// Lookup without architecture matches the logged build
+	logPath, err := latestBuildLog(BravetoolsImage{Name: "cowsay", Version: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logPath != buildLog.path {
+		t.Fatalf("expected build log %q, found %q", buildLog.path, logPath)
+	}
+	if filepath.Dir(logPath) != filepath.Join(home, shared.BuildLogStore) {
+		t.Fatalf("expected build log under %q, found %q", shared.BuildLogStore, logPath)
+	}
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) == 0 {
+		t.Fatal("expected build log content")
+	}
+}
@@ -0,0 +1,360 @@
+package platform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+)
+
+// Build cache images are stored in the LXD image store of the build remote under an alias derived from the step key
+const buildCachePrefix = "brave-cache-"
+
+// Image properties recording what a build cache image was created from
+const (
+	cacheImageProperty = "bravetools.cache.image"
+	cacheStepProperty  = "bravetools.cache.step"
+)
+
+// buildStep is a single cacheable step of a Bravefile build
+type buildStep struct {
+	description string
+	key         string
+	// cacheable is false for steps that leave processes running in the build unit, which a snapshot would not preserve
+	cacheable bool
+	// checkpoint marks the steps whose result is stored in the build cache
+	checkpoint bool
+	run        func(output io.Writer) error
+}
+
+// buildSteps splits the package, copy and run sections of a Bravefile into build steps.
+// Each step key chains the key of the previous step, starting from the base image fingerprint,
+// so a change to any step invalidates the cache for all steps after it.
+// Every cacheable step is a checkpoint, so a rebuild resumes from the longest unchanged prefix of steps. The final step is
+// never cached since the image itself is published after it.
+func buildSteps(ctx context.Context, lxdServer lxd.InstanceServer, bravefile *shared.Bravefile, baseFingerprint string) (steps []buildStep, err error) {
+	unitName := bravefile.PlatformService.Name
+	key := hashStrings("base", baseFingerprint)
+	cacheable := true
+
+	if bravefile.SystemPackages.Manager != "" {
+		packages := bravefile.SystemPackages
+		key = hashStrings(append([]string{key, "packages", packages.Manager}, packages.System...)...)
+		steps = append(steps, buildStep{
+			description: strings.TrimSpace(packages.Manager + " " + strings.Join(packages.System, " ")),
+			key:         key,
+			cacheable:   cacheable,
+			run: func(output io.Writer) error {
+				return installPackages(ctx, lxdServer, unitName, packages, output)
+			},
+		})
+	}
+
+	dir, _ := os.Getwd()
+	for _, c := range bravefile.Copy {
+		c := c
+
+		sourceHash, err := hashCopySource(filepath.FromSlash(path.Join(dir, c.Source)))
+		if err != nil {
+			return nil, errors.New("failed to read copy source " + c.Source + ": " + err.Error())
+		}
+
+		key = hashStrings(key, "copy", sourceHash, c.Target, c.Action)
+		steps = append(steps, buildStep{
+			description: c.Source + " -> " + c.Target,
+			key:         key,
+			cacheable:   cacheable,
+			run: func(output io.Writer) error {
+				return bravefileCopy(ctx, lxdServer, []shared.CopyCommand{c}, unitName, output)
+			},
+		})
+	}
+
+	for _, r := range bravefile.Run {
+		r := r
+
+		env := make([]string, 0, len(r.Env))
+		for k, v := range r.Env {
+			env = append(env, k+"="+v)
+		}
+		sort.Strings(env)
+
+		fields := []string{key, "run", r.Command, r.Content, strconv.FormatBool(r.Detach)}
+		fields = append(fields, r.Args...)
+		fields = append(fields, env...)
+		key = hashStrings(fields...)
+
+		// Detached processes do not survive a snapshot, so nothing after them can be restored from cache
+		if r.Detach {
+			cacheable = false
+		}
+
+		steps = append(steps, buildStep{
+			description: strings.TrimSpace(strings.Join(append([]string{r.Command}, r.Args...), " ")),
+			key:         key,
+			cacheable:   cacheable,
+			run: func(output io.Writer) error {
+				err := bravefileRun(ctx, lxdServer, []shared.RunCommand{r}, unitName, output)
+				if err != nil {
+					return fmt.Errorf("%s: %w", shared.Fatal("failed to execute command"), err)
+				}
+				return nil
+			},
+		})
+	}
+
+	for i := 0; i < len(steps)-1; i++ {
+		steps[i].checkpoint = steps[i].cacheable
+	}
+
+	return steps, nil
+}
+
+// hashStrings hashes a sequence of fields into a step key
+func hashStrings(fields ...string) string {
+	hasher := sha256.New()
+	for _, field := range fields {
+		// Length-prefix each field so that field boundaries are part of the hash
+		fmt.Fprintf(hasher, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// hashCopySource hashes the names, modes and contents of a file or directory tree
+func hashCopySource(sourcePath string) (string, error) {
+	hasher := sha256.New()
+
+	err := filepath.Walk(sourcePath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourcePath, filePath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%s %o\n", filepath.ToSlash(relPath), info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "-> %s\n", target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err = io.Copy(hasher, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// cacheAlias is the LXD image alias of the build cache image for a step key
+func cacheAlias(key string) string {
+	return buildCachePrefix + key
+}
+
+// findCachedStep returns the index of the last checkpoint step with a cached image on the build server, or -1 if no steps are cached.
+// Step keys chain every step before them, so a cached later checkpoint is valid even if an earlier one was pruned.
+func findCachedStep(lxdServer lxd.InstanceServer, steps []buildStep) (index int, fingerprint string) {
+	index = -1
+	for i, step := range steps {
+		if !step.cacheable {
+			break
+		}
+		if !step.checkpoint {
+			continue
+		}
+		alias, _, err := lxdServer.GetImageAlias(cacheAlias(step.key))
+		if err != nil {
+			continue
+		}
+		index, fingerprint = i, alias.Target
+	}
+	return index, fingerprint
+}
+
+// cacheBuildStep publishes the build unit as a cache image for a completed step.
+// The step is recorded in the image properties, which also keeps cache images distinct from the final published image.
+// Publishing restarts the unit, so this waits for its network to come back before the next step runs.
+func cacheBuildStep(lxdServer lxd.InstanceServer, unitName string, image BravetoolsImage, step buildStep) (fingerprint string, err error) {
+	// The step may already be cached if the build resumed from an earlier checkpoint
+	if alias, _, err := lxdServer.GetImageAlias(cacheAlias(step.key)); err == nil {
+		return alias.Target, nil
+	}
+
+	properties := map[string]string{
+		cacheImageProperty: image.String(),
+		cacheStepProperty:  step.description,
+	}
+
+	fingerprint, err = PublishWithProperties(lxdServer, unitName, cacheAlias(step.key), properties, "")
+	if err != nil {
+		return fingerprint, err
+	}
+
+	_, err = GetUnitAddress(lxdServer, unitName)
+	return fingerprint, err
+}
+
+// launchCachedStep replaces the build unit with one launched from the cache image of a step and waits for its network
+func launchCachedStep(lxdServer lxd.InstanceServer, unitName string, step buildStep, profile string, storage string) error {
+	if _, _, err := lxdServer.GetInstance(unitName); err == nil {
+		if err = DeleteUnit(lxdServer, unitName); err != nil {
+			return err
+		}
+	}
+
+	_, err := LaunchFromImage(lxdServer, lxdServer, cacheAlias(step.key), unitName, profile, storage)
+	if err != nil {
+		return err
+	}
+
+	err = Start(lxdServer, unitName)
+	if err != nil {
+		return err
+	}
+
+	_, err = GetUnitAddress(lxdServer, unitName)
+	return err
+}
+
+// cacheImage is a build cache image stored on a remote
+type cacheImage struct {
+	key         string
+	fingerprint string
+	image       string
+	step        string
+	size        int64
+	created     time.Time
+}
+
+// getCacheImages lists build cache images stored on a remote, newest first
+func getCacheImages(lxdServer lxd.InstanceServer) (cacheImages []cacheImage, err error) {
+	images, err := GetImages(lxdServer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		for _, alias := range img.Aliases {
+			if !strings.HasPrefix(alias.Name, buildCachePrefix) {
+				continue
+			}
+			cacheImages = append(cacheImages, cacheImage{
+				key:         strings.TrimPrefix(alias.Name, buildCachePrefix),
+				fingerprint: img.Fingerprint,
+				image:       img.Properties[cacheImageProperty],
+				step:        img.Properties[cacheStepProperty],
+				size:        img.Size,
+				created:     img.CreatedAt,
+			})
+			break
+		}
+	}
+
+	sort.Slice(cacheImages, func(i, j int) bool {
+		return cacheImages[i].created.After(cacheImages[j].created)
+	})
+
+	return cacheImages, nil
+}
+
+// PrintBuildCache prints the build cache images stored on the host remote
+func (bh *BraveHost) PrintBuildCache() error {
+	lxdServer, err := GetLXDInstanceServer(bh.Remote)
+	if err != nil {
+		return err
+	}
+
+	cacheImages, err := getCacheImages(lxdServer)
+	if err != nil {
+		return fmt.Errorf("failed to list build cache on remote %q: %s", bh.Remote.Name, err)
+	}
+
+	if len(cacheImages) == 0 {
+		fmt.Println("No build cache")
+		return nil
+	}
+
+	table := newPlainTable(os.Stdout, []string{"Key", "Image", "Step", "Created", "Size"})
+
+	var total int64
+	for _, c := range cacheImages {
+		r := []string{c.key[:12], c.image, shared.TruncateStringLeft(c.step, 48), c.created.Format("2006-01-02 15:04:05"), shared.FormatByteCountSI(c.size)}
+		table.Append(r)
+		total += c.size
+	}
+
+	table.Render()
+
+	fmt.Printf("\nTotal: %d cached steps, %s\n", len(cacheImages), shared.FormatByteCountSI(total))
+
+	return nil
+}
+
+// PruneBuildCache deletes build cache images stored on the host remote.
+// If imageName is provided only cache images created while building that image are deleted.
+func (bh *BraveHost) PruneBuildCache(imageName string) error {
+	lxdServer, err := GetLXDInstanceServer(bh.Remote)
+	if err != nil {
+		return err
+	}
+
+	cacheImages, err := getCacheImages(lxdServer)
+	if err != nil {
+		return fmt.Errorf("failed to list build cache on remote %q: %s", bh.Remote.Name, err)
+	}
+
+	if imageName != "" {
+		image, err := ParseImageString(imageName)
+		if err != nil {
+			return err
+		}
+		imageName = image.String()
+	}
+
+	var count int
+	var reclaimed int64
+	for _, c := range cacheImages {
+		if imageName != "" && c.image != imageName && !strings.HasPrefix(c.image, imageName+"/") {
+			continue
+		}
+
+		err = DeleteImageByFingerprint(lxdServer, c.fingerprint)
+		if err != nil {
+			return fmt.Errorf("failed to delete build cache image %q: %s", c.key[:12], err)
+		}
+		count++
+		reclaimed += c.size
+	}
+
+	fmt.Printf("Deleted %d cached steps, reclaimed %s\n", count, shared.FormatByteCountSI(reclaimed))
+
+	return nil
+}
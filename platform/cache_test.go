@@ -0,0 +1,104 @@
+package platform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+func TestBuildStepKeys(t *testing.T) {
+	bravefile := shared.NewBravefile()
+	bravefile.SystemPackages = shared.Packages{Manager: "apt", System: []string{"nginx"}}
+	bravefile.Run = []shared.RunCommand{
+		{Command: "echo", Args: []string{"one"}},
+		{Command: "echo", Args: []string{"two"}},
+		{Command: "echo", Args: []string{"three"}},
+	}
+
+	steps, err := buildSteps(context.Background(), nil, bravefile, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 build steps, found %d", len(steps))
+	}
+
+	// Changing a step changes its key and the keys of all steps after it
+	bravefile.Run[1].Args = []string{"changed"}
+	changed, err := buildSteps(context.Background(), nil, bravefile, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range steps {
+		if (steps[i].key == changed[i].key) != (i < 2) {
+			t.Errorf("unexpected key for step %d after changing step 2", i)
+		}
+	}
+
+	// A different base image invalidates every step
+	rebased, err := buildSteps(context.Background(), nil, bravefile, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebased[0].key == changed[0].key {
+		t.Error("expected base image fingerprint to change the first step key")
+	}
+}
+
+func TestBuildStepsDetach(t *testing.T) {
+	bravefile := shared.NewBravefile()
+	bravefile.Run = []shared.RunCommand{
+		{Command: "echo"},
+		{Command: "server", Detach: true},
+		{Command: "echo"},
+	}
+
+	steps, err := buildSteps(context.Background(), nil, bravefile, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []bool{true, false, false}
+	for i, step := range steps {
+		if step.cacheable != expected[i] {
+			t.Errorf("expected step %d cacheable to be %t", i, expected[i])
+		}
+	}
+}
+
+func TestBuildStepCheckpoints(t *testing.T) {
+	bravefile := shared.NewBravefile()
+	bravefile.SystemPackages = shared.Packages{Manager: "apt", System: []string{"nginx"}}
+	bravefile.Run = []shared.RunCommand{
+		{Command: "echo", Args: []string{"one"}},
+		{Command: "echo", Args: []string{"two"}},
+		{Command: "echo", Args: []string{"three"}},
+	}
+
+	steps, err := buildSteps(context.Background(), nil, bravefile, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every step but the final one is cached - the final step is published as the image itself
+	expected := []bool{true, true, true, false}
+	for i, step := range steps {
+		if step.checkpoint != expected[i] {
+			t.Errorf("expected step %d checkpoint to be %t", i, expected[i])
+		}
+	}
+
+	// Nothing from a detached run command onwards is cached
+	bravefile.Run[1].Detach = true
+	steps, err = buildSteps(context.Background(), nil, bravefile, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []bool{true, true, false, false}
+	for i, step := range steps {
+		if step.checkpoint != expected[i] {
+			t.Errorf("expected step %d checkpoint to be %t with a detached step", i, expected[i])
+		}
+	}
+}
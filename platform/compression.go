@@ -0,0 +1,298 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Compression algorithms of image archives
+const (
+	CompressionGzip  = "gzip"
+	CompressionXz    = "xz"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+	CompressionNone  = "none"
+)
+
+// defaultCompression is used when neither a flag nor the config select a compression algorithm
+const defaultCompression = CompressionGzip
+
+// compressionExtensions maps compression algorithms onto image file extensions
+var compressionExtensions = map[string]string{
+	CompressionGzip:  ".tar.gz",
+	CompressionXz:    ".tar.xz",
+	CompressionZstd:  ".tar.zst",
+	CompressionBzip2: ".tar.bz2",
+	CompressionNone:  ".tar",
+}
+
+// imageExtensions are the file extensions of image archives, plain tar last so it never shadows the others
+var imageExtensions = []string{".tar.gz", ".tgz", ".tar.xz", ".tar.zst", ".tar.bz2", ".tar"}
+
+// ValidateCompression checks that a compression algorithm can be selected for images
+func ValidateCompression(algorithm string) error {
+	switch algorithm {
+	case CompressionGzip, CompressionXz, CompressionZstd, CompressionNone:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q - expected one of gzip, xz, zstd or none", algorithm)
+}
+
+// resolveCompression returns the configured compression algorithm, falling back to the default
+func resolveCompression(algorithm string) (string, error) {
+	if algorithm == "" {
+		return defaultCompression, nil
+	}
+	return algorithm, ValidateCompression(algorithm)
+}
+
+// compressionExtension returns the image file extension of a compression algorithm
+func compressionExtension(algorithm string) string {
+	if extension, ok := compressionExtensions[algorithm]; ok {
+		return extension
+	}
+	return compressionExtensions[defaultCompression]
+}
+
+// imageExtension returns the image archive extension of filename, if it has one
+func imageExtension(filename string) string {
+	for _, extension := range imageExtensions {
+		if strings.HasSuffix(filename, extension) {
+			return extension
+		}
+	}
+	return ""
+}
+
+// trimImageExtension removes the image archive extension from filename
+func trimImageExtension(filename string) string {
+	return strings.TrimSuffix(filename, imageExtension(filename))
+}
+
+// findImageFile returns the image archive named basename with any image extension
+func findImageFile(basename string) (string, error) {
+	for _, extension := range imageExtensions {
+		if _, err := os.Stat(basename + extension); err == nil {
+			return basename + extension, nil
+		}
+	}
+	return "", fmt.Errorf("image file %s not found", basename+".tar.*")
+}
+
+// sniffCompression identifies the compression of a stream from its magic bytes
+func sniffCompression(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return CompressionGzip
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return CompressionXz
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return CompressionZstd
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+// detectCompression identifies the compression of a file from its content
+func detectCompression(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return sniffCompression(magic[:n]), nil
+}
+
+// commandStream closes the pipe of an external compression tool and waits for it to exit
+type commandStream struct {
+	io.Reader
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	// stoppedEarly is set when the caller did not read the decompressed content to the end
+	stoppedEarly bool
+}
+
+// Close reports the exit error of the tool. When decompressing, the rest of the output is read first so that the
+// tool checks the whole stream, unless the caller stopped reading early.
+func (s *commandStream) Close() error {
+	var err error
+	if s.WriteCloser != nil {
+		err = s.WriteCloser.Close()
+	} else {
+		if !s.stoppedEarly {
+			_, err = io.Copy(ioutil.Discard, s.Reader)
+		}
+		if closer, ok := s.Reader.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	waitErr := s.cmd.Wait()
+	if err == nil && waitErr != nil && !s.stoppedEarly {
+		err = fmt.Errorf("%s: %s %s", s.cmd.Args[0], waitErr, strings.TrimSpace(s.stderr.String()))
+	}
+	return err
+}
+
+func (s *commandStream) stopReading() {
+	s.stoppedEarly = true
+}
+
+// earlyStopper is implemented by decompressors that fail when their content is not read to the end
+type earlyStopper interface {
+	stopReading()
+}
+
+// stopReading marks a closer returned by decompressReader or openTar as abandoned before the end of its content,
+// so that closing it does not report the decompressor being cut off
+func stopReading(closer io.Closer) {
+	if stopper, ok := closer.(earlyStopper); ok {
+		stopper.stopReading()
+	}
+}
+
+// closeArchive closes a decompressor, setting err to its error unless err is already set.
+// A decompressor abandoned because of an earlier error is not read to the end.
+func closeArchive(closer io.Closer, err *error) {
+	if *err != nil {
+		stopReading(closer)
+	}
+	if closeErr := closer.Close(); *err == nil {
+		*err = closeErr
+	}
+}
+
+// compressionCommand starts the external tool used for xz and zstd, which have no implementation in the standard library
+func compressionCommand(algorithm string, decompress bool) (*exec.Cmd, error) {
+	args := []string{"-c", "-T0"}
+	if decompress {
+		args = []string{"-dc"}
+	}
+
+	tool, err := exec.LookPath(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%s compression requires the %s command: %s", algorithm, algorithm, err)
+	}
+	return exec.Command(tool, args...), nil
+}
+
+// decompressReader returns a reader of the decompressed content of r. The returned closer releases any external tool.
+func decompressReader(r io.Reader) (io.Reader, io.Closer, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(6)
+
+	switch algorithm := sniffCompression(magic); algorithm {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, gz, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(reader), io.NopCloser(nil), nil
+	case CompressionXz, CompressionZstd:
+		cmd, err := compressionCommand(algorithm, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd.Stdin = reader
+		stream := &commandStream{cmd: cmd}
+		cmd.Stderr = &stream.stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		stream.Reader = stdout
+		return stream, stream, nil
+	}
+
+	return reader, io.NopCloser(nil), nil
+}
+
+// compressWriter returns a writer that compresses into w. Closing it flushes the compressed stream but does not close w.
+func compressWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionXz, CompressionZstd:
+		cmd, err := compressionCommand(algorithm, false)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdout = w
+		stream := &commandStream{cmd: cmd}
+		cmd.Stderr = &stream.stderr
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, err
+		}
+		stream.WriteCloser = stdin
+		return stream, nil
+	}
+	return nil, ValidateCompression(algorithm)
+}
+
+// recompressImage writes the image archive at srcPath to destPath using the requested compression algorithm
+func recompressImage(srcPath string, destPath string, algorithm string) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	content, closer, err := decompressReader(src)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(closer, &err)
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := dest.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(destPath)
+		}
+	}()
+
+	w, err := compressWriter(dest, algorithm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, content); err != nil {
+		w.Close()
+		return errors.New("failed to compress image: " + err.Error())
+	}
+	return w.Close()
+}
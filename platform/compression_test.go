@@ -0,0 +1,138 @@
+package platform
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageExtensions(t *testing.T) {
+	cases := map[string]string{
+		"alpine_3.18_x86_64.tar.gz":  "alpine_3.18_x86_64",
+		"alpine_3.18_x86_64.tar.xz":  "alpine_3.18_x86_64",
+		"alpine_3.18_x86_64.tar.zst": "alpine_3.18_x86_64",
+		"alpine_3.18_x86_64.tar":     "alpine_3.18_x86_64",
+		"alpine_3.18_x86_64":         "alpine_3.18_x86_64",
+	}
+	for filename, expected := range cases {
+		if trimmed := trimImageExtension(filename); trimmed != expected {
+			t.Errorf("trimImageExtension(%q) = %q, expected %q", filename, trimmed, expected)
+		}
+
+		image, err := ImageFromFilename(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.Version != "3.18" || image.Architecture != "x86_64" {
+			t.Errorf("unexpected image %+v parsed from %q", image, filename)
+		}
+	}
+
+	if compressionExtension(CompressionZstd) != ".tar.zst" || compressionExtension(CompressionNone) != ".tar" {
+		t.Fatal("unexpected compression extensions")
+	}
+	if err := ValidateCompression("lz4"); err == nil {
+		t.Fatal("expected unknown compression to be rejected")
+	}
+}
+
+func TestRecompressImage(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "app.tar")
+	if err := os.WriteFile(source, writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: x86_64\n"},
+		{"rootfs/", tar.TypeDir, ""},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	algorithms := []string{CompressionGzip, CompressionNone}
+	for _, algorithm := range []string{CompressionXz, CompressionZstd} {
+		if _, err := exec.LookPath(algorithm); err == nil {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	for _, algorithm := range algorithms {
+		dest := filepath.Join(dir, "app-"+algorithm+compressionExtension(algorithm))
+		if err := recompressImage(source, dest, algorithm); err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+
+		detected, err := detectCompression(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected != algorithm {
+			t.Errorf("expected %s compression, detected %s", algorithm, detected)
+		}
+
+		tr, closer, err := openTar(dest)
+		if err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", algorithm, err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if err = closer.Close(); err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		if len(names) != 2 || names[0] != "metadata.yaml" {
+			t.Errorf("%s: unexpected entries %v", algorithm, names)
+		}
+	}
+}
+
+func TestCorruptXzArchive(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is not installed")
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "app.tar")
+	if err := os.WriteFile(source, writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: x86_64\n"},
+		{"rootfs/", tar.TypeDir, ""},
+		{"rootfs/etc/config", tar.TypeReg, "config"},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "app.tar.xz")
+	if err := recompressImage(source, archive, CompressionXz); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the index after the compressed data, so that the whole tar stream decompresses before xz fails
+	content, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-16] ^= 0xff
+	if err = os.WriteFile(archive, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = readImageFiles(archive); err == nil {
+		t.Error("expected reading the whole corrupt archive to fail")
+	}
+	if _, err = checkImageArchive(archive); err == nil {
+		t.Error("expected corrupt archive to fail verification")
+	}
+
+	// Callers that stop reading early do not see the failure of the cut off decompressor
+	metadata, err := readImageMetadata(archive)
+	if err != nil || metadata.Architecture != "x86_64" {
+		t.Errorf("expected metadata to be read, found %+v (%v)", metadata, err)
+	}
+}
@@ -0,0 +1,372 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+	lxdshared "github.com/lxc/lxd/shared"
+)
+
+// Kinds of entries that can be copied, named as in the LXD file API
+const (
+	copyKindFile      = "file"
+	copyKindDirectory = "directory"
+	copyKindSymlink   = "symlink"
+)
+
+// errUnsupportedCopyType is returned for entries such as sockets and devices, which are skipped when copying
+var errUnsupportedCopyType = errors.New("not a file, directory or symlink")
+
+// copyEntry describes a file, directory or symlink being copied
+type copyEntry struct {
+	kind string
+	mode os.FileMode
+	// uid and gid are -1 if the owner is unknown
+	uid int64
+	gid int64
+	// target is the destination of a symlink
+	target string
+	// entries are the names in a directory
+	entries []string
+}
+
+// copyFS reads and writes entries on the host or in a unit
+type copyFS interface {
+	// read returns the entry at a path, with the content of files. Content must be closed by the caller.
+	read(name string) (entry copyEntry, content io.ReadCloser, err error)
+	// write creates an entry at a path, replacing existing files
+	write(name string, entry copyEntry, content io.Reader) error
+	join(dir string, name string) string
+	base(name string) string
+	String() string
+}
+
+// parseCopyLocation splits a [remote:]unit:/path argument into its unit and path. Arguments without a unit are host paths.
+func parseCopyLocation(location string) (unitName string, filePath string, err error) {
+	split := strings.SplitN(location, ":", 3)
+
+	// Windows drive letters are not unit names
+	if len(split) == 1 || runtime.GOOS == "windows" && len(split[0]) == 1 {
+		return "", location, nil
+	}
+
+	filePath = split[len(split)-1]
+	unitName = strings.Join(split[:len(split)-1], ":")
+	if unitName == "" || strings.HasSuffix(unitName, ":") {
+		return "", "", fmt.Errorf("invalid location %q - expected a host path or [remote:]unit:/path", location)
+	}
+	if !strings.HasPrefix(filePath, "/") {
+		return "", "", fmt.Errorf("path %q in unit %q must be absolute", filePath, unitName)
+	}
+	return unitName, path.Clean(filePath), nil
+}
+
+// CopyPath copies a file or directory between the host and a unit, or between two units. Either side can be a host path
+// or [remote:]unit:/path. Directories are copied recursively and mode and ownership are preserved. If the destination is
+// an existing directory the source is copied into it, otherwise it is copied to the destination path.
+func (bh *BraveHost) CopyPath(src string, dst string) error {
+	srcFS, srcPath, err := bh.openCopyFS(src)
+	if err != nil {
+		return err
+	}
+	dstFS, dstPath, err := bh.openCopyFS(dst)
+	if err != nil {
+		return err
+	}
+	if _, isHost := srcFS.(hostCopyFS); isHost {
+		if _, isHost = dstFS.(hostCopyFS); isHost {
+			return errors.New("source or destination must be in a unit - use [remote:]unit:/path")
+		}
+	}
+
+	srcEntry, content, err := srcFS.read(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %s", src, err)
+	}
+
+	// Copy into an existing directory
+	if dstEntry, dstContent, err := dstFS.read(dstPath); err == nil {
+		if dstContent != nil {
+			dstContent.Close()
+		}
+		switch {
+		case dstEntry.kind == copyKindDirectory:
+			dstPath = dstFS.join(dstPath, srcFS.base(srcPath))
+		case srcEntry.kind == copyKindDirectory:
+			return fmt.Errorf("cannot copy directory %q over file %q", src, dst)
+		}
+	}
+
+	// The source was read already, so its content is streamed without reading it again
+	stats := &copyStats{}
+	err = writeTree(srcFS, srcPath, srcEntry, content, dstFS, dstPath, stats)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d files (%s) from %s to %s\n", stats.files, shared.FormatByteCountSI(stats.bytes), src, dst)
+	return nil
+}
+
+// openCopyFS returns the file system of a copy location and the path within it
+func (bh *BraveHost) openCopyFS(location string) (copyFS, string, error) {
+	unitName, filePath, err := parseCopyLocation(location)
+	if err != nil {
+		return nil, "", err
+	}
+	if unitName == "" {
+		return hostCopyFS{}, filePath, nil
+	}
+
+	lxdServer, unit, err := bh.connectUnit(unitName, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return unitCopyFS{lxdServer: lxdServer, unit: unit, name: unitName}, filePath, nil
+}
+
+type copyStats struct {
+	files int
+	bytes int64
+}
+
+// copyTree copies an entry and, for directories, everything below it. File content is streamed from source to destination.
+func copyTree(srcFS copyFS, srcPath string, dstFS copyFS, dstPath string, stats *copyStats) error {
+	entry, content, err := srcFS.read(srcPath)
+	if err == errUnsupportedCopyType {
+		fmt.Printf("Skipping %s%s: %s\n", srcFS.String(), srcPath, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s%s: %s", srcFS.String(), srcPath, err)
+	}
+
+	return writeTree(srcFS, srcPath, entry, content, dstFS, dstPath, stats)
+}
+
+// writeTree writes an entry read from srcPath, closing its content, then copies everything below it for directories
+func writeTree(srcFS copyFS, srcPath string, entry copyEntry, content io.ReadCloser, dstFS copyFS, dstPath string, stats *copyStats) error {
+	var err error
+	if content != nil {
+		defer content.Close()
+		counter := &countingReader{reader: content}
+		err = dstFS.write(dstPath, entry, counter)
+		stats.bytes += counter.count
+	} else {
+		err = dstFS.write(dstPath, entry, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s%s: %s", dstFS.String(), dstPath, err)
+	}
+	if entry.kind != copyKindDirectory {
+		stats.files++
+		return nil
+	}
+
+	for _, name := range entry.entries {
+		err = copyTree(srcFS, srcFS.join(srcPath, name), dstFS, dstFS.join(dstPath, name), stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// hostCopyFS copies to and from the local file system
+type hostCopyFS struct{}
+
+func (hostCopyFS) read(name string) (entry copyEntry, content io.ReadCloser, err error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return entry, nil, err
+	}
+
+	mode, uid, gid := lxdshared.GetOwnerMode(info)
+	entry = copyEntry{mode: mode.Perm(), uid: int64(uid), gid: int64(gid)}
+
+	switch {
+	case info.IsDir():
+		entry.kind = copyKindDirectory
+		files, err := ioutil.ReadDir(name)
+		if err != nil {
+			return entry, nil, err
+		}
+		for _, file := range files {
+			entry.entries = append(entry.entries, file.Name())
+		}
+	case mode&os.ModeSymlink != 0:
+		entry.kind = copyKindSymlink
+		entry.target, err = os.Readlink(name)
+		if err != nil {
+			return entry, nil, err
+		}
+	case mode.IsRegular():
+		entry.kind = copyKindFile
+		content, err = os.Open(name)
+		if err != nil {
+			return entry, nil, err
+		}
+	default:
+		return entry, nil, errUnsupportedCopyType
+	}
+
+	return entry, content, nil
+}
+
+func (hostCopyFS) write(name string, entry copyEntry, content io.Reader) error {
+	switch entry.kind {
+	case copyKindDirectory:
+		err := os.Mkdir(name, entry.mode)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	case copyKindSymlink:
+		if info, err := os.Lstat(name); err == nil && !info.IsDir() {
+			if err = os.Remove(name); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(entry.target, name); err != nil {
+			return err
+		}
+		chownHost(name, entry)
+		return nil
+	default:
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.mode)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, content); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+
+	// Apply the mode regardless of umask, then the owner where permitted
+	if err := os.Chmod(name, entry.mode); err != nil {
+		return err
+	}
+	chownHost(name, entry)
+	return nil
+}
+
+// chownHost sets the owner of a copied entry. Only privileged users can give files away, so failures are ignored.
+func chownHost(name string, entry copyEntry) {
+	if entry.uid >= 0 && entry.gid >= 0 {
+		os.Lchown(name, int(entry.uid), int(entry.gid))
+	}
+}
+
+func (hostCopyFS) join(dir string, name string) string {
+	return filepath.Join(dir, name)
+}
+
+func (hostCopyFS) base(name string) string {
+	return filepath.Base(name)
+}
+
+func (hostCopyFS) String() string {
+	return ""
+}
+
+// unitCopyFS copies to and from a unit through the LXD file API
+type unitCopyFS struct {
+	lxdServer lxd.InstanceServer
+	unit      string
+	// name is the [remote:]unit name the unit was given as
+	name string
+}
+
+func (fs unitCopyFS) read(name string) (entry copyEntry, content io.ReadCloser, err error) {
+	content, resp, err := fs.lxdServer.GetInstanceFile(fs.unit, name)
+	if err != nil {
+		return entry, nil, err
+	}
+
+	entry = copyEntry{kind: resp.Type, mode: os.FileMode(resp.Mode).Perm(), uid: resp.UID, gid: resp.GID}
+	switch resp.Type {
+	case copyKindDirectory:
+		content.Close()
+		entry.entries = append(entry.entries, resp.Entries...)
+		sort.Strings(entry.entries)
+		return entry, nil, nil
+	case copyKindSymlink:
+		target, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return entry, nil, err
+		}
+		entry.target = string(target)
+		return entry, nil, nil
+	case copyKindFile:
+		return entry, content, nil
+	default:
+		content.Close()
+		return entry, nil, errUnsupportedCopyType
+	}
+}
+
+func (fs unitCopyFS) write(name string, entry copyEntry, content io.Reader) error {
+	args := lxd.InstanceFileArgs{
+		UID:       entry.uid,
+		GID:       entry.gid,
+		Mode:      int(entry.mode),
+		Type:      entry.kind,
+		WriteMode: "overwrite",
+	}
+
+	switch entry.kind {
+	case copyKindSymlink:
+		args.Content = strings.NewReader(entry.target)
+	case copyKindFile:
+		args.Content = streamReadSeeker{content}
+	}
+
+	return fs.lxdServer.CreateInstanceFile(fs.unit, name, args)
+}
+
+func (unitCopyFS) join(dir string, name string) string {
+	return path.Join(dir, name)
+}
+
+func (unitCopyFS) base(name string) string {
+	return path.Base(name)
+}
+
+func (fs unitCopyFS) String() string {
+	return fs.name + ":"
+}
+
+// streamReadSeeker passes a stream to the LXD file API, which only reads its content
+type streamReadSeeker struct {
+	io.Reader
+}
+
+func (streamReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("stream is not seekable")
+}
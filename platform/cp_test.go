@@ -0,0 +1,79 @@
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCopyLocation(t *testing.T) {
+	cases := []struct {
+		location string
+		unit     string
+		path     string
+	}{
+		{"./config.yaml", "", "./config.yaml"},
+		{"web:/etc/nginx/", "web", "/etc/nginx"},
+		{"prod:web:/var/log/../www", "prod:web", "/var/www"},
+	}
+	for _, c := range cases {
+		unit, filePath, err := parseCopyLocation(c.location)
+		if err != nil {
+			t.Errorf("parseCopyLocation(%q): %s", c.location, err)
+			continue
+		}
+		if unit != c.unit || filePath != c.path {
+			t.Errorf("parseCopyLocation(%q) = %q, %q, expected %q, %q", c.location, unit, filePath, c.unit, c.path)
+		}
+	}
+
+	for _, location := range []string{"web:etc/hosts", ":/etc/hosts", "prod::/etc/hosts"} {
+		if _, _, err := parseCopyLocation(location); err == nil {
+			t.Errorf("expected %q to be rejected", location)
+		}
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "conf", "sites"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "conf", "sites", "default"), []byte("listen 80;\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "conf", "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sites/default", filepath.Join(src, "conf", "current")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "copy")
+	stats := &copyStats{}
+	err := copyTree(hostCopyFS{}, filepath.Join(src, "conf"), hostCopyFS{}, dst, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 3 || stats.bytes != 21 {
+		t.Errorf("expected 3 files of 21 bytes, got %d files of %d bytes", stats.files, stats.bytes)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "sites", "default"))
+	if err != nil || string(content) != "listen 80;\n" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+	for name, mode := range map[string]os.FileMode{"sites": 0750, "sites/default": 0640, "run.sh": 0755} {
+		info, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("expected %s to have mode %o, got %o", name, mode, info.Mode().Perm())
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "current")); err != nil || target != "sites/default" {
+		t.Errorf("unexpected symlink target %q: %v", target, err)
+	}
+}
@@ -0,0 +1,442 @@
+package platform
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+)
+
+// File kinds compared by filesystem diffs
+const (
+	fileKindRegular = 'f'
+	fileKindDir     = 'd'
+	fileKindSymlink = 'l'
+	fileKindChar    = 'c'
+	fileKindBlock   = 'b'
+	fileKindFifo    = 'p'
+	fileKindSocket  = 's'
+)
+
+// unitDiffExcluded are kernel and runtime filesystems of a unit that are never compared with its image
+var unitDiffExcluded = []string{"dev", "proc", "sys", "run", "tmp"}
+
+// unitStatFormat lists a file as raw mode, owner, group, size, modification time and path, separated by tabs
+const unitStatFormat = "%f\t%u\t%g\t%s\t%Y\t%n"
+
+// fileInfo describes a file of an image rootfs or unit filesystem
+type fileInfo struct {
+	kind    byte
+	perm    int64
+	uid     int
+	gid     int
+	size    int64
+	modTime int64
+	// digest of the content of regular files - only known for files read from image archives
+	digest string
+	link   string
+}
+
+// fileChange is a file added (+), removed (-) or changed (~) between two filesystems
+type fileChange struct {
+	path    string
+	op      byte
+	old     *fileInfo
+	new     *fileInfo
+	details []string
+}
+
+// tarFileKind maps a tar entry type onto a file kind
+func tarFileKind(typeflag byte) byte {
+	switch typeflag {
+	case tar.TypeDir:
+		return fileKindDir
+	case tar.TypeSymlink:
+		return fileKindSymlink
+	case tar.TypeChar:
+		return fileKindChar
+	case tar.TypeBlock:
+		return fileKindBlock
+	case tar.TypeFifo:
+		return fileKindFifo
+	}
+	return fileKindRegular
+}
+
+// modeFileKind maps the file type bits of a raw stat mode onto a file kind
+func modeFileKind(mode uint64) byte {
+	switch mode & 0170000 {
+	case 0040000:
+		return fileKindDir
+	case 0120000:
+		return fileKindSymlink
+	case 0020000:
+		return fileKindChar
+	case 0060000:
+		return fileKindBlock
+	case 0010000:
+		return fileKindFifo
+	case 0140000:
+		return fileKindSocket
+	}
+	return fileKindRegular
+}
+
+// readImageFiles lists the rootfs of an image archive by streaming it, hashing the content of regular files
+func readImageFiles(imagePath string) (files map[string]fileInfo, err error) {
+	tr, closer, err := openTar(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeArchive(closer, &err)
+
+	files = map[string]fileInfo{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, ok := cleanArchivePath(hdr.Name)
+		if !ok || !strings.HasPrefix(name, "rootfs/") {
+			continue
+		}
+		name = strings.TrimPrefix(name, "rootfs/")
+
+		// Hard links share the content of the file they link to
+		if hdr.Typeflag == tar.TypeLink {
+			target, _ := cleanArchivePath(hdr.Linkname)
+			if info, ok := files[strings.TrimPrefix(target, "rootfs/")]; ok {
+				files[name] = info
+			}
+			continue
+		}
+
+		info := fileInfo{
+			kind:    tarFileKind(hdr.Typeflag),
+			perm:    hdr.Mode & 07777,
+			uid:     hdr.Uid,
+			gid:     hdr.Gid,
+			size:    hdr.Size,
+			modTime: hdr.ModTime.Unix(),
+			link:    hdr.Linkname,
+		}
+		if info.kind == fileKindSymlink {
+			info.size = int64(len(hdr.Linkname))
+		}
+
+		if info.kind == fileKindRegular {
+			hasher := sha256.New()
+			if _, err = io.Copy(hasher, tr); err != nil {
+				return nil, err
+			}
+			info.digest = hex.EncodeToString(hasher.Sum(nil))
+		}
+
+		files[name] = info
+	}
+}
+
+// parseUnitFiles reads the output of stat with unitStatFormat. Records are NUL separated, or newline separated
+// if stat could not write NUL characters. Records in any other format are skipped.
+func parseUnitFiles(output string) map[string]fileInfo {
+	files := map[string]fileInfo{}
+
+	separator := "\n"
+	if strings.Contains(output, "\x00") {
+		separator = "\x00"
+	}
+	for _, record := range strings.Split(output, separator) {
+		// Drop output such as the echoed command that precedes the first record
+		if tab := strings.IndexByte(record, '\t'); tab >= 0 {
+			record = record[strings.LastIndexByte(record[:tab], '\n')+1:]
+		}
+
+		fields := strings.SplitN(record, "\t", 6)
+		if len(fields) != 6 {
+			continue
+		}
+
+		mode, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			continue
+		}
+		uid, err1 := strconv.Atoi(fields[1])
+		gid, err2 := strconv.Atoi(fields[2])
+		size, err3 := strconv.ParseInt(fields[3], 10, 64)
+		modTime, err4 := strconv.ParseInt(fields[4], 10, 64)
+		if shared.CollectErrors(err1, err2, err3, err4) != nil {
+			continue
+		}
+
+		name, ok := cleanArchivePath(fields[5])
+		if !ok {
+			continue
+		}
+
+		files[name] = fileInfo{
+			kind:    modeFileKind(mode),
+			perm:    int64(mode & 07777),
+			uid:     uid,
+			gid:     gid,
+			size:    size,
+			modTime: modTime,
+		}
+	}
+
+	return files
+}
+
+// unitFiles lists the root filesystem of a running unit, skipping kernel and runtime filesystems. Paths are passed
+// NUL separated so that names containing newlines are listed intact, falling back to one line per file where
+// stat cannot write NUL characters, as with busybox.
+func unitFiles(ctx context.Context, lxdServer lxd.InstanceServer, unitName string) (map[string]fileInfo, error) {
+	var prune []string
+	for _, dir := range unitDiffExcluded {
+		prune = append(prune, "-path /"+dir)
+	}
+	find := fmt.Sprintf(`find / -xdev \( %s \) -prune -o`, strings.Join(prune, " -o "))
+	script := fmt.Sprintf(`if stat --printf '' / >/dev/null 2>&1; then %s -print0 2>/dev/null | xargs -0 stat --printf '%s\0'; else %s -exec stat -c '%s' {} + 2>/dev/null; fi`,
+		find, unitStatFormat, find, unitStatFormat)
+
+	var output bytes.Buffer
+	status, err := Exec(ctx, lxdServer, unitName, []string{"sh", "-c", script}, ExecArgs{output: &output})
+	if err != nil {
+		return nil, err
+	}
+
+	files := parseUnitFiles(output.String())
+	if len(files) == 0 {
+		return nil, fmt.Errorf("failed to list files in unit %q (exit code %d)", unitName, status)
+	}
+	return files, nil
+}
+
+// excludeUnitPaths removes paths below the filesystems not compared for units
+func excludeUnitPaths(files map[string]fileInfo) {
+	for name := range files {
+		for _, dir := range unitDiffExcluded {
+			if name == dir || strings.HasPrefix(name, dir+"/") {
+				delete(files, name)
+				break
+			}
+		}
+	}
+}
+
+// diffFiles compares two filesystems. Regular files are compared by content when both digests are known,
+// otherwise by size and modification time.
+func diffFiles(a map[string]fileInfo, b map[string]fileInfo) (changes []fileChange) {
+	for name, oldInfo := range a {
+		oldInfo := oldInfo
+		newInfo, ok := b[name]
+		if !ok {
+			changes = append(changes, fileChange{path: name, op: '-', old: &oldInfo})
+			continue
+		}
+
+		if details := compareFiles(oldInfo, newInfo); len(details) > 0 {
+			changes = append(changes, fileChange{path: name, op: '~', old: &oldInfo, new: &newInfo, details: details})
+		}
+	}
+
+	for name, newInfo := range b {
+		newInfo := newInfo
+		if _, ok := a[name]; !ok {
+			changes = append(changes, fileChange{path: name, op: '+', new: &newInfo})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	return changes
+}
+
+func compareFiles(a fileInfo, b fileInfo) (details []string) {
+	if a.kind != b.kind {
+		return []string{fmt.Sprintf("type %c -> %c", a.kind, b.kind)}
+	}
+
+	if a.perm != b.perm {
+		details = append(details, fmt.Sprintf("mode %04o -> %04o", a.perm, b.perm))
+	}
+	if a.uid != b.uid || a.gid != b.gid {
+		details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", a.uid, a.gid, b.uid, b.gid))
+	}
+
+	switch a.kind {
+	case fileKindRegular:
+		if a.digest != "" && b.digest != "" {
+			if a.digest != b.digest {
+				details = append(details, "content")
+			}
+		} else if a.size != b.size || a.modTime != b.modTime {
+			details = append(details, "content")
+		}
+	case fileKindSymlink:
+		if a.link != "" && b.link != "" {
+			if a.link != b.link {
+				details = append(details, "target "+a.link+" -> "+b.link)
+			}
+		} else if a.size != b.size || a.modTime != b.modTime {
+			details = append(details, "target")
+		}
+	}
+
+	return details
+}
+
+// printFileChanges prints filesystem changes with their sizes followed by a summary
+func printFileChanges(changes []fileChange) {
+	if len(changes) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+
+	var added, removed, changed int
+	var addedSize, removedSize int64
+
+	table := newPlainTable(os.Stdout, []string{"", "Path", "Size", "Change"})
+	for _, change := range changes {
+		var size string
+		switch change.op {
+		case '+':
+			added++
+			addedSize += change.new.size
+			size = shared.FormatByteCountSI(change.new.size)
+		case '-':
+			removed++
+			removedSize += change.old.size
+			size = shared.FormatByteCountSI(change.old.size)
+		case '~':
+			changed++
+			size = shared.FormatByteCountSI(change.new.size)
+			if change.old.size != change.new.size {
+				size = shared.FormatByteCountSI(change.old.size) + " -> " + size
+			}
+		}
+		table.Append([]string{string(change.op), "/" + change.path, size, strings.Join(change.details, ", ")})
+	}
+	table.Render()
+
+	fmt.Printf("\n%d added (%s), %d removed (%s), %d changed\n", added, shared.FormatByteCountSI(addedSize), removed, shared.FormatByteCountSI(removedSize), changed)
+}
+
+// DiffImages prints the files added, removed and changed in the rootfs of image b compared to image a
+func (bh *BraveHost) DiffImages(a string, b string) error {
+	var files [2]map[string]fileInfo
+	for i, name := range []string{a, b} {
+		image, err := ParseImageString(name)
+		if err != nil {
+			return err
+		}
+
+		_, imagePath, err := resolveLocalImage(image)
+		if err != nil {
+			return err
+		}
+
+		files[i], err = readImageFiles(imagePath)
+		if err != nil {
+			return fmt.Errorf("failed to read image %q: %s", name, err)
+		}
+	}
+
+	printFileChanges(diffFiles(files[0], files[1]))
+	return nil
+}
+
+// unitImagePath finds the archive of the image a unit was deployed from. Units are matched with the unit database
+// by name only if LXD did not record their base image, which is only done for units on the local remote
+// as the database does not record remotes.
+func unitImagePath(lxdServer lxd.InstanceServer, remoteName string, unitName string) (string, error) {
+	instance, _, err := lxdServer.GetInstance(unitName)
+	if err != nil {
+		return "", err
+	}
+	if instance.Status != "Running" {
+		return "", fmt.Errorf("unit %q is not running", unitName)
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return "", err
+	}
+
+	// Units launched from the image store record the digest of the image as their base image fingerprint
+	if fingerprint := instance.Config["volatile.base_image"]; fingerprint != "" {
+		if store.references(digestPrefix+fingerprint) > 0 {
+			return store.blobPath(digestPrefix + fingerprint), nil
+		}
+		return "", fmt.Errorf("image %s unit %q was deployed from is not in the local image store", shortDigest(digestPrefix+fingerprint), unitName)
+	}
+	if remoteName != shared.BravetoolsRemote {
+		return "", fmt.Errorf("image unit %q was deployed from is not known", unitName)
+	}
+
+	units, err := getDatabaseUnits()
+	if err != nil {
+		return "", err
+	}
+	for _, unit := range units {
+		if unit.Name != unitName {
+			continue
+		}
+		image, err := ParseImageString(unit.Data.Image)
+		if err != nil {
+			return "", err
+		}
+		_, imagePath, err := resolveLocalImage(image)
+		return imagePath, err
+	}
+
+	return "", fmt.Errorf("image unit %q was deployed from is not in the local image store", unitName)
+}
+
+// DiffUnit prints the files added, removed and changed in a running unit compared to the image it was deployed from
+func (bh *BraveHost) DiffUnit(name string) error {
+	remoteName, unitName := ParseRemoteName(name)
+
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return err
+	}
+
+	lxdServer, err := GetLXDInstanceServer(remote)
+	if err != nil {
+		return err
+	}
+
+	imagePath, err := unitImagePath(lxdServer, remoteName, unitName)
+	if err != nil {
+		return err
+	}
+
+	imageFiles, err := readImageFiles(imagePath)
+	if err != nil {
+		return err
+	}
+	excludeUnitPaths(imageFiles)
+
+	currentFiles, err := unitFiles(context.Background(), lxdServer, unitName)
+	if err != nil {
+		return err
+	}
+
+	printFileChanges(diffFiles(imageFiles, currentFiles))
+	return nil
+}
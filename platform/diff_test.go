@@ -0,0 +1,92 @@
+package platform
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffImageFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.tar")
+	b := filepath.Join(dir, "b.tar")
+
+	if err := os.WriteFile(a, writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: x86_64"},
+		{"rootfs/etc/", tar.TypeDir, ""},
+		{"rootfs/etc/config", tar.TypeReg, "old"},
+		{"rootfs/etc/same", tar.TypeReg, "same"},
+		{"rootfs/etc/removed", tar.TypeReg, "gone"},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: aarch64"},
+		{"rootfs/etc/", tar.TypeDir, ""},
+		{"rootfs/etc/config", tar.TypeReg, "new"},
+		{"rootfs/etc/same", tar.TypeReg, "same"},
+		{"rootfs/etc/added", tar.TypeReg, "added"},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filesA, err := readImageFiles(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesB, err := readImageFiles(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var summary []string
+	for _, change := range diffFiles(filesA, filesB) {
+		summary = append(summary, string(change.op)+change.path)
+	}
+	expected := []string{"+etc/added", "~etc/config", "-etc/removed"}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("expected changes %v, found %v", expected, summary)
+	}
+}
+
+func TestParseUnitFiles(t *testing.T) {
+	output := "[unit] RUN: [sh -c find / -exec stat -c '%f\t%u\t%g\t%s\t%Y\t%n' {} +]\n" +
+		"41ed\t0\t0\t4096\t1700000000\t/\n" +
+		"81a4\t0\t0\t3\t1700000000\t/etc/config\n" +
+		"a1ff\t0\t0\t7\t1700000000\t/etc/link\n" +
+		"find: /proc/1: Permission denied\n"
+
+	files := parseUnitFiles(output)
+	expected := map[string]fileInfo{
+		"etc/config": {kind: fileKindRegular, perm: 0644, size: 3, modTime: 1700000000},
+		"etc/link":   {kind: fileKindSymlink, perm: 0777, size: 7, modTime: 1700000000},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %+v, found %+v", expected, files)
+	}
+
+	// NUL separated records keep names containing tabs and newlines intact
+	output = "[unit] RUN: [sh -c find / -print0 | xargs -0 stat --printf '%f\t%u\t%g\t%s\t%Y\t%n\\0']\n" +
+		"41ed\t0\t0\t4096\t1700000000\t/\x00" +
+		"81a4\t0\t0\t3\t1700000000\t/etc/config\x00" +
+		"81a4\t0\t0\t1\t1700000000\t/etc/new\nline\x00" +
+		"81a4\t0\t0\t1\t1700000000\t/etc/tab\tbed\x00"
+	nulFiles := parseUnitFiles(output)
+	for _, name := range []string{"etc/config", "etc/new\nline", "etc/tab\tbed"} {
+		if _, ok := nulFiles[name]; !ok {
+			t.Errorf("expected %q in %+v", name, nulFiles)
+		}
+	}
+	if len(nulFiles) != 3 {
+		t.Errorf("expected 3 files, found %+v", nulFiles)
+	}
+
+	// Without content digests files are compared by size and modification time
+	image := map[string]fileInfo{"etc/config": {kind: fileKindRegular, perm: 0644, size: 3, modTime: 1600000000, digest: "abc"}}
+	changes := diffFiles(image, map[string]fileInfo{"etc/config": files["etc/config"]})
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].details, []string{"content"}) {
+		t.Fatalf("expected modified file to be reported, found %+v", changes)
+	}
+}
@@ -0,0 +1,369 @@
+package platform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+)
+
+const hostsFile = "/etc/hosts"
+
+const (
+	hostsBlockBegin = "# BEGIN bravetools compose"
+	hostsBlockEnd   = "# END bravetools compose"
+)
+
+// serviceReference matches references to other compose services such as ${service.db.address}, ${service.db.port} or ${service.db.port.5432}
+var serviceReference = regexp.MustCompile(`\$\{service\.([^.}]+)\.(address|port)(?:\.([0-9]+))?\}`)
+
+// hostsEntry is a unit registered for service discovery along with the names it can be resolved by
+type hostsEntry struct {
+	lxdServer lxd.InstanceServer
+	remote    Remote
+	service   string
+	unit      string
+	address   string
+	names     []string
+	ports     []string
+}
+
+// Compose units record their project and the names they are known by, so hosts blocks can be rebuilt from LXD alone
+// whenever one of them is started or deployed
+const (
+	composeProjectConfigKey = "user.bravetools.compose.project"
+	composeNamesConfigKey   = "user.bravetools.compose.names"
+)
+
+// serviceDiscovery keeps track of units deployed by compose and lets them resolve each other by name.
+// Names are registered in a bravetools-managed block of /etc/hosts inside every unit of the project on the same remote.
+type serviceDiscovery struct {
+	project string
+	entries []hostsEntry
+}
+
+// newServiceDiscovery returns service discovery for units of a compose project, identified by the path of its compose file
+func newServiceDiscovery(project string) *serviceDiscovery {
+	return &serviceDiscovery{project: project}
+}
+
+// register records the current address of a newly deployed unit under its name and aliases,
+// then rewrites the hosts block of every unit of the project on the same remote.
+func (discovery *serviceDiscovery) register(lxdServer lxd.InstanceServer, remote Remote, unit string, service *shared.ComposeService) error {
+	address, err := GetUnitAddress(lxdServer, unit)
+	if err != nil {
+		return fmt.Errorf("failed to register unit %q for service discovery: %s", unit, err)
+	}
+
+	names := []string{unit}
+	for _, alias := range service.Aliases {
+		if alias != "" && alias != unit {
+			names = append(names, alias)
+		}
+	}
+
+	discovery.entries = append(discovery.entries, hostsEntry{
+		lxdServer: lxdServer,
+		remote:    remote,
+		service:   service.Name,
+		unit:      unit,
+		address:   address,
+		names:     names,
+		ports:     service.Ports,
+	})
+
+	err = SetConfig(lxdServer, unit, map[string]string{
+		composeProjectConfigKey: discovery.project,
+		composeNamesConfigKey:   strings.Join(names, ","),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register unit %q for service discovery: %s", unit, err)
+	}
+
+	return refreshComposeHosts(lxdServer, unit)
+}
+
+// refreshComposeHosts rewrites the hosts block of every running unit in the compose project of a unit, using the current
+// addresses of the units on its LXD server. Units that were not deployed by compose are left alone.
+func refreshComposeHosts(lxdServer lxd.InstanceServer, unit string) error {
+	instance, _, err := lxdServer.GetInstance(unit)
+	if err != nil {
+		return err
+	}
+	project := instance.Config[composeProjectConfigKey]
+	if project == "" {
+		return nil
+	}
+
+	// Wait for the unit to get an address after being started
+	if _, err = GetUnitAddress(lxdServer, unit); err != nil {
+		return err
+	}
+
+	return refreshComposeProjectHosts(lxdServer, project)
+}
+
+// refreshComposeProjectHosts rewrites the hosts block of every running unit in a compose project on an LXD server.
+// Units that no longer exist drop out of the block, so it is also used after a unit is deleted.
+func refreshComposeProjectHosts(lxdServer lxd.InstanceServer, project string) error {
+	instances, err := lxdServer.GetInstances(api.InstanceTypeAny)
+	if err != nil {
+		return err
+	}
+
+	var peers []hostsEntry
+	for _, instance := range instances {
+		if instance.Config[composeProjectConfigKey] != project || instance.Status != "Running" {
+			continue
+		}
+		state, _, err := lxdServer.GetInstanceState(instance.Name)
+		if err != nil {
+			return err
+		}
+		address := unitIPv4Address(state)
+		if address == "" {
+			continue
+		}
+
+		names := strings.Split(instance.Config[composeNamesConfigKey], ",")
+		if names[0] == "" {
+			names = []string{instance.Name}
+		}
+		peers = append(peers, hostsEntry{lxdServer: lxdServer, unit: instance.Name, address: address, names: names})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].unit < peers[j].unit })
+
+	block := hostsBlock(peers)
+	for _, peer := range peers {
+		err = updateHostsBlock(peer.lxdServer, peer.unit, block)
+		if err != nil {
+			return fmt.Errorf("failed to update %s in unit %q: %s", hostsFile, peer.unit, err)
+		}
+	}
+
+	return nil
+}
+
+// unitIPv4Address returns the IPv4 address of the eth0 interface of a unit, or an empty string if it has none yet
+func unitIPv4Address(state *api.InstanceState) string {
+	eth, ok := state.Network["eth0"]
+	if !ok {
+		return ""
+	}
+	for _, addr := range eth.Addresses {
+		if addr.Family == "inet" && isIPv4(addr.Address) {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// resolveReferences returns a copy of a service with references to previously deployed services resolved in its environment and postdeploy steps.
+// References are resolved relative to the remote the service is deployed to - services on the same remote are reached through
+// their unit address and port, while services on other remotes are reached through the remote host address and published port.
+func (discovery *serviceDiscovery) resolveReferences(service shared.Service, remote string) (shared.Service, error) {
+	var errs []error
+	resolve := func(content string) string {
+		resolved, err := discovery.resolve(content, remote)
+		errs = append(errs, err)
+		return resolved
+	}
+
+	resolved := service
+
+	resolved.Environment = make(map[string]string, len(service.Environment))
+	for key, value := range service.Environment {
+		resolved.Environment[key] = resolve(value)
+	}
+
+	resolved.Postdeploy.Run = make([]shared.RunCommand, len(service.Postdeploy.Run))
+	for i, run := range service.Postdeploy.Run {
+		run.Command = resolve(run.Command)
+		run.Content = resolve(run.Content)
+
+		args := make([]string, len(run.Args))
+		for j := range run.Args {
+			args[j] = resolve(run.Args[j])
+		}
+		run.Args = args
+
+		env := make(map[string]string, len(run.Env))
+		for key, value := range run.Env {
+			env[key] = resolve(value)
+		}
+		run.Env = env
+
+		resolved.Postdeploy.Run[i] = run
+	}
+
+	resolved.Postdeploy.Copy = make([]shared.CopyCommand, len(service.Postdeploy.Copy))
+	for i, c := range service.Postdeploy.Copy {
+		c.Source = resolve(c.Source)
+		c.Target = resolve(c.Target)
+		c.Action = resolve(c.Action)
+		resolved.Postdeploy.Copy[i] = c
+	}
+
+	if err := shared.CollectErrors(errs...); err != nil {
+		return service, fmt.Errorf("failed to resolve service references for %q: %s", service.Name, err)
+	}
+
+	return resolved, nil
+}
+
+// resolve replaces all service references in content with the address or port of the referenced service as seen from the provided remote
+func (discovery *serviceDiscovery) resolve(content string, remote string) (string, error) {
+	var err error
+
+	resolved := serviceReference.ReplaceAllStringFunc(content, func(reference string) string {
+		match := serviceReference.FindStringSubmatch(reference)
+		value, resolveErr := discovery.lookup(match[1], match[2], match[3], remote)
+		if resolveErr != nil {
+			err = shared.CollectErrors(err, resolveErr)
+			return reference
+		}
+		return value
+	})
+
+	return resolved, err
+}
+
+// lookup returns the address or port of a registered service as seen from the provided remote
+func (discovery *serviceDiscovery) lookup(serviceName string, field string, unitPort string, remote string) (string, error) {
+	var entry *hostsEntry
+	for i := range discovery.entries {
+		if discovery.entries[i].service == serviceName {
+			entry = &discovery.entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return "", fmt.Errorf("service %q is not deployed yet - add it to depends_on to reference it", serviceName)
+	}
+
+	sameRemote := entry.remote.Name == remote
+
+	switch field {
+	case "address":
+		if sameRemote {
+			return entry.address, nil
+		}
+		return remoteHostAddress(entry.remote)
+	case "port":
+		return servicePort(entry, unitPort, sameRemote)
+	}
+
+	return "", fmt.Errorf("unknown field %q for service %q", field, serviceName)
+}
+
+// servicePort returns the unit port when reached from the same remote, or the published host port when reached from another remote.
+// If no unit port is requested the first port forwarding definition of the service is used.
+func servicePort(entry *hostsEntry, unitPort string, sameRemote bool) (string, error) {
+	if unitPort != "" && sameRemote {
+		return unitPort, nil
+	}
+
+	for _, p := range entry.ports {
+		ps := strings.Split(p, ":")
+		if len(ps) != 2 {
+			continue
+		}
+		if unitPort == "" || ps[0] == unitPort {
+			if sameRemote {
+				return ps[0], nil
+			}
+			return ps[1], nil
+		}
+	}
+
+	if unitPort == "" {
+		return "", fmt.Errorf("service %q does not define any ports", entry.service)
+	}
+	return "", fmt.Errorf("port %s of service %q is not published on remote %q", unitPort, entry.service, entry.remote.Name)
+}
+
+// remoteHostAddress returns the host address of a remote that units on other remotes can connect to
+func remoteHostAddress(remote Remote) (string, error) {
+	if remote.Protocol == "unix" {
+		return "", fmt.Errorf("remote %q is connected through a unix socket and has no address reachable from other remotes", remote.Name)
+	}
+
+	remoteURL, err := url.Parse(remote.URL)
+	if err != nil || remoteURL.Hostname() == "" {
+		return "", fmt.Errorf("failed to parse address of remote %q from URL %q", remote.Name, remote.URL)
+	}
+
+	return remoteURL.Hostname(), nil
+}
+
+// hostsBlock renders the bravetools-managed section of a hosts file
+func hostsBlock(entries []hostsEntry) string {
+	var block strings.Builder
+
+	block.WriteString(hostsBlockBegin + "\n")
+	for _, entry := range entries {
+		block.WriteString(entry.address + "\t" + strings.Join(entry.names, " ") + "\n")
+	}
+	block.WriteString(hostsBlockEnd + "\n")
+
+	return block.String()
+}
+
+// replaceHostsBlock removes any existing bravetools-managed section from a hosts file and appends the provided block
+func replaceHostsBlock(hosts string, block string) string {
+	var lines []string
+	managed := false
+
+	for _, line := range strings.Split(hosts, "\n") {
+		switch strings.TrimSpace(line) {
+		case hostsBlockBegin:
+			managed = true
+			continue
+		case hostsBlockEnd:
+			managed = false
+			continue
+		}
+		if !managed {
+			lines = append(lines, line)
+		}
+	}
+
+	hosts = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if hosts != "" {
+		hosts += "\n"
+	}
+
+	return hosts + block
+}
+
+// updateHostsBlock rewrites the bravetools-managed section of /etc/hosts inside a unit, preserving the rest of the file
+func updateHostsBlock(lxdServer lxd.InstanceServer, unit string, block string) error {
+	content, resp, err := lxdServer.GetInstanceFile(unit, hostsFile)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	hosts, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	args := lxd.InstanceFileArgs{
+		Content:   bytes.NewReader([]byte(replaceHostsBlock(string(hosts), block))),
+		UID:       resp.UID,
+		GID:       resp.GID,
+		Mode:      resp.Mode,
+		Type:      "file",
+		WriteMode: "overwrite",
+	}
+
+	return lxdServer.CreateInstanceFile(unit, hostsFile, args)
+}
@@ -0,0 +1,100 @@
+package platform
+
+import "testing"
+
+func TestReplaceHostsBlock(t *testing.T) {
+	hosts := "127.0.0.1\tlocalhost\n::1\tip6-localhost\n"
+	entries := []hostsEntry{
+		{unit: "db", address: "10.0.0.10", names: []string{"db", "postgres"}},
+		{unit: "api", address: "10.0.0.11", names: []string{"api"}},
+	}
+
+	expected := hosts + hostsBlockBegin + "\n" +
+		"10.0.0.10\tdb postgres\n" +
+		"10.0.0.11\tapi\n" +
+		hostsBlockEnd + "\n"
+
+	updated := replaceHostsBlock(hosts, hostsBlock(entries))
+	if updated != expected {
+		t.Fatalf("expected hosts file:\n%s\nfound:\n%s", expected, updated)
+	}
+
+	// Redeploying with a new address replaces the managed block rather than appending a second one
+	entries[0].address = "10.0.0.20"
+	expected = hosts + hostsBlockBegin + "\n" +
+		"10.0.0.20\tdb postgres\n" +
+		"10.0.0.11\tapi\n" +
+		hostsBlockEnd + "\n"
+
+	updated = replaceHostsBlock(updated, hostsBlock(entries))
+	if updated != expected {
+		t.Fatalf("expected hosts file:\n%s\nfound:\n%s", expected, updated)
+	}
+}
+
+func TestReplaceHostsBlockEmptyFile(t *testing.T) {
+	block := hostsBlock([]hostsEntry{{unit: "db", address: "10.0.0.10", names: []string{"db"}}})
+
+	updated := replaceHostsBlock("", block)
+	if updated != block {
+		t.Fatalf("expected hosts file to only contain managed block, found:\n%s", updated)
+	}
+}
+
+func TestResolveServiceReferences(t *testing.T) {
+	discovery := newServiceDiscovery("brave-compose.yaml")
+	discovery.entries = []hostsEntry{
+		{
+			remote:  Remote{Name: "local", Protocol: "unix", URL: "/var/snap/lxd/common/lxd/unix.socket"},
+			service: "cache",
+			address: "10.0.0.5",
+			ports:   []string{"6379:16379"},
+		},
+		{
+			remote:  Remote{Name: "staging", Protocol: "lxd", URL: "https://staging.example.com:8443"},
+			service: "db",
+			address: "10.10.0.10",
+			ports:   []string{"5432:15432", "8080:18080"},
+		},
+	}
+
+	cases := map[string]string{
+		"${service.cache.address}:${service.cache.port}":      "10.0.0.5:6379",
+		"${service.cache.port.6379}":                          "6379",
+		"postgres://${service.db.address}:${service.db.port}": "postgres://staging.example.com:15432",
+		"${service.db.port.8080}":                             "18080",
+		"$HOME ${unrelated.reference}":                        "$HOME ${unrelated.reference}",
+	}
+
+	for input, expected := range cases {
+		actual, err := discovery.resolve(input, "local")
+		if err != nil {
+			t.Errorf("failed to resolve %q: %s", input, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("expected %q to resolve to %q, found %q", input, expected, actual)
+		}
+	}
+}
+
+func TestResolveServiceReferencesErrors(t *testing.T) {
+	discovery := newServiceDiscovery("brave-compose.yaml")
+	discovery.entries = []hostsEntry{
+		{
+			remote:  Remote{Name: "local", Protocol: "unix", URL: "/var/snap/lxd/common/lxd/unix.socket"},
+			service: "cache",
+			address: "10.0.0.5",
+		},
+	}
+
+	for _, input := range []string{
+		"${service.missing.address}",
+		"${service.cache.port}",
+		"${service.cache.address}",
+	} {
+		if _, err := discovery.resolve(input, "staging"); err == nil {
+			t.Errorf("expected error resolving %q from another remote", input)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package platform
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+	"github.com/gorilla/websocket"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/termios"
+)
+
+// Terminal modes of commands run in units
+const (
+	TerminalAuto   = "auto"
+	TerminalAlways = "always"
+	TerminalNever  = "never"
+)
+
+// defaultShell is used when the login shell of a user cannot be read from the unit
+const defaultShell = "/bin/sh"
+
+// ExecOptions configures a command run in a unit
+type ExecOptions struct {
+	// Env adds variables to the environment of the command
+	Env map[string]string
+	// User runs the command as a user of the unit, given as NAME or UID with an optional :GID
+	User string
+	// Workdir is the working directory of the command
+	Workdir string
+	// Terminal selects whether the command gets an interactive PTY - by default only if stdin and stdout are terminals
+	Terminal string
+}
+
+// unitUser is an account in the passwd database of a unit
+type unitUser struct {
+	name  string
+	uid   uint32
+	gid   uint32
+	home  string
+	shell string
+}
+
+// ExecUnit runs a command in a running unit, returning the exit code of the command
+func (bh *BraveHost) ExecUnit(name string, command []string, options ExecOptions) (exitCode int, err error) {
+	if len(command) == 0 {
+		return 1, errors.New("no command provided")
+	}
+
+	lxdServer, unitName, err := bh.connectUnit(name, true)
+	if err != nil {
+		return 1, err
+	}
+
+	user, err := lookupUnitUser(lxdServer, unitName, options.User)
+	if err != nil {
+		return 1, err
+	}
+
+	return execInteractive(lxdServer, unitName, command, user, options)
+}
+
+// ShellUnit starts a login shell in a running unit, returning its exit code
+func (bh *BraveHost) ShellUnit(name string, options ExecOptions) (exitCode int, err error) {
+	lxdServer, unitName, err := bh.connectUnit(name, true)
+	if err != nil {
+		return 1, err
+	}
+
+	user, err := lookupUnitUser(lxdServer, unitName, options.User)
+	if err != nil {
+		return 1, err
+	}
+
+	shell := user.shell
+	if shell == "" {
+		shell = defaultShell
+	}
+	if options.Terminal == "" {
+		options.Terminal = TerminalAlways
+	}
+
+	return execInteractive(lxdServer, unitName, []string{shell, "-l"}, user, options)
+}
+
+// connectUnit connects to the remote of a [remote:]unit name and checks that the unit exists, and is running if required
+func (bh *BraveHost) connectUnit(name string, running bool) (lxdServer lxd.InstanceServer, unitName string, err error) {
+	remoteName, unitName := ParseRemoteName(name)
+
+	// If local remote, ensure the VM is started
+	if remoteName == shared.BravetoolsRemote {
+		err = bh.Backend.Start()
+		if err != nil {
+			return nil, unitName, errors.New("failed to start backend: " + err.Error())
+		}
+	}
+
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return nil, unitName, fmt.Errorf("failed to load remote %q: %s", remoteName, err)
+	}
+
+	lxdServer, err = GetLXDInstanceServer(remote)
+	if err != nil {
+		return nil, unitName, err
+	}
+
+	state, _, err := lxdServer.GetInstanceState(unitName)
+	if err != nil {
+		return nil, unitName, fmt.Errorf("unit %q not found on remote %q: %s", unitName, remoteName, err)
+	}
+	if running && state.Status != "Running" {
+		return nil, unitName, fmt.Errorf("unit %q is %s - start it with `brave start %s`", unitName, strings.ToLower(state.Status), name)
+	}
+
+	return lxdServer, unitName, nil
+}
+
+// lookupUnitUser resolves a NAME or UID with an optional :GID against the passwd database of a unit.
+// An empty spec is root. Numeric IDs without a passwd entry are used as given.
+func lookupUnitUser(lxdServer lxd.InstanceServer, unitName string, spec string) (user unitUser, err error) {
+	if spec == "" {
+		spec = "root"
+	}
+
+	name, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+
+	users, err := readUnitPasswd(lxdServer, unitName)
+	if err != nil && spec != "root" {
+		return user, fmt.Errorf("failed to read users of unit %q: %s", unitName, err)
+	}
+
+	uid, uidErr := strconv.ParseUint(name, 10, 32)
+	found := false
+	for _, entry := range users {
+		if entry.name == name || uidErr == nil && uint64(entry.uid) == uid {
+			user, found = entry, true
+			break
+		}
+	}
+	switch {
+	case found:
+	case uidErr == nil:
+		user = unitUser{uid: uint32(uid), gid: uint32(uid)}
+	case name == "root":
+		user = unitUser{name: "root", home: "/root"}
+	default:
+		return user, fmt.Errorf("user %q does not exist in unit %q", name, unitName)
+	}
+
+	if group != "" {
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			return user, fmt.Errorf("invalid group %q - expected a numeric GID", group)
+		}
+		user.gid = uint32(gid)
+	}
+
+	return user, nil
+}
+
+// readUnitPasswd reads the accounts of a unit from its /etc/passwd
+func readUnitPasswd(lxdServer lxd.InstanceServer, unitName string) (users []unitUser, err error) {
+	content, _, err := lxdServer.GetInstanceFile(unitName, "/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return parsePasswd(content)
+}
+
+// parsePasswd parses passwd entries of the form name:password:uid:gid:gecos:home:shell
+func parsePasswd(r io.Reader) (users []unitUser, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		users = append(users, unitUser{
+			name:  fields[0],
+			uid:   uint32(uid),
+			gid:   uint32(gid),
+			home:  fields[5],
+			shell: fields[6],
+		})
+	}
+	return users, scanner.Err()
+}
+
+// execEnvironment returns the environment of a command run as user - explicit variables take precedence
+func execEnvironment(user unitUser, env map[string]string, interactive bool) map[string]string {
+	environment := map[string]string{}
+	if user.home != "" {
+		environment["HOME"] = user.home
+	}
+	if user.name != "" {
+		environment["USER"] = user.name
+	}
+	if interactive {
+		environment["TERM"] = "xterm"
+		if term := os.Getenv("TERM"); term != "" {
+			environment["TERM"] = term
+		}
+	}
+	for key, value := range env {
+		environment[key] = value
+	}
+	return environment
+}
+
+// execInteractive runs a command in a unit connected to the standard streams, with a PTY if the terminal mode asks
+// for one. The local terminal is switched to raw mode and window size changes are forwarded while the command runs.
+func execInteractive(lxdServer lxd.InstanceServer, unitName string, command []string, user unitUser, options ExecOptions) (exitCode int, err error) {
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+
+	var interactive bool
+	switch options.Terminal {
+	case "", TerminalAuto:
+		interactive = termios.IsTerminal(stdinFd) && termios.IsTerminal(stdoutFd)
+	case TerminalAlways:
+		interactive = true
+	case TerminalNever:
+		interactive = false
+	default:
+		return 1, fmt.Errorf("invalid terminal mode %q - expected %s, %s or %s", options.Terminal, TerminalAuto, TerminalAlways, TerminalNever)
+	}
+
+	req := api.InstanceExecPost{
+		Command:     command,
+		WaitForWS:   true,
+		Interactive: interactive,
+		Environment: execEnvironment(user, options.Env, interactive),
+		User:        user.uid,
+		Group:       user.gid,
+		Cwd:         options.Workdir,
+	}
+
+	args := lxd.InstanceExecArgs{
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		DataDone: make(chan bool),
+	}
+
+	if interactive && termios.IsTerminal(stdinFd) {
+		state, err := termios.MakeRaw(stdinFd)
+		if err != nil {
+			return 1, fmt.Errorf("failed to set terminal to raw mode: %s", err)
+		}
+		defer termios.Restore(stdinFd, state)
+	}
+	if interactive && termios.IsTerminal(stdoutFd) {
+		req.Width, req.Height, err = termios.GetSize(stdoutFd)
+		if err != nil {
+			return 1, fmt.Errorf("failed to get terminal size: %s", err)
+		}
+		done := make(chan struct{})
+		defer close(done)
+		args.Control = func(control *websocket.Conn) {
+			forwardTerminalResize(control, stdoutFd, done)
+		}
+	}
+
+	op, err := lxdServer.ExecInstance(unitName, req, &args)
+	if err != nil {
+		return 1, fmt.Errorf("failed to run command in unit %q: %s", unitName, err)
+	}
+
+	err = op.Wait()
+	if err != nil {
+		return 1, fmt.Errorf("failed to run command in unit %q: %s", unitName, err)
+	}
+
+	// Wait for all output to be written before returning
+	<-args.DataDone
+
+	returnCode, ok := op.Get().Metadata["return"].(float64)
+	if !ok {
+		return 1, fmt.Errorf("no exit code returned for command in unit %q", unitName)
+	}
+	return int(returnCode), nil
+}
+
+// sendTerminalSize tells LXD the size of the local terminal so the PTY of the command can be resized
+func sendTerminalSize(control *websocket.Conn, fd int) error {
+	width, height, err := termios.GetSize(fd)
+	if err != nil {
+		return err
+	}
+
+	msg := api.InstanceExecControl{
+		Command: "window-resize",
+		Args: map[string]string{
+			"width":  strconv.Itoa(width),
+			"height": strconv.Itoa(height),
+		},
+	}
+	return control.WriteJSON(msg)
+}
+
+// runInUnit runs a command in a unit without a terminal, connecting its standard streams to the provided reader and
+// writers. A nil stdin leaves the standard input of the command open, nil writers discard output.
+func runInUnit(lxdServer lxd.InstanceServer, unitName string, command []string, stdin io.ReadCloser, stdout io.Writer, stderr io.Writer) (exitCode int, err error) {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	req := api.InstanceExecPost{
+		Command:   command,
+		WaitForWS: true,
+	}
+	args := lxd.InstanceExecArgs{
+		Stdin:    stdin,
+		Stdout:   nopWriteCloser{stdout},
+		Stderr:   nopWriteCloser{stderr},
+		DataDone: make(chan bool),
+	}
+
+	op, err := lxdServer.ExecInstance(unitName, req, &args)
+	if err != nil {
+		return 1, err
+	}
+	if err = op.Wait(); err != nil {
+		return 1, err
+	}
+	<-args.DataDone
+
+	returnCode, ok := op.Get().Metadata["return"].(float64)
+	if !ok {
+		return 1, fmt.Errorf("no exit code returned for command in unit %q", unitName)
+	}
+	return int(returnCode), nil
+}
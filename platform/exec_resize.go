@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package platform
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+// forwardTerminalResize sends the terminal size to LXD whenever the local terminal is resized, until done is closed
+func forwardTerminalResize(control *websocket.Conn, fd int, done <-chan struct{}) {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+
+	for {
+		select {
+		case <-resized:
+			if err := sendTerminalSize(control, fd); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package platform
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lxc/lxd/shared/termios"
+)
+
+// forwardTerminalResize polls the terminal size, as Windows has no resize signal, and sends changes to LXD until done is closed
+func forwardTerminalResize(control *websocket.Conn, fd int, done <-chan struct{}) {
+	width, height, _ := termios.GetSize(fd)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			newWidth, newHeight, err := termios.GetSize(fd)
+			if err != nil || newWidth == width && newHeight == height {
+				continue
+			}
+			width, height = newWidth, newHeight
+			if err = sendTerminalSize(control, fd); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
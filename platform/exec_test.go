@@ -0,0 +1,35 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePasswd(t *testing.T) {
+	passwd := `root:x:0:0:root:/root:/bin/bash
+# comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+app:x:1000:1001:App User,,,:/home/app:/bin/sh
+broken:x:abc:1:broken:/:/bin/sh
+`
+	users, err := parsePasswd(strings.NewReader(passwd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("expected 3 users, got %d", len(users))
+	}
+
+	app := users[2]
+	if app.name != "app" || app.uid != 1000 || app.gid != 1001 || app.home != "/home/app" || app.shell != "/bin/sh" {
+		t.Errorf("unexpected user %+v", app)
+	}
+
+	env := execEnvironment(app, map[string]string{"HOME": "/tmp", "DEBUG": "1"}, false)
+	if env["HOME"] != "/tmp" || env["USER"] != "app" || env["DEBUG"] != "1" {
+		t.Errorf("unexpected environment %v", env)
+	}
+	if _, ok := env["TERM"]; ok {
+		t.Error("expected TERM to be set only for interactive commands")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -14,10 +15,12 @@ import (
 	"regexp"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/bravetools/bravetools/shared"
 	lxd "github.com/lxc/lxd/client"
 	"github.com/lxc/lxd/shared/api"
+	"github.com/olekukonko/tablewriter"
 )
 
 // Private Helpers
@@ -109,10 +112,9 @@ func needTransferImage(bravefile shared.Bravefile) bool {
 	return destRemoteName != shared.BravetoolsRemote
 }
 
-func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
+func buildImage(bh *BraveHost, bravefile *shared.Bravefile) (err error) {
 
 	var imageStruct BravetoolsImage
-	var err error
 
 	// The image to build - if not in build section, use Image defined in Service section
 	imageString := bravefile.Image
@@ -125,6 +127,20 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 		return fmt.Errorf("failed to build image: %s", err)
 	}
 
+	// Keep the Bravefile as provided - the copy used to build is modified along the way
+	resolvedBravefile := *bravefile
+
+	switch bravefile.SystemPackages.Manager {
+	case "":
+		// No package manager - if packages are to be installed, raise error
+		if len(bravefile.SystemPackages.System) > 0 {
+			return errors.New("package manager not specified - cannot install packages")
+		}
+	case "apk", "apt":
+	default:
+		return fmt.Errorf("package manager %q not recognized", bravefile.SystemPackages.Manager)
+	}
+
 	// If version explicitly provided separately this is a legacy Bravefile
 	if !bravefile.IsLegacy() {
 		imageStruct, err = ParseImageString(imageString)
@@ -134,6 +150,9 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 	if err != nil {
 		return err
 	}
+	if err = checkConcreteVersion(imageStruct); err != nil {
+		return err
+	}
 
 	// Use bravetools host LXD instance to build
 	lxdServer, err := GetLXDInstanceServer(bh.Remote)
@@ -153,6 +172,7 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 	if imageStruct.Version == "" {
 		imageStruct.Version = defaultImageVersion
 	}
+	resolvedBravefile.Image = imageStruct.String()
 
 	// Intercept SIGINT, propagate cancel and cleanup artefacts
 	var imageFingerprint string
@@ -176,6 +196,23 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 
 	fmt.Println(shared.Info("Building Image: " + imageStruct.String()))
 
+	// Capture build output and step outcomes - on failure point the user at the log and the failing step
+	buildLog, err := newBuildLog(imageStruct, bh.Remote.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		buildLog.close(err)
+		if err == nil {
+			return
+		}
+		if i, step := buildLog.failedStep(); step != nil {
+			err = fmt.Errorf("build failed at step %d (%s): %s\nbuild log: %s", i+1, step.description, err, buildLog.path)
+		} else {
+			err = fmt.Errorf("%s\nbuild log: %s", err, buildLog.path)
+		}
+	}()
+
 	bravefile.PlatformService.Name = "brave-build-" + strings.ReplaceAll(strings.ReplaceAll(imageStruct.ToBasename(), "_", "-"), ".", "-")
 
 	err = checkUnits(lxdServer, bravefile.PlatformService.Name, bh.Remote.Profile)
@@ -196,6 +233,24 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 			return fmt.Errorf("base image %q does not exist: %s", bravefile.Base.Image, err.Error())
 		}
 	}
+	resolvedBravefile.Base.Location = bravefile.Base.Location
+
+	// Pin version constraints of the base image so the build and its recorded Bravefile use a concrete version
+	if bravefile.Base.Location != "github" {
+		bravefile.Base.Image, err = resolveBaseImageVersion(bravefile.Base.Image, bravefile.Base.Location, buildServerArch)
+		if err != nil {
+			return fmt.Errorf("failed to resolve base image %q: %s", bravefile.Base.Image, err)
+		}
+		resolvedBravefile.Base.Image = bravefile.Base.Image
+	}
+
+	// Base images built by bravetools record their own lineage
+	var baseLineage []string
+
+	// The base image is inspected first so the build cache can be checked before it is launched.
+	// launchBase launches the build unit from the base image and returns the fingerprint of the image it imported.
+	var baseFingerprint string
+	var launchBase func() (string, error)
 
 	switch bravefile.Base.Location {
 	case "public", "private":
@@ -203,7 +258,7 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 
 		// Connect to image source LXD server
 		if bravefile.Base.Location == "public" {
-			sourceImageServer, err = GetSimplestreamsLXDSever("https://images.linuxcontainers.org", nil)
+			sourceImageServer, err = GetSimplestreamsLXDSever(publicImageServerURL(), nil)
 			if err != nil {
 				return err
 			}
@@ -218,11 +273,7 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 			}
 
 			// Connect to remote server - authenticate if not public
-			if imageRemote.Public {
-				sourceImageServer, err = GetLXDImageSever(imageRemote)
-			} else {
-				sourceImageServer, err = GetLXDInstanceServer(imageRemote)
-			}
+			sourceImageServer, err = connectImageRemote(imageRemote)
 			if err != nil {
 				return err
 			}
@@ -238,25 +289,24 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
 			return err
 		}
+		baseLineage = parseLineage(img.Properties[lineageProperty])
+		baseFingerprint = img.Fingerprint
 
-		imageFingerprint, err = LaunchFromImage(lxdServer, sourceImageServer, bravefile.Base.Image, bravefile.PlatformService.Name, bh.Remote.Profile, bh.Remote.Storage)
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return err
-		}
-
-		err = Start(lxdServer, bravefile.PlatformService.Name)
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return err
+		launchBase = func() (string, error) {
+			fingerprint, err := LaunchFromImage(lxdServer, sourceImageServer, bravefile.Base.Image, bravefile.PlatformService.Name, bh.Remote.Profile, bh.Remote.Storage)
+			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+				return fingerprint, err
+			}
+			return fingerprint, Start(lxdServer, bravefile.PlatformService.Name)
 		}
 	case "github":
-		imageFingerprint, err = importGitHub(ctx, lxdServer, bravefile, bh, bh.Remote.Profile, bh.Remote.Storage)
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return err
-		}
-
-		err = Start(lxdServer, bravefile.PlatformService.Name)
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return err
+		// The base image is only known once it has been built from GitHub, so the cache is checked after launching it
+		launchBase = func() (string, error) {
+			fingerprint, err := importGitHub(ctx, lxdServer, bravefile, bh, bh.Remote.Profile, bh.Remote.Storage)
+			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+				return fingerprint, err
+			}
+			return fingerprint, Start(lxdServer, bravefile.PlatformService.Name)
 		}
 	case "local":
 		// Check disk space
@@ -289,85 +339,117 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
 			return err
 		}
+		// The image store is content addressed, so the digest of the base image is the fingerprint it is imported with
+		if resolvedBase, basePath, err := resolveLocalImage(localBaseImage); err == nil {
+			baseLineage = localImageLineage(basePath)
+			baseFingerprint = strings.TrimPrefix(resolvedBase.hashString, digestPrefix)
+		}
 		err = CheckStoragePoolSpace(lxdServer, bh.Settings.StoragePool.Name, imgSize)
 		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
 			return err
 		}
 
-		imageFingerprint, err = importLocal(ctx, lxdServer, bravefile, bh.Remote.Profile, bh.Remote.Storage)
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return err
+		launchBase = func() (string, error) {
+			return importLocal(ctx, lxdServer, bravefile, bh.Remote.Profile, bh.Remote.Storage)
 		}
 	default:
 		return fmt.Errorf("base image location %q not supported", bravefile.Base.Location)
 	}
 
-	pMan := bravefile.SystemPackages.Manager
-
-	switch pMan {
-	case "":
-		// No package manager - if packages are to be installed, raise error
-		if len(bravefile.SystemPackages.System) > 0 {
-			return errors.New("package manager not specified - cannot install packages")
+	// Resume from the last cached step - the unit is launched from the cache image instead of the base image
+	var steps []buildStep
+	cached := -1
+	findCache := func() {
+		if bh.Settings.Build.NoCache {
+			return
 		}
-	case "apk":
-		_, err := Exec(ctx, lxdServer, bravefile.PlatformService.Name, []string{"apk", "update", "--no-cache"}, ExecArgs{})
-		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return errors.New("failed to update repositories: " + err.Error())
+		var cacheFingerprint string
+		cached, cacheFingerprint = findCachedStep(lxdServer, steps)
+		if cached >= 0 {
+			fmt.Printf("Using build cache %s for %d of %d steps\n", cacheFingerprint[:12], cached+1, len(steps))
 		}
+	}
 
-		args := []string{"apk", "--no-cache", "add"}
-		args = append(args, bravefile.SystemPackages.System...)
+	if baseFingerprint != "" {
+		steps, err = buildSteps(ctx, lxdServer, bravefile, baseFingerprint)
+		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+			return err
+		}
+		findCache()
+	}
 
-		if len(args) > 3 {
-			status, err := Exec(ctx, lxdServer, bravefile.PlatformService.Name, args, ExecArgs{})
+	if cached < 0 {
+		imageFingerprint, err = launchBase()
+		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+			return err
+		}
 
+		if baseFingerprint == "" {
+			steps, err = buildSteps(ctx, lxdServer, bravefile, imageFingerprint)
 			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-				return errors.New("failed to install packages: " + err.Error())
-			}
-			if status > 0 {
-				return errors.New(shared.Fatal("failed to install packages"))
+				return err
 			}
+			findCache()
 		}
+	}
 
-	case "apt":
-		_, err := Exec(ctx, lxdServer, bravefile.PlatformService.Name, []string{"apt", "update"}, ExecArgs{})
+	if cached >= 0 {
+		err = launchCachedStep(lxdServer, bravefile.PlatformService.Name, steps[cached], bh.Remote.Profile, bh.Remote.Storage)
 		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-			return errors.New("failed to update repositories: " + err.Error())
+			return err
 		}
+	}
+	next := cached + 1
 
-		args := []string{"apt", "install"}
-		args = append(args, bravefile.SystemPackages.System...)
+	for _, step := range steps[:next] {
+		buildLog.cachedStep(step)
+	}
 
-		if len(args) > 2 {
-			args = append(args, "--yes")
-			status, err := Exec(ctx, lxdServer, bravefile.PlatformService.Name, args, ExecArgs{})
+	for i, step := range steps[next:] {
+		buildLog.beginStep(next+i, len(steps), step)
 
-			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-				return errors.New("failed to install packages: " + err.Error())
-			}
-			if status > 0 {
-				return errors.New(shared.Fatal("failed to install packages"))
+		sizeBefore := unitDiskUsage(lxdServer, bravefile.PlatformService.Name)
+		started := time.Now()
+
+		err = step.run(buildLog.output)
+		err = shared.CollectErrors(err, ctx.Err())
+
+		buildLog.endStep(step, time.Since(started), unitDiskUsage(lxdServer, bravefile.PlatformService.Name)-sizeBefore, err)
+		if err != nil {
+			return err
+		}
+
+		if step.checkpoint && !bh.Settings.Build.NoCache {
+			// A failure to cache must not fail the build
+			if _, cacheErr := cacheBuildStep(lxdServer, bravefile.PlatformService.Name, imageStruct, step); cacheErr != nil {
+				fmt.Println(shared.Warn("failed to cache build step: " + cacheErr.Error()))
 			}
 		}
-	default:
-		return fmt.Errorf("package manager %q not recognized", pMan)
 	}
 
-	// Go through "Copy" section
-	err = bravefileCopy(ctx, lxdServer, bravefile.Copy, bravefile.PlatformService.Name)
-	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+	// Record installed packages before the unit is published - a missing package database should not fail the build
+	var bom *cycloneDX
+	packages, pkgType, distro, sbomErr := collectPackages(ctx, lxdServer, bravefile.PlatformService.Name)
+	if sbomErr != nil {
+		fmt.Fprintln(buildLog.output, shared.Warn("failed to generate SBOM: "+sbomErr.Error()))
+	} else {
+		doc := newCycloneDX(imageStruct, packages, pkgType, distro)
+		bom = &doc
+	}
+
+	// Embed the Bravefile and base image lineage in the image metadata
+	properties, err := imageProperties(imageStruct, resolvedBravefile, baseLineage)
+	if err != nil {
 		return err
 	}
 
-	// Go through "Run" section
-	err = bravefileRun(ctx, lxdServer, bravefile.Run, bravefile.PlatformService.Name)
-	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-		return errors.New(shared.Fatal("failed to execute command: " + err.Error()))
+	compression, err := resolveCompression(bh.Settings.Compression)
+	if err != nil {
+		return err
 	}
 
-	// Create an image based on running container and export it. Image saved as tar.gz in project local directory.
-	unitFingerprint, err := Publish(lxdServer, bravefile.PlatformService.Name, imageStruct.ToBasename())
+	// Create an image based on running container and export it. Image saved in project local directory.
+	unitFingerprint, err := PublishWithProperties(lxdServer, bravefile.PlatformService.Name, imageStruct.ToBasename(), properties, compression)
 	defer DeleteImageByFingerprint(lxdServer, unitFingerprint)
 	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
 		return errors.New("failed to publish image: " + err.Error())
@@ -383,6 +465,12 @@ func buildImage(bh *BraveHost, bravefile *shared.Bravefile) error {
 		return errors.New("failed to copy image file to bravetools image store: " + err.Error())
 	}
 
+	if bom != nil {
+		if sbomErr = writeSBOM(imageStruct, *bom); sbomErr != nil {
+			fmt.Println(shared.Warn("failed to store SBOM: " + sbomErr.Error()))
+		}
+	}
+
 	return nil
 }
 
@@ -588,14 +676,14 @@ func importLocal(ctx context.Context, lxdServer lxd.InstanceServer, bravefile *s
 func postdeploy(ctx context.Context, lxdServer lxd.InstanceServer, unitConfig *shared.Service) (err error) {
 
 	if unitConfig.Postdeploy.Copy != nil {
-		err = bravefileCopy(ctx, lxdServer, unitConfig.Postdeploy.Copy, unitConfig.Name)
+		err = bravefileCopy(ctx, lxdServer, unitConfig.Postdeploy.Copy, unitConfig.Name, os.Stdout)
 		if err != nil {
 			return err
 		}
 	}
 
 	if unitConfig.Postdeploy.Run != nil {
-		err = bravefileRun(ctx, lxdServer, unitConfig.Postdeploy.Run, unitConfig.Name)
+		err = bravefileRun(ctx, lxdServer, unitConfig.Postdeploy.Run, unitConfig.Name, os.Stdout)
 		if err != nil {
 			return errors.New(shared.Fatal("failed to execute command: " + err.Error()))
 		}
@@ -604,7 +692,57 @@ func postdeploy(ctx context.Context, lxdServer lxd.InstanceServer, unitConfig *s
 	return nil
 }
 
-func bravefileCopy(ctx context.Context, lxdServer lxd.InstanceServer, copy []shared.CopyCommand, service string) error {
+// installPackages updates package repositories in a unit and installs system packages listed in a Bravefile
+func installPackages(ctx context.Context, lxdServer lxd.InstanceServer, unitName string, packages shared.Packages, output io.Writer) error {
+	switch packages.Manager {
+	case "apk":
+		_, err := Exec(ctx, lxdServer, unitName, []string{"apk", "update", "--no-cache"}, ExecArgs{output: output})
+		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+			return errors.New("failed to update repositories: " + err.Error())
+		}
+
+		args := []string{"apk", "--no-cache", "add"}
+		args = append(args, packages.System...)
+
+		if len(args) > 3 {
+			status, err := Exec(ctx, lxdServer, unitName, args, ExecArgs{output: output})
+
+			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+				return errors.New("failed to install packages: " + err.Error())
+			}
+			if status > 0 {
+				return &exitCodeError{code: status, message: shared.Fatal("failed to install packages")}
+			}
+		}
+
+	case "apt":
+		_, err := Exec(ctx, lxdServer, unitName, []string{"apt", "update"}, ExecArgs{output: output})
+		if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+			return errors.New("failed to update repositories: " + err.Error())
+		}
+
+		args := []string{"apt", "install"}
+		args = append(args, packages.System...)
+
+		if len(args) > 2 {
+			args = append(args, "--yes")
+			status, err := Exec(ctx, lxdServer, unitName, args, ExecArgs{output: output})
+
+			if err := shared.CollectErrors(err, ctx.Err()); err != nil {
+				return errors.New("failed to install packages: " + err.Error())
+			}
+			if status > 0 {
+				return &exitCodeError{code: status, message: shared.Fatal("failed to install packages")}
+			}
+		}
+	default:
+		return fmt.Errorf("package manager %q not recognized", packages.Manager)
+	}
+
+	return nil
+}
+
+func bravefileCopy(ctx context.Context, lxdServer lxd.InstanceServer, copy []shared.CopyCommand, service string, output io.Writer) error {
 	dir, _ := os.Getwd()
 	for _, c := range copy {
 		if err := ctx.Err(); err != nil {
@@ -616,7 +754,7 @@ func bravefileCopy(ctx context.Context, lxdServer lxd.InstanceServer, copy []sha
 		sourcePath := filepath.FromSlash(source)
 
 		target := c.Target
-		_, err := Exec(ctx, lxdServer, service, []string{"mkdir", "-p", target}, ExecArgs{})
+		_, err := Exec(ctx, lxdServer, service, []string{"mkdir", "-p", target}, ExecArgs{output: output})
 		if err != nil {
 			return errors.New("Failed to create target directory: " + err.Error())
 		}
@@ -644,7 +782,7 @@ func bravefileCopy(ctx context.Context, lxdServer lxd.InstanceServer, copy []sha
 		}
 
 		if c.Action != "" {
-			_, err = Exec(ctx, lxdServer, service, []string{"bash", "-c", c.Action}, ExecArgs{})
+			_, err = Exec(ctx, lxdServer, service, []string{"bash", "-c", c.Action}, ExecArgs{output: output})
 			if err != nil {
 				return errors.New("Failed to execute action: " + err.Error())
 			}
@@ -654,7 +792,7 @@ func bravefileCopy(ctx context.Context, lxdServer lxd.InstanceServer, copy []sha
 	return nil
 }
 
-func bravefileRun(ctx context.Context, lxdServer lxd.InstanceServer, run []shared.RunCommand, service string) (err error) {
+func bravefileRun(ctx context.Context, lxdServer lxd.InstanceServer, run []shared.RunCommand, service string, output io.Writer) (err error) {
 	for _, c := range run {
 		if err = ctx.Err(); err != nil {
 			return err
@@ -676,18 +814,28 @@ func bravefileRun(ctx context.Context, lxdServer lxd.InstanceServer, run []share
 			args = append(args, content)
 		}
 
-		status, err := Exec(ctx, lxdServer, service, args, ExecArgs{env: c.Env, detach: c.Detach})
+		status, err := Exec(ctx, lxdServer, service, args, ExecArgs{env: c.Env, detach: c.Detach, output: output})
 		if err != nil {
 			return err
 		}
 		if status > 0 {
-			return fmt.Errorf("non-zero exit code %d for command %q", status, strings.Join(args, " "))
+			return &exitCodeError{code: status, message: fmt.Sprintf("non-zero exit code %d for command %q", status, strings.Join(args, " "))}
 		}
 	}
 
 	return err
 }
 
+// exitCodeError is returned when a command in a unit exits with a non-zero status
+type exitCodeError struct {
+	code    int
+	message string
+}
+
+func (e *exitCodeError) Error() string {
+	return e.message
+}
+
 func cleanUnusedStoragePool(lxdServer lxd.InstanceServer, name string) {
 	err := DeleteStoragePool(lxdServer, name)
 	if err != nil {
@@ -748,6 +896,21 @@ func getBaseOnlyServices(composeFile *shared.ComposeFile) (serviceNames []string
 	return serviceNames
 }
 
+// composeUnitName returns the [remote:]unit name a compose service is deployed as.
+// The remote can be provided in the service's remote field or as a prefix of the service name, but the two must agree.
+func composeUnitName(service *shared.ComposeService) (string, error) {
+	if service.Remote == "" {
+		return service.Name, nil
+	}
+
+	remoteName, unitName := ParseRemoteName(service.Name)
+	if strings.Contains(service.Name, ":") && remoteName != service.Remote {
+		return "", fmt.Errorf("service %q is placed on remote %q but its name refers to remote %q", service.Name, service.Remote, remoteName)
+	}
+
+	return service.Remote + ":" + unitName, nil
+}
+
 func getBuildDependents(dependency string, composeFile *shared.ComposeFile) (serviceNames []string, err error) {
 	for service := range composeFile.Services {
 		var imageStruct BravetoolsImage
@@ -791,9 +954,10 @@ func cleanMountTargetPath(targetPath string) string {
 // importImageFile imports an LXD image file in the local directory into the bravetools image store
 // The image file is cleaned up afterwards.
 func importImageFile(ctx context.Context, imageStruct BravetoolsImage) error {
-	home, _ := os.UserHomeDir()
-	localImageFile := imageStruct.ToBasename() + ".tar.gz"
-	localHashFile := localImageFile + ".md5"
+	localImageFile, err := findImageFile(imageStruct.ToBasename())
+	if err != nil {
+		return err
+	}
 
 	defer func() {
 		if err := os.Remove(localImageFile); err != nil {
@@ -801,41 +965,39 @@ func importImageFile(ctx context.Context, imageStruct BravetoolsImage) error {
 		}
 	}()
 
-	imageHash, err := shared.FileHash(localImageFile)
-	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-		return errors.New("failed to generate image hash: " + err.Error())
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	fmt.Println(imageHash)
-
-	// Write image hash to a file
-	f, err := os.Create(localHashFile)
+	store, err := openImageStore()
 	if err != nil {
-		return errors.New(err.Error())
-	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Println("failed to close image hash file: " + err.Error())
-		}
-		if err := os.Remove(localHashFile); err != nil {
-			fmt.Println("failed to clean up image hash: " + err.Error())
-		}
-	}()
-
-	_, err = f.WriteString(imageHash)
-	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-		return errors.New(err.Error())
+		return err
 	}
 
-	err = shared.CopyFile(localImageFile, path.Join(home, shared.ImageStore, localImageFile))
+	record, err := store.add(localImageFile, imageStruct, nil)
 	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
 		return errors.New("failed to copy image archive to local storage: " + err.Error())
 	}
 
-	err = shared.CopyFile(localHashFile, path.Join(home, shared.ImageStore, localHashFile))
-	if err := shared.CollectErrors(err, ctx.Err()); err != nil {
-		return errors.New("failed to copy images hash into local storage: " + err.Error())
-	}
+	fmt.Println(record.Digest)
 
 	return nil
 }
+
+// newPlainTable returns a borderless table writer matching the style of other bravetools listings
+func newPlainTable(w io.Writer, header []string) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+	return table
+}
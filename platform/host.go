@@ -3,6 +3,7 @@ package platform
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -24,6 +25,16 @@ type HostSettings struct {
 	BackendSettings BackendSettings `yaml:"backendsettings"`
 	Status          string          `yaml:"status"`
 	Remote          string          `yaml:"remote"`
+	BuildRemote     string          `yaml:"build_remote,omitempty"`
+	Build           BuildSettings   `yaml:"build,omitempty"`
+	Compression     string          `yaml:"compression,omitempty"`
+	// PublicImageServer is the simplestreams server of base images with location public
+	PublicImageServer string `yaml:"public_image_server,omitempty"`
+}
+
+// BuildSettings are defaults applied to image builds
+type BuildSettings struct {
+	NoCache bool `yaml:"no_cache,omitempty"`
 }
 
 // Storage ..
@@ -60,6 +71,31 @@ type BraveHost struct {
 	Settings HostSettings `yaml:"settings"`
 	Remote   Remote
 	Backend  Backend
+	// remoteSelected is set once Remote has been chosen explicitly or resolved from build_remote
+	remoteSelected bool
+}
+
+// SelectRemote sets the remote that builds images, taking priority over the build_remote setting
+func (bh *BraveHost) SelectRemote(remote Remote) {
+	bh.Remote = remote
+	bh.remoteSelected = true
+	if remote.Name != shared.BravetoolsRemote {
+		bh.Settings.StoragePool.Name = remote.Storage
+	}
+}
+
+// resolveBuildRemote selects the build_remote setting as the build remote, unless a remote was selected explicitly
+func (bh *BraveHost) resolveBuildRemote() error {
+	if bh.remoteSelected || bh.Settings.BuildRemote == "" {
+		return nil
+	}
+
+	remote, err := LoadRemoteSettings(bh.Settings.BuildRemote)
+	if err != nil {
+		return fmt.Errorf("failed to load build_remote %q: %s", bh.Settings.BuildRemote, err)
+	}
+	bh.SelectRemote(remote)
+	return nil
 }
 
 // NewBraveHost returns Brave host
@@ -22,6 +22,7 @@ import (
 	"github.com/bravetools/bravetools/db"
 	"github.com/bravetools/bravetools/shared"
 	"github.com/google/uuid"
+	lxd "github.com/lxc/lxd/client"
 	"github.com/olekukonko/tablewriter"
 )
 
@@ -39,9 +40,6 @@ func (bh *BraveHost) AddRemote() error {
 
 // ImportLocalImage import tarball into local images folder
 func (bh *BraveHost) ImportLocalImage(sourcePath string) error {
-	home, _ := os.UserHomeDir()
-	imageStore := path.Join(home, shared.ImageStore)
-
 	_, imageName := filepath.Split(sourcePath)
 
 	image, err := ImageFromFilename(imageName)
@@ -53,29 +51,41 @@ func (bh *BraveHost) ImportLocalImage(sourcePath string) error {
 		return fmt.Errorf("image %q already exists in local image store", image)
 	}
 
-	imagePath := filepath.Join(imageStore, image.ToBasename()+".tar.gz")
-	hashFile := imagePath + ".md5"
-
-	err = shared.CopyFile(sourcePath, imagePath)
+	// Refuse truncated or corrupted archives before they reach the store
+	digest, err := checkImageArchive(sourcePath)
 	if err != nil {
-		return errors.New("failed to copy image archive to local image store: " + err.Error())
+		return fmt.Errorf("failed to import %q: %s", imageName, err)
 	}
 
-	imageHash, err := shared.FileHash(sourcePath)
+	// Verify a detached signature shipped next to the archive against the signature policy of the local remote
+	localRemote, err := LoadRemoteSettings(shared.BravetoolsRemote)
+	if err != nil {
+		return err
+	}
+	err = checkImageSignature(image, sourcePath, digest, localRemote.SignaturePolicy)
 	if err != nil {
-		return errors.New("failed to generate image hash: " + err.Error())
+		return fmt.Errorf("failed to import %q: %s", imageName, err)
 	}
 
-	// Write image hash to a file
-	f, err := os.Create(hashFile)
+	store, err := openImageStore()
 	if err != nil {
-		return errors.New(err.Error())
+		return err
 	}
-	defer f.Close()
 
-	_, err = f.WriteString(imageHash)
+	record, err := store.add(sourcePath, image, nil)
 	if err != nil {
-		return errors.New(err.Error())
+		return errors.New("failed to copy image archive to local image store: " + err.Error())
+	}
+	if record.Digest != digest {
+		return shared.CollectErrors(fmt.Errorf("failed to import %q: archive changed while importing", imageName), store.remove(record))
+	}
+
+	// Keep the verified signature with the image
+	if shared.FileExists(signaturePath(sourcePath)) {
+		err = shared.CopyFile(signaturePath(sourcePath), signaturePath(store.blobPath(record.Digest)))
+		if err != nil {
+			return shared.CollectErrors(err, store.remove(record))
+		}
 	}
 
 	fmt.Printf("Imported file %q into bravetools as image %q\n", imageName, image)
@@ -109,7 +119,7 @@ func (bh *BraveHost) PrintLocalImages() error {
 			timeUnit = "just now"
 		}
 
-		r := []string{image.Name, image.Version, image.Architecture, timeUnit, shared.FormatByteCountSI(image.size), image.hashString}
+		r := []string{image.Name, image.Version, image.Architecture, timeUnit, shared.FormatByteCountSI(image.size), shortDigest(image.hashString)}
 		table.Append(r)
 	}
 
@@ -146,22 +156,68 @@ func (bh *BraveHost) DeleteLocalImage(name string, legacy bool) error {
 		}
 	}
 
-	imagePath, err := matchLocalImagePath(image)
+	store, err := openImageStore()
 	if err != nil {
 		return err
 	}
-	imageHash := imagePath + ".md5"
 
-	err = os.Remove(imagePath)
+	record, err := store.match(image)
 	if err != nil {
 		return err
 	}
 
-	err = os.Remove(imageHash)
+	return store.remove(record)
+}
+
+// TagImage creates an alias for a local image without copying it
+func (bh *BraveHost) TagImage(src string, dst string) error {
+	srcImage, err := ParseImageString(src)
+	if err != nil {
+		return err
+	}
+	dstImage, err := ParseImageString(dst)
+	if err != nil {
+		return err
+	}
+
+	store, err := openImageStore()
 	if err != nil {
 		return err
 	}
 
+	aliases, err := store.tag(srcImage, dstImage)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		fmt.Printf("Tagged %q as %q\n", alias.Target, alias.image())
+	}
+
+	return nil
+}
+
+// UntagImage removes an alias created by TagImage. The image it points at is kept.
+func (bh *BraveHost) UntagImage(name string) error {
+	image, err := ParseImageString(name)
+	if err != nil {
+		return err
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	removed, err := store.untag(image)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range removed {
+		fmt.Printf("Untagged %q\n", alias.image())
+	}
+
 	return nil
 }
 
@@ -667,6 +723,14 @@ func (bh *BraveHost) DeleteUnit(name string) error {
 		return errors.New("failed to delete unit: " + err.Error())
 	}
 
+	// Compose peers should no longer resolve the deleted unit
+	if project := inst.Config[composeProjectConfigKey]; project != "" {
+		err = refreshComposeProjectHosts(lxdServer, project)
+		if err != nil {
+			fmt.Println(shared.Warn(fmt.Sprintf("Warning: failed to update service discovery after deleting unit %q: %s", name, err)))
+		}
+	}
+
 	// Deleting unit from databse
 
 	userHome, err := os.UserHomeDir()
@@ -697,11 +761,21 @@ func (e *ImageExistsError) Error() string {
 
 // BuildImage creates an image based on Bravefile
 func (bh *BraveHost) BuildImage(bravefile shared.Bravefile) error {
+	if err := bh.resolveBuildRemote(); err != nil {
+		return err
+	}
+
+	if bh.Remote.Public || bh.Remote.Protocol == "simplestreams" {
+		return fmt.Errorf("remote %q is a public image server and cannot build images", bh.Remote.Name)
+	}
+
 	if bh.Remote.Name == shared.BravetoolsRemote {
 		err := bh.Backend.Start()
 		if err != nil {
 			return errors.New("failed to get host info: " + err.Error())
 		}
+	} else {
+		fmt.Println(shared.Info("Building on remote " + bh.Remote.Name))
 	}
 
 	err := buildImage(bh, &bravefile)
@@ -763,10 +837,15 @@ func (bh *BraveHost) PublishUnit(unitName string, imageName string) error {
 
 	imageName = imageStruct.ToBasename()
 
-	// Create an image based on running container and export it. Image saved as tar.gz in project local directory.
-	fmt.Printf("Publishing unit %q as image %q\n", unitName, imageName+".tar.gz")
+	compression, err := resolveCompression(bh.Settings.Compression)
+	if err != nil {
+		return err
+	}
+
+	// Create an image based on running container and export it. Image saved in project local directory.
+	fmt.Printf("Publishing unit %q as image %q\n", unitName, imageName+compressionExtension(compression))
 
-	unitFingerprint, err := Publish(lxdServer, unitName, imageName)
+	unitFingerprint, err := PublishWithProperties(lxdServer, unitName, imageName, nil, compression)
 	defer DeleteImageByFingerprint(lxdServer, unitFingerprint)
 	if err != nil {
 		return errors.New("failed to publish image: " + err.Error())
@@ -783,26 +862,28 @@ func (bh *BraveHost) PublishUnit(unitName string, imageName string) error {
 	return nil
 }
 
-func ExportBravetoolsImage(image string, outputDir string) error {
+// ExportBravetoolsImage copies an image from the local image store to outputDir.
+// The archive is recompressed if compression is set and differs from the stored archive.
+func ExportBravetoolsImage(image string, outputDir string, compression string) error {
 	img, err := ParseImageString(image)
 	if err != nil {
 		return err
 	}
 
-	path, err := matchLocalImagePath(img)
+	resolvedImg, path, err := resolveLocalImage(img)
 	if err != nil {
 		return err
 	}
 
-	resolvedImg, err := ImageFromFilename(filepath.Base(path))
+	storedCompression, err := detectCompression(path)
 	if err != nil {
-		resolvedImg, err = ImageFromLegacyFilename(path)
-		if err != nil {
-			resolvedImg = img
-		}
+		return err
+	}
+	if compression == "" {
+		compression = storedCompression
 	}
 
-	destPath := filepath.Base(path)
+	destPath := resolvedImg.ToBasename() + compressionExtension(compression)
 	if outputDir != "" {
 		destPath = filepath.Join(outputDir, destPath)
 	}
@@ -811,11 +892,28 @@ func ExportBravetoolsImage(image string, outputDir string) error {
 		return fmt.Errorf("existing file at %s would be overwritten by export of %q", destPath, resolvedImg)
 	}
 
-	err = shared.CopyFile(path, destPath)
+	if compression == storedCompression {
+		err = shared.CopyFile(path, destPath)
+	} else {
+		fmt.Printf("Compressing image %q with %s\n", resolvedImg, compression)
+		err = recompressImage(path, destPath, compression)
+	}
 	if err != nil {
 		return err
 	}
 
+	// Signatures cover the digest of the stored archive, so they only remain valid for an unchanged copy
+	if shared.FileExists(signaturePath(path)) {
+		if compression == storedCompression {
+			err = shared.CopyFile(signaturePath(path), signaturePath(destPath))
+			if err != nil {
+				return err
+			}
+		} else {
+			fmt.Println(shared.Warn("signatures of " + resolvedImg.String() + " are not exported with the recompressed archive"))
+		}
+	}
+
 	fmt.Printf("Exported image %q to: %s\n", resolvedImg, destPath)
 
 	return nil
@@ -881,11 +979,26 @@ func (bh *BraveHost) StartUnit(name string) error {
 		return errors.New("failed to start unit: " + err.Error())
 	}
 
+	// The unit may come back with a new address, so compose peers need to learn it
+	err = refreshComposeHosts(lxdServer, name)
+	if err != nil {
+		fmt.Println(shared.Warn(fmt.Sprintf("Warning: failed to update service discovery for unit %q: %s", name, err)))
+	}
+
 	return nil
 }
 
 // InitUnit starts unit from supplied image
 func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err error) {
+	return bh.initUnit(backend, unitParams, nil)
+}
+
+// unitHook is called during deployment with the LXD server and remote a unit is deployed to
+type unitHook func(lxdServer lxd.InstanceServer, remote Remote, unitName string) error
+
+// initUnit starts unit from supplied image. If provided, beforePostdeploy is called once the unit
+// is configured and running, before any postdeploy steps are executed.
+func (bh *BraveHost) initUnit(backend Backend, unitParams shared.Service, beforePostdeploy unitHook) (err error) {
 	// Check for missing mandatory fields
 	err = unitParams.ValidateDeploy()
 	if err != nil {
@@ -931,6 +1044,15 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 	}
 
 	if imageRemoteName != shared.BravetoolsRemote {
+		if isVersionConstraint(imageStruct.Version) {
+			resolved, err := resolveRemoteImage(imageRemoteName, imageStruct)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Resolved image %q to %q\n", imageStruct, resolved)
+			imageStruct = resolved
+		}
+
 		bravefile := shared.NewBravefile()
 		bravefile.Image = imageStruct.String()
 		bravefile.Base.Image = imageRemoteName + ":" + imageStruct.String()
@@ -1003,13 +1125,21 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 		return err
 	}
 
+	// Pick the image variant matching the remote - images built for another architecture cannot run there
 	if imageStruct.Architecture == "" {
 		imageStruct.Architecture = deployArch
+	} else if !sameArchitecture(imageStruct.Architecture, deployArch) {
+		return fmt.Errorf("image %q cannot be deployed to remote %q with architecture %q", imageStruct.String(), deployRemoteName, deployArch)
 	}
 
-	image, err := matchLocalImagePath(imageStruct)
+	resolvedImage, image, err := resolveLocalImage(imageStruct)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s - build it for %q with `brave build --platform %s`", err, deployArch, deployArch)
+	}
+	if resolvedImage.Version != imageStruct.Version && isVersionConstraint(imageStruct.Version) {
+		fmt.Printf("Resolved image %q to %q\n", imageStruct, resolvedImage)
+		imageStruct = resolvedImage
+		unitParams.Image = resolvedImage.String()
 	}
 
 	imgSize, err := localImageSize(imageStruct)
@@ -1017,9 +1147,12 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 		return fmt.Errorf("failed to get image size for image %q", imageStruct.String())
 	}
 
-	fingerprint, err := shared.FileSha256Hash(image)
+	// The image store is content addressed, so the digest of the image is its LXD fingerprint
+	fingerprint := strings.TrimPrefix(resolvedImage.hashString, digestPrefix)
+
+	err = checkImageSignature(resolvedImage, image, resolvedImage.hashString, deployRemote.SignaturePolicy)
 	if err != nil {
-		return fmt.Errorf("failed to obtain image hash %q", unitParams.Image)
+		return fmt.Errorf("refusing to deploy to remote %q: %s", deployRemoteName, err)
 	}
 
 	// Resource checks
@@ -1041,10 +1174,17 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 		}
 	}
 
-	// Import local image if it doesn't exist in LXD image store
+	// Import local image if it doesn't exist in LXD image store.
+	// Imported images are tagged so that any left behind by an interrupted deploy can be pruned later.
+	launchImage := unitParams.Image
 	if _, _, err = lxdServer.GetImage(fingerprint); err != nil {
-		_, err = ImportImage(lxdServer, image, unitName)
-		unitParams.Image = unitName
+		err = verifyImageFile(resolvedImage, image)
+		if err != nil {
+			return fmt.Errorf("%s - run `brave image verify --all` to check other images", err)
+		}
+
+		_, err = ImportImageWithProperties(lxdServer, image, unitName, map[string]string{deployImageProperty: resolvedImage.String()})
+		launchImage = unitName
 		if err = shared.CollectErrors(err, ctx.Err()); err != nil {
 			return errors.New("failed to import image: " + err.Error())
 		}
@@ -1052,7 +1192,7 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 	}
 
 	// Launch unit and set up cleanup code to delete it if an error encountered during deployment
-	_, err = LaunchFromImage(lxdServer, lxdServer, unitParams.Image, unitParams.Name, unitParams.Profile, unitParams.Storage)
+	_, err = LaunchFromImage(lxdServer, lxdServer, launchImage, unitParams.Name, unitParams.Profile, unitParams.Storage)
 	defer func() {
 		if err != nil {
 			delErr := DeleteUnit(lxdServer, unitName)
@@ -1141,6 +1281,16 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 		config["security.nesting"] = "true"
 	}
 
+	// Environment variables are set on the unit and inherited by processes started inside it
+	for key, value := range unitParams.Environment {
+		config["environment."+key] = value
+	}
+
+	// Log files are recorded on the unit so `brave logs` can find them on any remote
+	if len(unitParams.Logs) > 0 {
+		config[logFilesConfigKey] = strings.Join(unitParams.Logs, ",")
+	}
+
 	if unitParams.Resources.GPU == "yes" {
 		config["nvidia.runtime"] = "true"
 		device := map[string]string{"type": "gpu"}
@@ -1181,6 +1331,13 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 		}
 	}
 
+	if beforePostdeploy != nil {
+		err = beforePostdeploy(lxdServer, deployRemote, unitName)
+		if err = shared.CollectErrors(err, ctx.Err()); err != nil {
+			return err
+		}
+	}
+
 	err = postdeploy(ctx, lxdServer, &unitParams)
 	if err = shared.CollectErrors(err, ctx.Err()); err != nil {
 		return err
@@ -1209,7 +1366,7 @@ func (bh *BraveHost) InitUnit(backend Backend, unitParams shared.Service) (err e
 	unitData.CPU, _ = strconv.Atoi(unitParams.Resources.CPU)
 	unitData.RAM = unitParams.Resources.RAM
 	unitData.IP = unitParams.IP
-	unitData.Image = unitParams.Image
+	unitData.Image = resolvedImage.String()
 
 	data, err := json.Marshal(unitData)
 	if err != nil {
@@ -1272,8 +1429,23 @@ func (bh *BraveHost) Compose(backend Backend, composeFile *shared.ComposeFile) (
 			err = fmt.Errorf("failed to deploy service %q: %s", serviceName, err)
 			return err
 		}
+		for _, alias := range service.Aliases {
+			if alias == "" || strings.ContainsAny(alias, "/_ !@£$%^&*(){};`~,?:") {
+				return fmt.Errorf("failed to deploy service %q: invalid alias %q", serviceName, alias)
+			}
+		}
+		if _, err = composeUnitName(service); err != nil {
+			return fmt.Errorf("failed to deploy service %q: %s", serviceName, err)
+		}
 	}
 
+	// Units register their name and aliases as they are deployed so that the rest of the project can resolve them
+	project, err := filepath.Abs(composeFile.Path)
+	if err != nil {
+		return err
+	}
+	discovery := newServiceDiscovery(project)
+
 	// (Optionally build) and deploy each service
 	for _, serviceName := range topologicalOrdering {
 		service := composeFile.Services[serviceName]
@@ -1337,14 +1509,32 @@ func (bh *BraveHost) Compose(backend Backend, composeFile *shared.ComposeFile) (
 			}
 			os.Chdir(deployDir)
 
+			// Place unit on the requested remote and resolve references to services deployed before it
+			var unitName string
+			var unitParams shared.Service
+
+			unitName, err = composeUnitName(service)
+			if err != nil {
+				return err
+			}
+			deployRemoteName, _ := ParseRemoteName(unitName)
+
+			unitParams, err = discovery.resolveReferences(service.Service, deployRemoteName)
+			if err != nil {
+				return err
+			}
+			unitParams.Name = unitName
+
 			// Cleanup each unit if error in compose
-			err = bh.InitUnit(backend, service.Service)
+			err = bh.initUnit(backend, unitParams, func(lxdServer lxd.InstanceServer, remote Remote, name string) error {
+				return discovery.register(lxdServer, remote, name, service)
+			})
 			if err != nil {
 				return err
 			}
 			defer func() {
 				if err != nil {
-					bh.DeleteUnit(service.Name)
+					bh.DeleteUnit(unitName)
 				}
 			}()
 
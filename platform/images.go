@@ -3,15 +3,13 @@ package platform
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
 )
 
 const defaultImageVersion = "untagged"
@@ -97,7 +95,12 @@ func validateImage(imageStruct BravetoolsImage) error {
 			return fmt.Errorf("character %q is not valid in image name field", char)
 		}
 	}
-	for _, char := range imageStruct.Version {
+	// Versions may be a caret or tilde range resolved against the available versions
+	version := imageStruct.Version
+	if isVersionRange(version) {
+		version = version[1:]
+	}
+	for _, char := range version {
 		if !validImageFieldChar(char) {
 			return fmt.Errorf("character %q is not valid in image version field", char)
 		}
@@ -137,7 +140,7 @@ func (imageStruct BravetoolsImage) String() string {
 }
 
 func ImageFromFilename(filename string) (BravetoolsImage, error) {
-	filename = strings.TrimSuffix(filename, ".tar.gz")
+	filename = trimImageExtension(filename)
 	split := strings.SplitN(filename, "_", 3)
 
 	image := BravetoolsImage{
@@ -163,7 +166,7 @@ func ImageFromFilename(filename string) (BravetoolsImage, error) {
 func ImageFromLegacyFilename(filename string) (BravetoolsImage, error) {
 	// Legacy filenames are not delimited by underscores
 	// Final "-" is followed by version - no arch
-	filename = strings.TrimSuffix(filename, ".tar.gz")
+	filename = trimImageExtension(filename)
 	split := strings.Split(filename, "-")
 
 	image := BravetoolsImage{
@@ -185,182 +188,65 @@ func ImageFromLegacyFilename(filename string) (BravetoolsImage, error) {
 }
 
 type multipleImageMatches struct {
-	image         BravetoolsImage
-	matchingPaths []string
+	image   BravetoolsImage
+	matches []BravetoolsImage
 }
 
 func (e multipleImageMatches) Error() string {
 	// Multiple matches - ambiguous result. Return formatted error with the options.
-	imageStrings := make([]string, len(e.matchingPaths))
-	for _, path := range e.matchingPaths {
-		img, err := ImageFromFilename(filepath.Base(path))
-		if err != nil {
-			imageStrings = append(imageStrings, path)
-		} else {
-			imageStrings = append(imageStrings, img.String())
-		}
+	imageStrings := make([]string, len(e.matches))
+	for i, match := range e.matches {
+		imageStrings[i] = match.String()
 	}
 
-	return fmt.Sprintf("multiple matches for image %q in image store - specify version and/or architecture.\nMatches:%s", e.image, strings.Join(imageStrings, "\n"))
+	return fmt.Sprintf("multiple matches for image %q in image store - specify version and/or architecture.\nMatches:\n%s", e.image, strings.Join(imageStrings, "\n"))
 }
 
 func GetLocalImages() (images []BravetoolsImage, err error) {
-	home, _ := os.UserHomeDir()
-	imageStore := path.Join(home, shared.ImageStore)
-
-	// We're only interested in imageFiles and not MD5 checksums
-	imageFiles, err := shared.WalkMatch(imageStore, "*.tar.gz")
+	store, err := openImageStore()
 	if err != nil {
-		return images, errors.New("failed to access images folder: " + err.Error())
-	}
-
-	for _, imageFile := range imageFiles {
-		// Ignore "hidden" files starting with a full-stop - skip to next
-		if strings.Index(imageFile, ".") == 0 {
-			continue
-		}
-
-		// Parse image struct from file
-		image, err := ImageFromFilename(filepath.Base(imageFile))
-		if err != nil {
-			image, err = ImageFromLegacyFilename(filepath.Base(imageFile))
-			if err != nil {
-				return images, fmt.Errorf("failed to parse image filename schema from %q", imageFile)
-			}
-
-			if _, err = localImagePath(image); err != nil {
-				return images, fmt.Errorf("failed to retrieve parse file %q as a bravetools image", imageFile)
-			}
-		}
-
-		if _, err = localImagePath(image); err != nil {
-			image, err = ImageFromLegacyFilename(filepath.Base(imageFile))
-			if err != nil {
-				return images, fmt.Errorf("failed to parse image filename schema from %q", imageFile)
-			}
-			if _, err = localImagePath(image); err != nil {
-				return images, fmt.Errorf("failed to retrieve parse file %q as a bravetools image", imageFile)
-			}
-		}
-
-		// Extract file metadata to populate image fields
-		info, err := os.Stat(imageFile)
-		if err != nil {
-			return images, fmt.Errorf("failed to get image %q size: %s", image, err)
-		}
-		image.size = info.Size()
-		image.modTime = info.ModTime()
-		hashString, err := hashImage(image)
-		if err != nil {
-			return images, fmt.Errorf("failed to get image %q hash: %s", image, err)
-		}
-		image.hashString = hashString
-
-		images = append(images, image)
+		return images, err
 	}
 
-	return images, nil
+	return store.images(), nil
 }
 
-// matchLocalImagePath attempts to find candidates for the provided image definition using regex matching.
-// If more than one candidate file exists a formatted error of type 'multipleImageMatches' is returned.
-func matchLocalImagePath(image BravetoolsImage) (string, error) {
-
-	// Before querying candidates using regex, attempt to exactly match the provided image definition
-	if path, err := localImagePath(image); err == nil {
-		return path, nil
-	}
-
-	homeDir, err := os.UserHomeDir()
+// resolveLocalImage finds the image in the local image store matching the provided definition, along with the path of its archive.
+// Empty version and architecture fields match any value. If more than one image matches a formatted error of type 'multipleImageMatches' is returned.
+func resolveLocalImage(image BravetoolsImage) (BravetoolsImage, string, error) {
+	store, err := openImageStore()
 	if err != nil {
-		return "", fmt.Errorf("failed to access bravetools image store: %s", err)
+		return image, "", err
 	}
 
-	var fileRegexArr []string
-	for _, field := range []string{image.Name, image.Version, image.Architecture} {
-		if field == "" {
-			fileRegexArr = append(fileRegexArr, "*")
-		} else {
-			fileRegexArr = append(fileRegexArr, field)
-		}
-	}
-
-	imagePath := filepath.Join(homeDir, shared.ImageStore, strings.Join(fileRegexArr, "_")) + ".tar.gz"
-
-	matches, err := filepath.Glob(imagePath)
+	record, err := store.match(image)
 	if err != nil {
-		return "", fmt.Errorf("failed to search bravetools image store: %s", err)
-	}
-
-	nmatches := len(matches)
-	switch {
-	case nmatches == 1:
-		return matches[0], nil
-	case nmatches > 1:
-		// Multiple matches - ambiguous result. Return formatted error with the options.
-		err = multipleImageMatches{image: image, matchingPaths: matches}
-		return "", err
+		return image, "", err
 	}
 
-	return "", fmt.Errorf("failed to retrieve path for image %s, version: %s, arch: %s ", image.Name, image.Version, image.Architecture)
+	return record.image(), store.blobPath(record.Digest), nil
 }
 
-// localImagePath gets the exact image filepath matching the definition if it exists - no regex matching is performed
-func localImagePath(image BravetoolsImage) (string, error) {
-	homeDir, _ := os.UserHomeDir()
-	imagePath := path.Join(homeDir, shared.ImageStore, image.ToBasename()+".tar.gz")
-	if shared.FileExists(imagePath) {
-		return imagePath, nil
-	}
-	// Legacy filenames will not have arch
-	imagePath = path.Join(homeDir, shared.ImageStore, image.Name+"-"+image.Version+".tar.gz")
-	if shared.FileExists(imagePath) {
-		return imagePath, nil
-	}
-	return "", fmt.Errorf("failed to retrieve path for image %s, version: %s, arch: %s ", image.Name, image.Version, image.Architecture)
+// matchLocalImagePath finds the archive of the image matching the provided definition, treating empty fields as wildcards.
+// If more than one candidate exists a formatted error of type 'multipleImageMatches' is returned.
+func matchLocalImagePath(image BravetoolsImage) (string, error) {
+	_, imagePath, err := resolveLocalImage(image)
+	return imagePath, err
 }
 
-// hashImage calculates the md5 hash of the provided BravetoolsImage and stores it in a file.
-// If a file with a hash for this image already exists the hash will not be recalculated.
-func hashImage(image BravetoolsImage) (string, error) {
-	localImageFile, err := matchLocalImagePath(image)
+// localImagePath gets the archive path of the image exactly matching the definition if it exists - no wildcard matching is performed
+func localImagePath(image BravetoolsImage) (string, error) {
+	store, err := openImageStore()
 	if err != nil {
 		return "", err
 	}
-	hashFileName := localImageFile + ".md5"
 
-	hash, err := ioutil.ReadFile(hashFileName)
+	record, err := store.exact(image)
 	if err != nil {
-		if os.IsNotExist(err) {
-
-			imageHash, err := shared.FileHash(localImageFile)
-			if err != nil {
-				return "", errors.New("failed to generate image hash: " + err.Error())
-			}
-
-			f, err := os.Create(hashFileName)
-			if err != nil {
-				return "", errors.New(err.Error())
-			}
-			defer f.Close()
-
-			_, err = f.WriteString(imageHash)
-			if err != nil {
-				return "", errors.New(err.Error())
-			}
-
-			hash, err = ioutil.ReadFile(hashFileName)
-			if err != nil {
-				return "", errors.New(err.Error())
-			}
-		} else {
-			return "", errors.New("couldn't load image hash: " + err.Error())
-		}
+		return "", err
 	}
 
-	hashString := string(hash)
-	hashString = strings.TrimRight(hashString, "\r\n")
-	return hashString, nil
+	return store.blobPath(record.Digest), nil
 }
 
 func localImageSize(image BravetoolsImage) (bytes int64, err error) {
@@ -411,21 +297,26 @@ func resolveBaseImageLocation(imageString string, architecture string) (location
 	}
 
 	// Check for legacy image field
-	imageStruct, err = ParseLegacyImageString(imageString)
+	legacyImage, err := ParseLegacyImageString(imageString)
 	if err == nil {
-		if _, err = matchLocalImagePath(imageStruct); err == nil {
+		if _, err = matchLocalImagePath(legacyImage); err == nil {
 			return "local", nil
 		}
 	}
 
 	// Query public remote for alias
-	publicLxd, err := GetSimplestreamsLXDSever("https://images.linuxcontainers.org", nil)
+	publicLxd, err := GetSimplestreamsLXDSever(publicImageServerURL(), nil)
 	if err != nil {
 		return "", err
 	}
 	if _, err := GetFingerprintByAlias(publicLxd, imageString, architecture); err == nil {
 		return "public", nil
 	}
+	if isVersionConstraint(imageStruct.Version) {
+		if _, err := resolveRemoteVersion(publicLxd, imageStruct); err == nil {
+			return "public", nil
+		}
+	}
 
 	return "", fmt.Errorf("image %q location could not be resolved", imageString)
 }
@@ -455,5 +346,43 @@ func GetBravefileFromLXD(name string) (*shared.Bravefile, error) {
 	bravefile.PlatformService.Name = ""
 	bravefile.PlatformService.Image = image.String()
 
+	// Images built by bravetools carry the Bravefile they were built from - reuse its service section
+	if imageRemoteName != shared.BravetoolsRemote {
+		if embedded := remoteEmbeddedBravefile(imageRemoteName, image); embedded != nil {
+			bravefile.PlatformService = embedded.PlatformService
+			bravefile.PlatformService.Image = image.String()
+		}
+	}
+
 	return bravefile, nil
 }
+
+// remoteEmbeddedBravefile returns the Bravefile embedded in an image on a remote, or nil if it has none
+func remoteEmbeddedBravefile(remoteName string, image BravetoolsImage) *shared.Bravefile {
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return nil
+	}
+
+	var imageServer lxd.ImageServer
+	if remote.Public || remote.Protocol == "simplestreams" {
+		imageServer, err = GetLXDImageSever(remote)
+	} else {
+		imageServer, err = GetLXDInstanceServer(remote)
+	}
+	if err != nil {
+		return nil
+	}
+
+	remoteImage, err := GetImageByAlias(imageServer, image.String(), image.Architecture)
+	if err != nil {
+		return nil
+	}
+
+	bravefile, err := embeddedBravefile(remoteImage.Properties)
+	if err != nil {
+		fmt.Println(shared.Warn(fmt.Sprintf("Warning: image %q: %s", image, err)))
+		return nil
+	}
+	return bravefile
+}
@@ -0,0 +1,241 @@
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	"gopkg.in/yaml.v2"
+)
+
+// Image properties recorded in metadata.yaml of images built by bravetools
+const (
+	bravefileProperty = "bravetools.bravefile"
+	lineageProperty   = "bravetools.lineage"
+)
+
+// Lineage entries are the base images an image was built from, nearest first
+const lineageSeparator = ","
+
+// imageInspection describes an image in the local image store
+type imageInspection struct {
+	Image        string            `json:"image"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Architecture string            `json:"architecture"`
+	Created      time.Time         `json:"created"`
+	Target       string            `json:"target,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"`
+	Lineage      []string          `json:"lineage"`
+	Bravefile    string            `json:"bravefile,omitempty"`
+}
+
+// imageProperties returns the properties embedded in an image built from the resolved Bravefile
+func imageProperties(image BravetoolsImage, bravefile shared.Bravefile, baseLineage []string) (map[string]string, error) {
+	content, err := yaml.Marshal(bravefile)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage := append([]string{bravefile.Base.Image}, baseLineage...)
+
+	return map[string]string{
+		"description":     "Bravetools image " + image.String(),
+		bravefileProperty: string(content),
+		lineageProperty:   strings.Join(lineage, lineageSeparator),
+	}, nil
+}
+
+// parseLineage splits a lineage property into base images
+func parseLineage(lineage string) []string {
+	if lineage == "" {
+		return nil
+	}
+	return strings.Split(lineage, lineageSeparator)
+}
+
+// readImageMetadata reads metadata.yaml from a unified LXD image archive. The rest of the archive is not read.
+func readImageMetadata(imagePath string) (metadata lxdImageMetadata, err error) {
+	tr, closer, err := openTar(imagePath)
+	if err != nil {
+		return metadata, err
+	}
+	defer closeArchive(closer, &err)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return metadata, errors.New("image archive has no metadata.yaml")
+		}
+		if err != nil {
+			return metadata, err
+		}
+
+		if name, _ := cleanArchivePath(hdr.Name); name != "metadata.yaml" {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return metadata, err
+		}
+		err = yaml.Unmarshal(content, &metadata)
+		if err != nil {
+			return metadata, fmt.Errorf("failed to parse metadata.yaml: %s", err)
+		}
+		stopReading(closer)
+		return metadata, nil
+	}
+}
+
+// localImageLineage returns the lineage recorded in a local image archive. Images built before lineage was recorded have none.
+func localImageLineage(imagePath string) []string {
+	metadata, err := readImageMetadata(imagePath)
+	if err != nil {
+		return nil
+	}
+	return parseLineage(metadata.Properties[lineageProperty])
+}
+
+// embeddedBravefile parses the Bravefile embedded in image properties
+func embeddedBravefile(properties map[string]string) (*shared.Bravefile, error) {
+	content, ok := properties[bravefileProperty]
+	if !ok {
+		return nil, nil
+	}
+
+	var bravefile shared.Bravefile
+	err := yaml.Unmarshal([]byte(content), &bravefile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse embedded Bravefile: %s", err)
+	}
+	return &bravefile, nil
+}
+
+// inspectImage collects the metadata of an image in the local image store
+func inspectImage(name string) (*imageInspection, error) {
+	image, err := ParseImageString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := store.match(image)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &imageInspection{
+		Image:        record.image().String(),
+		Digest:       record.Digest,
+		Size:         record.Size,
+		Architecture: record.Architecture,
+		Created:      record.Created,
+		Target:       record.Target,
+		Labels:       record.Labels,
+		Lineage:      []string{},
+	}
+
+	metadata, err := readImageMetadata(store.blobPath(record.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of image %q: %s", inspection.Image, err)
+	}
+	if metadata.Architecture != "" && inspection.Architecture == "" {
+		inspection.Architecture = metadata.Architecture
+	}
+	if metadata.CreationDate > 0 {
+		inspection.Created = time.Unix(metadata.CreationDate, 0).UTC()
+	}
+
+	// The Bravefile and lineage are shown in their own sections
+	inspection.Properties = map[string]string{}
+	for key, value := range metadata.Properties {
+		switch key {
+		case bravefileProperty:
+			inspection.Bravefile = value
+		case lineageProperty:
+			inspection.Lineage = append(inspection.Lineage, parseLineage(value)...)
+		default:
+			inspection.Properties[key] = value
+		}
+	}
+
+	return inspection, nil
+}
+
+// InspectImage prints the metadata, lineage and embedded Bravefile of an image in the local image store
+func (bh *BraveHost) InspectImage(name string, asJSON bool) error {
+	inspection, err := inspectImage(name)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		content, err := json.MarshalIndent(inspection, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+
+	fmt.Printf("Image:         %s\n", inspection.Image)
+	if inspection.Target != "" {
+		fmt.Printf("Alias for:     %s\n", inspection.Target)
+	}
+	fmt.Printf("Digest:        %s\n", inspection.Digest)
+	fmt.Printf("Size:          %s\n", shared.FormatByteCountSI(inspection.Size))
+	fmt.Printf("Architecture:  %s\n", inspection.Architecture)
+	fmt.Printf("Created:       %s\n", inspection.Created.Local().Format(time.RFC1123))
+
+	if len(inspection.Lineage) > 0 {
+		fmt.Println("\nBase images:")
+		for i, base := range inspection.Lineage {
+			fmt.Printf("  %s└─ %s\n", strings.Repeat("  ", i), base)
+		}
+	}
+
+	printProperties := func(title string, properties map[string]string) {
+		if len(properties) == 0 {
+			return
+		}
+		fmt.Printf("\n%s:\n", title)
+
+		var keys []string
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		table := newPlainTable(os.Stdout, nil)
+		for _, key := range keys {
+			table.Append([]string{"  " + key, properties[key]})
+		}
+		table.Render()
+	}
+	printProperties("Labels", inspection.Labels)
+	printProperties("Properties", inspection.Properties)
+
+	fmt.Println("\nBravefile:")
+	if inspection.Bravefile == "" {
+		fmt.Println("  No Bravefile was recorded for this image")
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimRight(inspection.Bravefile, "\n"), "\n") {
+		fmt.Println("  " + line)
+	}
+
+	return nil
+}
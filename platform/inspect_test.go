@@ -0,0 +1,75 @@
+package platform
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bravetools/bravetools/shared"
+	"gopkg.in/yaml.v2"
+)
+
+func TestInspectImage(t *testing.T) {
+	newTestImageStore(t)
+
+	bravefile := shared.NewBravefile()
+	bravefile.Image = "app/1.0/x86_64"
+	bravefile.Base.Image = "base/2.0"
+	bravefile.Base.Location = "local"
+	bravefile.Run = []shared.RunCommand{{Command: "echo", Args: []string{"hello"}}}
+
+	properties, err := imageProperties(BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}, *bravefile, []string{"alpine/3.16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := yaml.Marshal(lxdImageMetadata{Architecture: "x86_64", CreationDate: 1700000000, Properties: properties})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "app.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err = gz.Write(writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, string(metadata)},
+		{"rootfs/", tar.TypeDir, ""},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.add(archive, BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	inspection, err := inspectImage("app/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(inspection.Lineage, []string{"base/2.0", "alpine/3.16"}) {
+		t.Fatalf("unexpected lineage %v", inspection.Lineage)
+	}
+	if inspection.Created.Unix() != 1700000000 || inspection.Properties["description"] == "" {
+		t.Fatalf("unexpected metadata %+v", inspection)
+	}
+
+	embedded, err := embeddedBravefile(map[string]string{bravefileProperty: inspection.Bravefile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if embedded.Base.Image != "base/2.0" || len(embedded.Run) != 1 || embedded.Run[0].Command != "echo" {
+		t.Fatalf("embedded Bravefile does not match build: %+v", embedded)
+	}
+}
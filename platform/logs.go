@@ -0,0 +1,291 @@
+package platform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+)
+
+// logFilesConfigKey records the log files of a unit, configured with the logs field of its service
+const logFilesConfigKey = "user.bravetools.logs"
+
+// consolePollInterval is how often the console log is read again when following it
+const consolePollInterval = time.Second
+
+// LogOptions controls which part of the logs of a unit is shown
+type LogOptions struct {
+	// Follow keeps streaming new output until interrupted
+	Follow bool
+	// Tail limits output to the last lines of each log - all lines are shown if negative
+	Tail int
+}
+
+// UnitLogs prints the console log of a unit, followed by the output of its services from the log files configured for
+// the unit or, if none are configured, from journalctl where the unit runs systemd
+func (bh *BraveHost) UnitLogs(name string, options LogOptions) error {
+	lxdServer, unitName, err := bh.connectUnit(name, false)
+	if err != nil {
+		return err
+	}
+
+	return streamUnitLogs(lxdServer, unitName, options, os.Stdout, &sync.Mutex{}, "")
+}
+
+// ComposeLogs interleaves the logs of the units of all services in a compose file, prefixing each line with the service name
+func (bh *BraveHost) ComposeLogs(composeFile *shared.ComposeFile, options LogOptions) error {
+	services, err := composeFile.TopologicalOrdering()
+	if err != nil {
+		return err
+	}
+
+	width := 0
+	for _, service := range services {
+		if len(service) > width {
+			width = len(service)
+		}
+	}
+
+	mutex := &sync.Mutex{}
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		unitName, err := composeUnitName(composeFile.Services[service])
+		if err != nil {
+			return err
+		}
+		lxdServer, unit, err := bh.connectUnit(unitName, false)
+		if err != nil {
+			return fmt.Errorf("service %q: %s", service, err)
+		}
+
+		prefix := fmt.Sprintf("%-*s | ", width, service)
+		wg.Add(1)
+		go func(i int, service string) {
+			defer wg.Done()
+			if err := streamUnitLogs(lxdServer, unit, options, os.Stdout, mutex, prefix); err != nil {
+				errs[i] = fmt.Errorf("service %q: %s", service, err)
+			}
+		}(i, service)
+	}
+	wg.Wait()
+
+	return shared.CollectErrors(errs...)
+}
+
+// streamUnitLogs writes the console and service logs of a unit to out as whole lines with a prefix, holding mutex while
+// writing. Followed logs are streamed concurrently.
+func streamUnitLogs(lxdServer lxd.InstanceServer, unitName string, options LogOptions, out io.Writer, mutex *sync.Mutex, prefix string) error {
+	state, _, err := lxdServer.GetInstanceState(unitName)
+	if err != nil {
+		return err
+	}
+
+	sources := []func(out io.Writer) error{
+		func(out io.Writer) error {
+			return consoleLog(lxdServer, unitName, options, out)
+		},
+	}
+
+	// Service logs are read by running commands in the unit
+	if state.Status == "Running" {
+		command, err := serviceLogCommand(lxdServer, unitName, options)
+		if err != nil {
+			return err
+		}
+		if command != nil {
+			sources = append(sources, func(out io.Writer) error {
+				code, err := runInUnit(lxdServer, unitName, command, nil, out, out)
+				if err == nil && code != 0 {
+					err = fmt.Errorf("%s exited with code %d", command[0], code)
+				}
+				return err
+			})
+		}
+	}
+
+	// Each source buffers its own partial lines
+	run := func(source func(out io.Writer) error) error {
+		lines := newLineWriter(out, mutex, prefix)
+		return shared.CollectErrors(source(lines), lines.Flush())
+	}
+
+	if !options.Follow {
+		for _, source := range sources {
+			if err = run(source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source func(out io.Writer) error) {
+			defer wg.Done()
+			errs[i] = run(source)
+		}(i, source)
+	}
+	wg.Wait()
+
+	return shared.CollectErrors(errs...)
+}
+
+// serviceLogCommand returns the command printing the service logs of a unit - tail for configured log files, otherwise
+// journalctl if the unit runs systemd. Nil is returned if the unit has neither.
+func serviceLogCommand(lxdServer lxd.InstanceServer, unitName string, options LogOptions) ([]string, error) {
+	instance, _, err := lxdServer.GetInstance(unitName)
+	if err != nil {
+		return nil, err
+	}
+
+	if files := instance.Config[logFilesConfigKey]; files != "" {
+		lines := "+1"
+		if options.Tail >= 0 {
+			lines = strconv.Itoa(options.Tail)
+		}
+		command := []string{"tail", "-n", lines}
+		if options.Follow {
+			command = append(command, "-F")
+		}
+		return append(command, strings.Split(files, ",")...), nil
+	}
+
+	code, err := runInUnit(lxdServer, unitName, []string{"sh", "-c", "command -v journalctl && test -d /run/systemd/system"}, nil, nil, nil)
+	if err != nil || code != 0 {
+		return nil, nil
+	}
+
+	command := []string{"journalctl", "--no-pager"}
+	if options.Tail >= 0 {
+		command = append(command, "-n", strconv.Itoa(options.Tail))
+	}
+	if options.Follow {
+		command = append(command, "-f")
+	}
+	return command, nil
+}
+
+// consoleLog writes the LXD console log of a unit to out, polling for new output if following
+func consoleLog(lxdServer lxd.InstanceServer, unitName string, options LogOptions, out io.Writer) error {
+	content, err := readConsoleLog(lxdServer, unitName)
+	if err != nil {
+		return fmt.Errorf("failed to read console log of unit %q: %s", unitName, err)
+	}
+	if _, err = out.Write(tailLines(content, options.Tail)); err != nil {
+		return err
+	}
+	if !options.Follow {
+		return nil
+	}
+
+	for {
+		time.Sleep(consolePollInterval)
+
+		previous := content
+		content, err = readConsoleLog(lxdServer, unitName)
+		if err != nil {
+			return fmt.Errorf("failed to read console log of unit %q: %s", unitName, err)
+		}
+		if _, err = out.Write(newConsoleOutput(previous, content)); err != nil {
+			return err
+		}
+	}
+}
+
+// newConsoleOutput returns the part of a console log not in the previous read of it. LXD keeps the console log in a ring
+// buffer, so once it is full old output is dropped from the start as new output is appended. The new output follows the
+// longest end of the previous read that the log starts with. If there is none the log started over, as when the unit restarts.
+func newConsoleOutput(previous []byte, content []byte) []byte {
+	for dropped := 0; dropped < len(previous); dropped++ {
+		if bytes.HasPrefix(content, previous[dropped:]) {
+			return content[len(previous)-dropped:]
+		}
+	}
+	return content
+}
+
+func readConsoleLog(lxdServer lxd.InstanceServer, unitName string) ([]byte, error) {
+	log, err := lxdServer.GetInstanceConsoleLog(unitName, &lxd.InstanceConsoleLogArgs{})
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	return ioutil.ReadAll(log)
+}
+
+// tailLines returns the last n lines of content, or all of it if n is negative
+func tailLines(content []byte, n int) []byte {
+	if n < 0 {
+		return content
+	}
+	if n == 0 {
+		return nil
+	}
+
+	end := len(content)
+	if end > 0 && content[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		newline := bytes.LastIndexByte(content[:end], '\n')
+		if newline < 0 {
+			return content
+		}
+		end = newline
+	}
+	return content[end+1:]
+}
+
+// lineWriter writes complete lines to a shared output with a prefix, so lines from concurrent writers do not mix
+type lineWriter struct {
+	out     io.Writer
+	mutex   *sync.Mutex
+	prefix  string
+	pending []byte
+}
+
+func newLineWriter(out io.Writer, mutex *sync.Mutex, prefix string) *lineWriter {
+	return &lineWriter{out: out, mutex: mutex, prefix: prefix}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for {
+		newline := bytes.IndexByte(w.pending, '\n')
+		if newline < 0 {
+			return len(p), nil
+		}
+		if _, err := fmt.Fprintf(w.out, "%s%s\n", w.prefix, w.pending[:newline]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[newline+1:]
+	}
+}
+
+// Flush writes a final line without a trailing newline
+func (w *lineWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w.out, "%s%s\n", w.prefix, w.pending)
+	w.pending = nil
+	return err
+}
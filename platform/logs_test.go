@@ -0,0 +1,66 @@
+package platform
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestTailLines(t *testing.T) {
+	content := []byte("one\ntwo\nthree\n")
+	cases := map[int]string{
+		-1: "one\ntwo\nthree\n",
+		0:  "",
+		1:  "three\n",
+		2:  "two\nthree\n",
+		5:  "one\ntwo\nthree\n",
+	}
+	for n, expected := range cases {
+		if tail := string(tailLines(content, n)); tail != expected {
+			t.Errorf("tailLines(%d) = %q, expected %q", n, tail, expected)
+		}
+	}
+	if tail := string(tailLines([]byte("one\ntwo"), 1)); tail != "two" {
+		t.Errorf("expected last line without trailing newline, got %q", tail)
+	}
+}
+
+func TestNewConsoleOutput(t *testing.T) {
+	cases := []struct {
+		previous string
+		content  string
+		expected string
+	}{
+		{"", "boot\n", "boot\n"},
+		{"boot\n", "boot\nready\n", "ready\n"},
+		{"boot\nready\n", "boot\nready\n", ""},
+		// A full ring buffer drops old output as new output arrives, so the length stays the same
+		{"one\ntwo\n", "ne\ntwo\nx\n", "x\n"},
+		{"one\ntwo\n", "two\nsix\n", "six\n"},
+		// The log starts over when the unit restarts
+		{"one\ntwo\n", "boot\n", "boot\n"},
+	}
+	for _, c := range cases {
+		if output := string(newConsoleOutput([]byte(c.previous), []byte(c.content))); output != c.expected {
+			t.Errorf("newConsoleOutput(%q, %q) = %q, expected %q", c.previous, c.content, output, c.expected)
+		}
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var out bytes.Buffer
+	mutex := &sync.Mutex{}
+	api := newLineWriter(&out, mutex, "api | ")
+	db := newLineWriter(&out, mutex, "db  | ")
+
+	api.Write([]byte("star"))
+	db.Write([]byte("ready\n"))
+	api.Write([]byte("ted\nlisten"))
+	api.Flush()
+	db.Flush()
+
+	expected := "db  | ready\napi | started\napi | listen\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output %q, expected %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,858 @@
+package platform
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	"gopkg.in/yaml.v2"
+)
+
+// Media types of OCI and Docker image indexes, used to find the manifest for this platform
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	dockerListMediaType  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// Whiteout files mark paths deleted by a layer
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ociDigestPattern matches the only blob digests accepted in an OCI image layout
+var ociDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ociInitScript keeps a system container running for images without an init system
+const ociInitScript = `#!/bin/sh
+# Generated by bravetools - the imported image has no init system
+trap 'exit 0' TERM INT PWR
+while :; do sleep 3600 & wait $!; done
+`
+
+// ociDescriptor references a blob in an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// ociIndex is an OCI image index or Docker manifest list
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI or Docker image manifest
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+	Manifests     []ociDescriptor `json:"manifests,omitempty"`
+}
+
+// importBlob is a blob of an imported archive. Its content is checked against digest as it is read, unless digest is empty.
+type importBlob struct {
+	path   string
+	digest string
+}
+
+// dockerManifest is an entry of the manifest.json written by docker save
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ociImageConfig holds the parts of an image config carried into bravetools images
+type ociImageConfig struct {
+	Created      string `json:"created,omitempty"`
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Env          []string            `json:"Env,omitempty"`
+		Entrypoint   []string            `json:"Entrypoint,omitempty"`
+		Cmd          []string            `json:"Cmd,omitempty"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+		WorkingDir   string              `json:"WorkingDir,omitempty"`
+		User         string              `json:"User,omitempty"`
+		Labels       map[string]string   `json:"Labels,omitempty"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ociImage describes an OCI or Docker image archive converted to an LXD image
+type ociImage struct {
+	Reference string
+	Config    ociImageConfig
+}
+
+// lxdImageMetadata is the metadata.yaml of an LXD image
+type lxdImageMetadata struct {
+	Architecture string            `yaml:"architecture"`
+	CreationDate int64             `yaml:"creation_date"`
+	Properties   map[string]string `yaml:"properties"`
+}
+
+// layerEntry records the layer providing a path of the flattened rootfs
+type layerEntry struct {
+	layer    int
+	typeflag byte
+	linkname string
+}
+
+// ImportOCIImage converts an OCI image layout or docker save archive into an LXD image and adds it to the local image store.
+// The image config is carried into a Bravefile service section written to bravefilePath.
+func (bh *BraveHost) ImportOCIImage(archivePath string, imageName string, bravefilePath string) error {
+	tmpDir, err := ioutil.TempDir("", "bravetools-oci-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imagePath := filepath.Join(tmpDir, "image.tar.gz")
+	ociImg, err := convertOCIArchive(archivePath, imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to convert %q: %s", archivePath, err)
+	}
+
+	if imageName == "" {
+		imageName = ociImageName(ociImg.Reference, archivePath)
+	}
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+	if image.Version == "" {
+		image.Version = defaultImageVersion
+	}
+	if image.Architecture == "" {
+		image.Architecture = ociArchitecture(ociImg.Config)
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	record, err := store.add(imagePath, image, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %q into bravetools as image %q (%s)\n", filepath.Base(archivePath), image, shortDigest(record.Digest))
+
+	if bravefilePath == "" {
+		return nil
+	}
+	if shared.FileExists(bravefilePath) {
+		fmt.Println(shared.Warn(fmt.Sprintf("Warning: %s already exists - not writing generated Bravefile", bravefilePath)))
+		return nil
+	}
+
+	bravefile, err := yaml.Marshal(ociBravefile(image, ociImg.Config))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(bravefilePath, bravefile, 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated Bravefile service for %q at %s\n", image, bravefilePath)
+	return nil
+}
+
+// convertOCIArchive flattens the layers of an OCI or Docker image archive into a unified LXD image at destPath
+func convertOCIArchive(archivePath string, destPath string) (*ociImage, error) {
+	layoutDir, err := ioutil.TempDir("", "bravetools-oci-layout-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(layoutDir)
+
+	err = extractArchive(archivePath, layoutDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []importBlob
+	var config importBlob
+	var reference string
+
+	if shared.FileExists(filepath.Join(layoutDir, "manifest.json")) {
+		layers, config, reference, err = readDockerManifest(layoutDir)
+	} else if shared.FileExists(filepath.Join(layoutDir, "index.json")) {
+		layers, config, reference, err = readOCILayout(layoutDir)
+	} else {
+		err = errors.New("archive is neither an OCI image layout nor a docker save archive")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := readBlob(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %s", err)
+	}
+
+	img := &ociImage{Reference: reference}
+	err = json.Unmarshal(content, &img.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image config: %s", err)
+	}
+
+	err = writeLXDImage(destPath, layers, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// extractArchive unpacks the regular files of a (possibly gzip compressed) tar archive into dir
+func extractArchive(archivePath string, dir string) (err error) {
+	tr, closer, err := openTar(archivePath)
+	if err != nil {
+		return err
+	}
+	defer closeArchive(closer, &err)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := cleanArchivePath(hdr.Name)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err = shared.CollectErrors(err, f.Close()); err != nil {
+			return err
+		}
+	}
+}
+
+// readDockerManifest returns the layers, config and tag of the first image in a docker save archive
+func readDockerManifest(dir string) (layers []importBlob, config importBlob, reference string, err error) {
+	var manifests []dockerManifest
+	err = readJSON(importBlob{path: filepath.Join(dir, "manifest.json")}, &manifests)
+	if err != nil {
+		return nil, config, "", err
+	}
+	if len(manifests) == 0 {
+		return nil, config, "", errors.New("manifest.json lists no images")
+	}
+	if len(manifests) > 1 {
+		fmt.Println(shared.Warn(fmt.Sprintf("Warning: archive contains %d images - importing the first", len(manifests))))
+	}
+
+	manifest := manifests[0]
+	for _, layer := range manifest.Layers {
+		blob, err := dockerArchiveBlob(dir, layer)
+		if err != nil {
+			return nil, config, "", err
+		}
+		layers = append(layers, blob)
+	}
+	if len(manifest.RepoTags) > 0 {
+		reference = manifest.RepoTags[0]
+	}
+
+	config, err = dockerArchiveBlob(dir, manifest.Config)
+	if err != nil {
+		return nil, config, "", err
+	}
+
+	return layers, config, reference, nil
+}
+
+// dockerArchiveBlob returns the blob for a file listed in manifest.json, which must be a relative path inside the archive
+func dockerArchiveBlob(dir string, name string) (importBlob, error) {
+	cleaned, ok := cleanArchivePath(name)
+	if !ok || cleaned != name {
+		return importBlob{}, fmt.Errorf("invalid path %q in manifest.json", name)
+	}
+	return importBlob{path: filepath.Join(dir, filepath.FromSlash(cleaned))}, nil
+}
+
+// readOCILayout returns the layers, config and reference name of the image in an OCI image layout matching this platform
+func readOCILayout(dir string) (layers []importBlob, config importBlob, reference string, err error) {
+	var index ociIndex
+	err = readJSON(importBlob{path: filepath.Join(dir, "index.json")}, &index)
+	if err != nil {
+		return nil, config, "", err
+	}
+
+	descriptor, err := selectManifest(index.Manifests)
+	if err != nil {
+		return nil, config, "", err
+	}
+	reference = descriptor.Annotations[ociRefNameAnnotation]
+
+	// Follow nested indexes down to an image manifest
+	var manifest ociManifest
+	for {
+		blob, err := ociBlobPath(dir, descriptor.Digest)
+		if err != nil {
+			return nil, config, "", err
+		}
+		err = readJSON(blob, &manifest)
+		if err != nil {
+			return nil, config, "", err
+		}
+		if manifest.MediaType != ociIndexMediaType && manifest.MediaType != dockerListMediaType && len(manifest.Manifests) == 0 {
+			break
+		}
+
+		descriptor, err = selectManifest(manifest.Manifests)
+		if err != nil {
+			return nil, config, "", err
+		}
+		manifest = ociManifest{}
+	}
+
+	for _, layer := range manifest.Layers {
+		blob, err := ociBlobPath(dir, layer.Digest)
+		if err != nil {
+			return nil, config, "", err
+		}
+		layers = append(layers, blob)
+	}
+
+	config, err = ociBlobPath(dir, manifest.Config.Digest)
+	if err != nil {
+		return nil, config, "", err
+	}
+	return layers, config, reference, nil
+}
+
+// selectManifest picks the manifest for the architecture of this host, falling back to the first one
+func selectManifest(manifests []ociDescriptor) (ociDescriptor, error) {
+	if len(manifests) == 0 {
+		return ociDescriptor{}, errors.New("image index lists no manifests")
+	}
+
+	for _, manifest := range manifests {
+		if manifest.Platform != nil && sameArchitecture(manifest.Platform.Architecture, runtime.GOARCH) {
+			return manifest, nil
+		}
+	}
+	return manifests[0], nil
+}
+
+// ociBlobPath returns the blob of an OCI image layout with a digest, refusing digests that are not plain sha256 hashes
+func ociBlobPath(dir string, digest string) (importBlob, error) {
+	if !ociDigestPattern.MatchString(digest) {
+		return importBlob{}, fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return importBlob{path: filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, digestPrefix)), digest: digest}, nil
+}
+
+// readBlob reads a blob and checks its digest
+func readBlob(blob importBlob) ([]byte, error) {
+	content, err := ioutil.ReadFile(blob.path)
+	if err != nil {
+		return nil, err
+	}
+	if blob.digest != "" {
+		sum := sha256.Sum256(content)
+		err = checkBlobDigest(blob, hex.EncodeToString(sum[:]))
+	}
+	return content, err
+}
+
+// checkBlobDigest compares the hex encoded sha256 of the content read from a blob with its expected digest
+func checkBlobDigest(blob importBlob, sum string) error {
+	if digestPrefix+sum != blob.digest {
+		return fmt.Errorf("blob %s does not match its digest - found %s%s", blob.digest, digestPrefix, sum)
+	}
+	return nil
+}
+
+func readJSON(blob importBlob, v interface{}) error {
+	content, err := readBlob(blob)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", filepath.Base(blob.path), err)
+	}
+	return nil
+}
+
+// openTar opens a tar archive, decompressing it if it is gzip, bzip2, xz or zstd compressed
+func openTar(archivePath string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, closer, err := decompressReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to open %s: %s", filepath.Base(archivePath), err)
+	}
+
+	return tar.NewReader(content), archiveCloser{closer, f}, nil
+}
+
+// archiveCloser releases the decompressor of an archive before closing its file
+type archiveCloser []io.Closer
+
+func (closers archiveCloser) stopReading() {
+	for _, closer := range closers {
+		stopReading(closer)
+	}
+}
+
+func (closers archiveCloser) Close() error {
+	var err error
+	for _, closer := range closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// cleanArchivePath returns a tar entry name relative to the archive root, rejecting the root itself
+func cleanArchivePath(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name, name != ""
+}
+
+// indexLayers works out which layer provides each path of the flattened rootfs, applying whiteouts
+func indexLayers(layers []importBlob) (map[string]layerEntry, error) {
+	entries := map[string]layerEntry{}
+	// children indexes the paths directly below each directory, including directories with no entry of their own
+	children := map[string]map[string]bool{}
+
+	addEntry := func(name string, entry layerEntry) {
+		entries[name] = entry
+		for name != "" {
+			dir := path.Dir(name)
+			if dir == "." {
+				dir = ""
+			}
+			if children[dir][name] {
+				return
+			}
+			if children[dir] == nil {
+				children[dir] = map[string]bool{}
+			}
+			children[dir][name] = true
+			name = dir
+		}
+	}
+
+	// Remove paths below dir provided by layers lower than the current one
+	var removeChildren func(dir string, layer int)
+	removeChildren = func(dir string, layer int) {
+		for name := range children[dir] {
+			if entry, ok := entries[name]; ok && entry.layer < layer {
+				delete(entries, name)
+			}
+			removeChildren(name, layer)
+			if _, ok := entries[name]; !ok && len(children[name]) == 0 {
+				delete(children[dir], name)
+				delete(children, name)
+			}
+		}
+	}
+
+	for i, layer := range layers {
+		err := walkLayer(layer, func(name string, hdr *tar.Header, tr *tar.Reader) error {
+			dir, base := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+
+			switch {
+			case base == whiteoutOpaque:
+				removeChildren(dir, i)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				if entry, ok := entries[target]; ok && entry.layer < i {
+					delete(entries, target)
+				}
+				removeChildren(target, i)
+			default:
+				if hdr.Typeflag != tar.TypeDir {
+					removeChildren(name, i)
+				}
+				addEntry(name, layerEntry{layer: i, typeflag: hdr.Typeflag, linkname: hdr.Linkname})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %d: %s", i+1, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// walkLayer calls fn for every entry of a layer archive, then checks the digest of the layer
+func walkLayer(layer importBlob, fn func(name string, hdr *tar.Header, tr *tar.Reader) error) error {
+	f, err := os.Open(layer.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	content, closer, err := decompressReader(io.TeeReader(f, hash))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", filepath.Base(layer.path), err)
+	}
+
+	err = walkTar(tar.NewReader(content), fn)
+	if err == nil && layer.digest != "" {
+		// Read past the end of the tar stream so that the whole blob is hashed
+		_, err = io.Copy(ioutil.Discard, content)
+	}
+	closeArchive(closer, &err)
+	if err != nil || layer.digest == "" {
+		return err
+	}
+
+	// The decompressor has exited, so hash anything it left unread
+	if _, err = io.Copy(hash, f); err != nil {
+		return err
+	}
+	return checkBlobDigest(layer, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// walkTar calls fn for every entry of a tar stream with a name below the archive root
+func walkTar(tr *tar.Reader, fn func(name string, hdr *tar.Header, tr *tar.Reader) error) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := cleanArchivePath(hdr.Name)
+		if !ok {
+			continue
+		}
+
+		err = fn(name, hdr, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeLXDImage writes a unified LXD image holding metadata.yaml and the flattened layers under rootfs/
+func writeLXDImage(destPath string, layers []importBlob, img *ociImage) error {
+	entries, err := indexLayers(layers)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	metadata, err := yaml.Marshal(lxdImageMetadata{
+		Architecture: ociArchitecture(img.Config),
+		CreationDate: time.Now().Unix(),
+		Properties: map[string]string{
+			"description":   "Imported from " + img.Reference,
+			"os":            img.Config.OS,
+			lineageProperty: img.Reference,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeTarFile(tw, "metadata.yaml", metadata, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writeTarDir(tw, "rootfs", 0755)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range layers {
+		err = walkLayer(layer, func(name string, hdr *tar.Header, tr *tar.Reader) error {
+			if entry, ok := entries[name]; !ok || entry.layer != i {
+				return nil
+			}
+
+			out := *hdr
+			out.Name = "rootfs/" + name
+			if hdr.Typeflag == tar.TypeDir {
+				out.Name += "/"
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				target, _ := cleanArchivePath(hdr.Linkname)
+				if _, ok := entries[target]; !ok {
+					return nil
+				}
+				out.Linkname = "rootfs/" + target
+			}
+
+			err := tw.WriteHeader(&out)
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				_, err = io.Copy(tw, tr)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to flatten layer %d: %s", i+1, err)
+		}
+	}
+
+	// System containers need mount points for the kernel filesystems LXD provides
+	var missing []string
+	for _, dir := range []string{"dev", "proc", "sys", "run", "tmp"} {
+		if _, ok := entries[dir]; !ok {
+			missing = append(missing, dir)
+		}
+	}
+	sort.Strings(missing)
+	for _, dir := range missing {
+		mode := int64(0755)
+		if dir == "tmp" {
+			mode = 01777
+		}
+		err = writeTarDir(tw, "rootfs/"+dir, mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Application images rarely ship an init system - keep the container running with a shell loop when possible
+	if initPath := ociInitPath(entries); initPath != "" {
+		err = writeTarFile(tw, "rootfs/"+initPath, []byte(ociInitScript), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ociInitPath returns where to write a generated init script, or "" if the image has an init system or no shell
+func ociInitPath(entries map[string]layerEntry) string {
+	if _, ok := entries["sbin/init"]; ok {
+		return ""
+	}
+	if _, ok := entries["bin/sh"]; !ok {
+		return ""
+	}
+
+	// Follow a merged /usr symlink so that the script is not written through it
+	dir := "sbin"
+	if entry, ok := entries[dir]; ok && entry.typeflag == tar.TypeSymlink {
+		target := entry.linkname
+		if !path.IsAbs(target) {
+			target = path.Join("/", target)
+		}
+		dir, _ = cleanArchivePath(target)
+		if _, ok := entries[path.Join(dir, "init")]; ok {
+			return ""
+		}
+	}
+
+	return path.Join(dir, "init")
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, mode int64) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(content)
+	return err
+}
+
+func writeTarDir(tw *tar.Writer, name string, mode int64) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     mode,
+		ModTime:  time.Now(),
+	})
+}
+
+// ociArchitecture returns the LXD architecture name of an image config, defaulting to this host
+func ociArchitecture(config ociImageConfig) string {
+	if config.Architecture == "" {
+		return normalizeArchitecture(runtime.GOARCH)
+	}
+	return normalizeArchitecture(config.Architecture)
+}
+
+// ociImageName derives a bravetools image name from an image reference such as docker.io/library/nginx:1.25,
+// falling back to the archive file name
+func ociImageName(reference string, archivePath string) string {
+	name := reference
+	version := ""
+
+	// Strip digests and split tag from repository - a colon before the last slash belongs to a registry port
+	name = strings.SplitN(name, "@", 2)[0]
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, version = name[:i], name[i+1:]
+	}
+	name = path.Base(name)
+
+	if name == "" || name == "." || name == "/" {
+		name = strings.SplitN(filepath.Base(archivePath), ".", 2)[0]
+	}
+
+	sanitize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if validImageFieldChar(r) {
+				return r
+			}
+			return '-'
+		}, s)
+	}
+
+	if version == "" {
+		return sanitize(name)
+	}
+	return sanitize(name) + "/" + sanitize(version)
+}
+
+// ociBravefile generates a Bravefile service section deploying an imported image with its environment,
+// exposed ports and entrypoint
+func ociBravefile(image BravetoolsImage, config ociImageConfig) *shared.Bravefile {
+	bravefile := shared.NewBravefile()
+	bravefile.PlatformService.Name = image.Name
+	bravefile.PlatformService.Image = image.String()
+
+	if len(config.Config.Env) > 0 {
+		bravefile.PlatformService.Environment = map[string]string{}
+		for _, env := range config.Config.Env {
+			kv := strings.SplitN(env, "=", 2)
+			if len(kv) == 2 {
+				bravefile.PlatformService.Environment[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	var ports []string
+	for port := range config.Config.ExposedPorts {
+		split := strings.SplitN(port, "/", 2)
+		if len(split) == 2 && split[1] != "tcp" {
+			continue
+		}
+		ports = append(ports, split[0]+":"+split[0])
+	}
+	sort.Strings(ports)
+	bravefile.PlatformService.Ports = ports
+
+	command := append(append([]string{}, config.Config.Entrypoint...), config.Config.Cmd...)
+	if len(command) > 0 {
+		run := shared.RunCommand{
+			Command: command[0],
+			Args:    command[1:],
+			Detach:  true,
+		}
+
+		// Run through a shell when a working directory or user has to be applied
+		if config.Config.WorkingDir != "" || config.Config.User != "" {
+			script := "exec " + shellJoin(command)
+			if config.Config.WorkingDir != "" {
+				script = "cd " + shellQuote(config.Config.WorkingDir) + " && " + script
+			}
+			if config.Config.User != "" {
+				script = "exec su -s /bin/sh " + shellQuote(strings.SplitN(config.Config.User, ":", 2)[0]) + " -c " + shellQuote(script)
+			}
+			run = shared.RunCommand{
+				Command: "sh",
+				Args:    []string{"-c", script},
+				Detach:  true,
+			}
+		}
+
+		bravefile.PlatformService.Postdeploy.Run = []shared.RunCommand{run}
+	}
+
+	return bravefile
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
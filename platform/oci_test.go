@@ -0,0 +1,401 @@
+package platform
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bravetools/bravetools/shared"
+	"gopkg.in/yaml.v2"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+}
+
+func writeTestTar(t *testing.T, entries []testTarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644, Size: int64(len(entry.content))}
+		if entry.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestConvertDockerArchive(t *testing.T) {
+	lower := writeTestTar(t, []testTarEntry{
+		{"bin/", tar.TypeDir, ""},
+		{"bin/sh", tar.TypeReg, "shell"},
+		{"etc/", tar.TypeDir, ""},
+		{"etc/deleted", tar.TypeReg, "a"},
+		{"etc/kept", tar.TypeReg, "b"},
+		{"var/cache/", tar.TypeDir, ""},
+		{"var/cache/old", tar.TypeReg, "old"},
+	})
+	upper := writeTestTar(t, []testTarEntry{
+		{"etc/.wh.deleted", tar.TypeReg, ""},
+		{"etc/kept", tar.TypeReg, "updated"},
+		{"var/cache/.wh..wh..opq", tar.TypeReg, ""},
+		{"var/cache/new", tar.TypeReg, "new"},
+	})
+
+	config := ociImageConfig{Architecture: "arm64", OS: "linux"}
+	config.Config.Env = []string{"PATH=/usr/bin:/bin"}
+	configJSON, _ := json.Marshal(config)
+	manifest, _ := json.Marshal([]dockerManifest{{Config: "config.json", RepoTags: []string{"nginx:1.25"}, Layers: []string{"lower/layer.tar", "upper/layer.tar"}}})
+
+	archive := filepath.Join(t.TempDir(), "nginx.tar")
+	if err := os.WriteFile(archive, writeTestTar(t, []testTarEntry{
+		{"manifest.json", tar.TypeReg, string(manifest)},
+		{"config.json", tar.TypeReg, string(configJSON)},
+		{"lower/layer.tar", tar.TypeReg, string(lower)},
+		{"upper/layer.tar", tar.TypeReg, string(upper)},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "image.tar.gz")
+	img, err := convertOCIArchive(archive, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Reference != "nginx:1.25" || ociArchitecture(img.Config) != "aarch64" {
+		t.Fatalf("unexpected image reference %q and architecture %q", img.Reference, ociArchitecture(img.Config))
+	}
+
+	f, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			content, _ := io.ReadAll(tr)
+			files[hdr.Name] = string(content)
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"metadata.yaml", "rootfs/bin/sh", "rootfs/etc/kept", "rootfs/sbin/init", "rootfs/var/cache/new"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected files %v, found %v", expected, names)
+	}
+	if files["rootfs/etc/kept"] != "updated" {
+		t.Fatalf("expected upper layer to replace file, found %q", files["rootfs/etc/kept"])
+	}
+}
+
+func TestConvertDockerArchiveMaliciousManifest(t *testing.T) {
+	manifests := []dockerManifest{
+		{Config: "../outside.json", Layers: []string{"layer.tar"}},
+		{Config: "config.json", Layers: []string{"/etc/passwd"}},
+		{Config: "config.json", Layers: []string{"nested/../../layer.tar"}},
+	}
+
+	for _, m := range manifests {
+		manifest, _ := json.Marshal([]dockerManifest{m})
+		archive := filepath.Join(t.TempDir(), "image.tar")
+		if err := os.WriteFile(archive, writeTestTar(t, []testTarEntry{
+			{"manifest.json", tar.TypeReg, string(manifest)},
+			{"config.json", tar.TypeReg, "{}"},
+			{"layer.tar", tar.TypeReg, ""},
+		}), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := convertOCIArchive(archive, filepath.Join(t.TempDir(), "image.tar.gz"))
+		if err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Errorf("expected manifest with config %q and layers %v to be rejected, found %v", m.Config, m.Layers, err)
+		}
+	}
+}
+
+func TestOCIImageName(t *testing.T) {
+	cases := map[string]string{
+		"nginx:1.25":                         "nginx/1.25",
+		"docker.io/library/redis:7.2-alpine": "redis/7.2-alpine",
+		"localhost:5000/team/api":            "api",
+		"":                                   "archive",
+	}
+	for reference, expected := range cases {
+		if name := ociImageName(reference, "/tmp/archive.tar"); name != expected {
+			t.Errorf("ociImageName(%q) = %q, expected %q", reference, name, expected)
+		}
+	}
+}
+
+func TestOCIBravefile(t *testing.T) {
+	var config ociImageConfig
+	config.Config.Env = []string{"PATH=/usr/bin", "MODE=prod"}
+	config.Config.Entrypoint = []string{"/docker-entrypoint.sh"}
+	config.Config.Cmd = []string{"nginx", "-g", "daemon off;"}
+	config.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}, "443/tcp": {}, "53/udp": {}}
+
+	bravefile := ociBravefile(BravetoolsImage{Name: "nginx", Version: "1.25", Architecture: "x86_64"}, config)
+	service := bravefile.PlatformService
+
+	if service.Image != "nginx/1.25/x86_64" || service.Environment["MODE"] != "prod" {
+		t.Fatalf("unexpected service %+v", service)
+	}
+	if !reflect.DeepEqual(service.Ports, []string{"443:443", "80:80"}) {
+		t.Fatalf("expected tcp ports to be forwarded, found %v", service.Ports)
+	}
+
+	run := service.Postdeploy.Run
+	if len(run) != 1 || run[0].Command != "/docker-entrypoint.sh" || !reflect.DeepEqual(run[0].Args, []string{"nginx", "-g", "daemon off;"}) || !run[0].Detach {
+		t.Fatalf("unexpected entrypoint %+v", run)
+	}
+}
+
+func TestExportOCIRoundTrip(t *testing.T) {
+	lxdImage := filepath.Join(t.TempDir(), "app.tar.gz")
+	f, err := os.Create(lxdImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err = gz.Write(writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: aarch64\ncreation_date: 0\n"},
+		{"rootfs/", tar.TypeDir, ""},
+		{"rootfs/sbin/init", tar.TypeReg, "init"},
+		{"rootfs/etc/app.conf", tar.TypeReg, "conf"},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	service := &shared.NewBravefile().PlatformService
+	service.Ports = []string{"8080:80"}
+	service.Postdeploy.Run = []shared.RunCommand{{Command: "app", Args: []string{"--serve"}, Detach: true}}
+	record := imageRecord{Name: "App", Version: "1.0", Labels: map[string]string{"team": "core"}}
+
+	for _, format := range []string{ExportFormatOCI, ExportFormatDocker} {
+		exported := filepath.Join(t.TempDir(), "app."+format+".tar")
+		if err := writeOCIArchive(lxdImage, exported, t.TempDir(), format, ociExportConfig(record, service)); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		img, err := convertOCIArchive(exported, filepath.Join(t.TempDir(), "image.tar.gz"))
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if img.Reference != "app:1.0" {
+			t.Errorf("%s: unexpected reference %q", format, img.Reference)
+		}
+		if ociArchitecture(img.Config) != "aarch64" {
+			t.Errorf("%s: expected architecture aarch64, found %q", format, img.Config.Architecture)
+		}
+		if _, ok := img.Config.Config.ExposedPorts["8080/tcp"]; !ok {
+			t.Errorf("%s: expected unit port to be exposed, found %v", format, img.Config.Config.ExposedPorts)
+		}
+		if !reflect.DeepEqual(img.Config.Config.Cmd, []string{"app", "--serve"}) || img.Config.Config.Labels["team"] != "core" {
+			t.Errorf("%s: unexpected config %+v", format, img.Config.Config)
+		}
+	}
+}
+
+func TestExportOCIEmbeddedBravefile(t *testing.T) {
+	newTestImageStore(t)
+	image := BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}
+
+	bravefile := shared.NewBravefile()
+	bravefile.Base.Image = "alpine/3.16"
+	bravefile.PlatformService.Ports = []string{"8080:80"}
+	bravefile.PlatformService.Postdeploy.Run = []shared.RunCommand{{Command: "app", Args: []string{"--serve"}, Detach: true}}
+	properties, err := imageProperties(image, *bravefile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := yaml.Marshal(lxdImageMetadata{Architecture: "x86_64", Properties: properties})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lxdImage := filepath.Join(t.TempDir(), "app.tar.gz")
+	f, err := os.Create(lxdImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err = gz.Write(writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, string(metadata)},
+		{"rootfs/", tar.TypeDir, ""},
+		{"rootfs/sbin/init", tar.TypeReg, "init"},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.add(lxdImage, image, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without --bravefile the service of the Bravefile embedded in the image is used
+	outputDir := t.TempDir()
+	if err = ExportOCIImage(image.String(), outputDir, ExportFormatOCI, ""); err != nil {
+		t.Fatal(err)
+	}
+	img, err := convertOCIArchive(filepath.Join(outputDir, image.ToBasename()+".oci.tar"), filepath.Join(t.TempDir(), "image.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.Config.Config.ExposedPorts["8080/tcp"]; !ok || !reflect.DeepEqual(img.Config.Config.Cmd, []string{"app", "--serve"}) {
+		t.Errorf("expected config from embedded Bravefile, found %+v", img.Config.Config)
+	}
+}
+
+func TestReadOCILayoutDigests(t *testing.T) {
+	layer := writeTestTar(t, []testTarEntry{{"bin/sh", tar.TypeReg, "shell"}})
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+
+	digestOf := func(content []byte) string {
+		return digestPrefix + fmt.Sprintf("%x", sha256.Sum256(content))
+	}
+
+	// writeLayout writes an OCI layout whose manifest references the layer by layerDigest, storing layerContent as the blob
+	writeLayout := func(layerDigest string, layerContent []byte) string {
+		dir := t.TempDir()
+		blobs := filepath.Join(dir, "blobs", "sha256")
+		if err := os.MkdirAll(blobs, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeBlob := func(digest string, content []byte) {
+			if ociDigestPattern.MatchString(digest) {
+				if err := os.WriteFile(filepath.Join(blobs, strings.TrimPrefix(digest, digestPrefix)), content, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		manifest, _ := json.Marshal(ociManifest{
+			SchemaVersion: 2,
+			Config:        ociDescriptor{Digest: digestOf(config)},
+			Layers:        []ociDescriptor{{Digest: layerDigest}},
+		})
+		index, _ := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{{Digest: digestOf(manifest)}}})
+
+		writeBlob(digestOf(config), config)
+		writeBlob(digestOf(manifest), manifest)
+		writeBlob(layerDigest, layerContent)
+		if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	layers, _, _, err := readOCILayout(writeLayout(digestOf(layer), layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = indexLayers(layers); err != nil {
+		t.Fatalf("expected layer to match its digest: %s", err)
+	}
+
+	// A layer whose content was altered fails when it is read
+	tampered := bytes.Replace(layer, []byte("shell"), []byte("SHELL"), 1)
+	layers, _, _, err = readOCILayout(writeLayout(digestOf(layer), tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = indexLayers(layers); err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Fatalf("expected digest mismatch, found %v", err)
+	}
+
+	// Digests must not be able to point outside the blob directory
+	for _, digest := range []string{"sha256:../../index.json", "sha512:" + strings.Repeat("0", 128), "sha256:" + strings.Repeat("A", 64)} {
+		if _, _, _, err = readOCILayout(writeLayout(digest, layer)); err == nil || !strings.Contains(err.Error(), "invalid blob digest") {
+			t.Errorf("expected digest %q to be refused, found %v", digest, err)
+		}
+	}
+}
+
+func TestIndexLayersWhiteouts(t *testing.T) {
+	dir := t.TempDir()
+	writeLayer := func(name string, entries []testTarEntry) importBlob {
+		layerPath := filepath.Join(dir, name)
+		if err := os.WriteFile(layerPath, writeTestTar(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return importBlob{path: layerPath}
+	}
+
+	layers := []importBlob{
+		writeLayer("lower", []testTarEntry{
+			{"opt/app/bin/run", tar.TypeReg, "run"},
+			{"opt/app/data/db", tar.TypeReg, "db"},
+			{"opt/other", tar.TypeReg, "other"},
+			{"srv/", tar.TypeDir, ""},
+			{"srv/www/index.html", tar.TypeReg, "index"},
+		}),
+		writeLayer("upper", []testTarEntry{
+			{"opt/app/", tar.TypeDir, ""},
+			{"opt/app/.wh..wh..opq", tar.TypeReg, ""},
+			{"opt/app/bin/new", tar.TypeReg, "new"},
+			{"srv/.wh.www", tar.TypeReg, ""},
+		}),
+	}
+
+	entries, err := indexLayers(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"opt/app", "opt/app/bin/new", "opt/other", "srv"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected paths %v, found %v", expected, names)
+	}
+}
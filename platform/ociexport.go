@@ -0,0 +1,435 @@
+package platform
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	"gopkg.in/yaml.v2"
+)
+
+// Image export formats
+const (
+	ExportFormatLXD    = "lxd"
+	ExportFormatOCI    = "oci"
+	ExportFormatDocker = "docker"
+)
+
+// Media types of the OCI image layout written on export
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// OCI images name architectures after Go - LXD kernel architecture names are mapped onto them
+var ociArchitectureNames = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"i686":    "386",
+}
+
+// ociArchitectureName returns the OCI name of an LXD architecture
+func ociArchitectureName(arch string) string {
+	arch = normalizeArchitecture(arch)
+	if name, ok := ociArchitectureNames[arch]; ok {
+		return name
+	}
+	return arch
+}
+
+// ExportOCIImage converts an image in the local image store into an OCI image layout archive (format "oci")
+// or a docker-archive (format "docker") that can be loaded by Docker or Podman. Labels of the image and the
+// ports, environment and command of the Bravefile service at bravefilePath, if provided, become OCI config.
+func ExportOCIImage(imageName string, outputDir string, format string, bravefilePath string) error {
+	if format != ExportFormatOCI && format != ExportFormatDocker {
+		return fmt.Errorf("unknown export format %q - expected %q, %q or %q", format, ExportFormatLXD, ExportFormatOCI, ExportFormatDocker)
+	}
+
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	record, err := store.match(image)
+	if err != nil {
+		return err
+	}
+	resolvedImg := record.image()
+
+	var service *shared.Service
+	if bravefilePath != "" {
+		bravefile := shared.NewBravefile()
+		err = bravefile.Load(bravefilePath)
+		if err != nil {
+			return err
+		}
+		service = &bravefile.PlatformService
+	} else {
+		// Fall back to the Bravefile the image was built from
+		metadata, err := readImageMetadata(store.blobPath(record.Digest))
+		if err != nil {
+			return fmt.Errorf("failed to read metadata of image %q: %s", resolvedImg, err)
+		}
+		bravefile, err := embeddedBravefile(metadata.Properties)
+		if err != nil {
+			return err
+		}
+		if bravefile != nil {
+			service = &bravefile.PlatformService
+		}
+	}
+
+	destPath := resolvedImg.ToBasename() + "." + format + ".tar"
+	if outputDir != "" {
+		destPath = filepath.Join(outputDir, destPath)
+	}
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("existing file at %s would be overwritten by export of %q", destPath, resolvedImg)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "bravetools-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = writeOCIArchive(store.blobPath(record.Digest), destPath, tmpDir, format, ociExportConfig(*record, service))
+	if err != nil {
+		os.Remove(destPath)
+		return fmt.Errorf("failed to export %q as %s image: %s", resolvedImg, format, err)
+	}
+
+	fmt.Printf("Exported image %q to: %s\n", resolvedImg, destPath)
+	return nil
+}
+
+// ociExportConfig builds the OCI config of an exported image from its record and Bravefile service
+func ociExportConfig(record imageRecord, service *shared.Service) ociImageConfig {
+	var config ociImageConfig
+	config.Created = record.Created.UTC().Format(time.RFC3339)
+	config.Architecture = ociArchitectureName(record.Architecture)
+	config.OS = "linux"
+
+	config.Config.Labels = map[string]string{
+		"org.opencontainers.image.title":   record.Name,
+		"org.opencontainers.image.version": record.Version,
+		"org.opencontainers.image.created": config.Created,
+	}
+	for key, value := range record.Labels {
+		config.Config.Labels[key] = value
+	}
+
+	if service == nil {
+		return config
+	}
+
+	for key, value := range service.Environment {
+		config.Config.Env = append(config.Config.Env, key+"="+value)
+	}
+	sort.Strings(config.Config.Env)
+
+	// Bravefile ports are UNIT_PORT:HOST_PORT - the unit port is the one exposed by the image
+	for _, port := range service.Ports {
+		unitPort := strings.SplitN(port, ":", 2)[0]
+		if unitPort == "" {
+			continue
+		}
+		if config.Config.ExposedPorts == nil {
+			config.Config.ExposedPorts = map[string]struct{}{}
+		}
+		config.Config.ExposedPorts[unitPort+"/tcp"] = struct{}{}
+	}
+
+	// The last long running postdeploy command is the closest equivalent of an image command
+	for _, run := range service.Postdeploy.Run {
+		if run.Command != "" && run.Detach {
+			config.Config.Cmd = append([]string{run.Command}, run.Args...)
+		}
+	}
+
+	return config
+}
+
+// lxdRootfsLayer writes the rootfs of a unified LXD image archive as an uncompressed layer tar. The architecture
+// and creation date recorded in metadata.yaml are returned.
+func lxdRootfsLayer(imagePath string, w io.Writer) (metadata lxdImageMetadata, err error) {
+	tr, closer, err := openTar(imagePath)
+	if err != nil {
+		return metadata, err
+	}
+	defer closeArchive(closer, &err)
+
+	tw := tar.NewWriter(w)
+	hasRootfs := false
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return metadata, err
+		}
+
+		name, ok := cleanArchivePath(hdr.Name)
+		if !ok {
+			continue
+		}
+
+		if name == "metadata.yaml" {
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return metadata, err
+			}
+			err = yaml.Unmarshal(content, &metadata)
+			if err != nil {
+				return metadata, fmt.Errorf("failed to parse metadata.yaml: %s", err)
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(name, "rootfs/") {
+			continue
+		}
+		hasRootfs = true
+
+		out := *hdr
+		out.Name = strings.TrimPrefix(name, "rootfs/")
+		if hdr.Typeflag == tar.TypeDir {
+			out.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			target, _ := cleanArchivePath(hdr.Linkname)
+			out.Linkname = strings.TrimPrefix(target, "rootfs/")
+		}
+
+		err = tw.WriteHeader(&out)
+		if err != nil {
+			return metadata, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			_, err = io.Copy(tw, tr)
+			if err != nil {
+				return metadata, err
+			}
+		}
+	}
+
+	if !hasRootfs {
+		return metadata, errors.New("image archive has no rootfs - only unified LXD images can be exported")
+	}
+
+	return metadata, tw.Close()
+}
+
+// ociBlob is a file written to an export archive
+type ociBlob struct {
+	name string
+	path string
+}
+
+// writeOCIArchive converts a unified LXD image into an OCI layout or docker-archive tar at destPath, using tmpDir for blobs
+func writeOCIArchive(imagePath string, destPath string, tmpDir string, format string, config ociImageConfig) error {
+	layerPath := filepath.Join(tmpDir, "layer")
+	layerFile, err := os.Create(layerPath)
+	if err != nil {
+		return err
+	}
+	defer layerFile.Close()
+
+	// OCI layers are stored compressed and identified by the compressed digest, docker-archive layers are not compressed
+	diffHash := sha256.New()
+	blobHash := sha256.New()
+	var layerWriter io.Writer
+	var gz *gzip.Writer
+	if format == ExportFormatOCI {
+		gz = gzip.NewWriter(io.MultiWriter(layerFile, blobHash))
+		layerWriter = io.MultiWriter(gz, diffHash)
+	} else {
+		layerWriter = io.MultiWriter(layerFile, diffHash, blobHash)
+	}
+
+	metadata, err := lxdRootfsLayer(imagePath, layerWriter)
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err = layerFile.Close(); err != nil {
+		return err
+	}
+
+	if config.Architecture == "" && metadata.Architecture != "" {
+		config.Architecture = ociArchitectureName(metadata.Architecture)
+	}
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = []string{hashDigest(diffHash)}
+
+	layerDigest := hashDigest(blobHash)
+	layerInfo, err := os.Stat(layerPath)
+	if err != nil {
+		return err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configDigest := bytesDigest(configJSON)
+
+	reference := ociReference(config)
+	var blobs []ociBlob
+	files := map[string][]byte{}
+
+	if format == ExportFormatOCI {
+		manifest, err := json.Marshal(ociManifest{
+			SchemaVersion: 2,
+			MediaType:     ociManifestMediaType,
+			Config:        ociDescriptor{MediaType: ociConfigMediaType, Digest: configDigest, Size: int64(len(configJSON))},
+			Layers:        []ociDescriptor{{MediaType: ociLayerMediaType, Digest: layerDigest, Size: layerInfo.Size()}},
+		})
+		if err != nil {
+			return err
+		}
+
+		manifestDescriptor := ociDescriptor{
+			MediaType:   ociManifestMediaType,
+			Digest:      bytesDigest(manifest),
+			Size:        int64(len(manifest)),
+			Annotations: map[string]string{ociRefNameAnnotation: reference},
+		}
+		index, err := json.Marshal(ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType, Manifests: []ociDescriptor{manifestDescriptor}})
+		if err != nil {
+			return err
+		}
+
+		files["oci-layout"] = []byte(`{"imageLayoutVersion":"1.0.0"}`)
+		files["index.json"] = index
+		files[ociBlobName(configDigest)] = configJSON
+		files[ociBlobName(manifestDescriptor.Digest)] = manifest
+		blobs = append(blobs, ociBlob{name: ociBlobName(layerDigest), path: layerPath})
+	} else {
+		configName := strings.TrimPrefix(configDigest, digestPrefix) + ".json"
+		layerName := strings.TrimPrefix(layerDigest, digestPrefix) + "/layer.tar"
+
+		manifest, err := json.Marshal([]dockerManifest{{Config: configName, RepoTags: []string{reference}, Layers: []string{layerName}}})
+		if err != nil {
+			return err
+		}
+
+		files["manifest.json"] = manifest
+		files[configName] = configJSON
+		blobs = append(blobs, ociBlob{name: layerName, path: layerPath})
+	}
+
+	return writeExportTar(destPath, files, blobs)
+}
+
+// writeExportTar writes small in-memory files followed by blobs on disk into a tar archive
+func writeExportTar(destPath string, files map[string][]byte, blobs []ociBlob) error {
+	f, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = writeTarFile(tw, name, files[name], 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, blob := range blobs {
+		err = writeTarBlob(tw, blob)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeTarBlob(tw *tar.Writer, blob ociBlob) error {
+	f, err := os.Open(blob.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     blob.name,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ociReference returns the name:tag an exported image is loaded as. Docker requires lower case repository names.
+func ociReference(config ociImageConfig) string {
+	name := strings.ToLower(config.Config.Labels["org.opencontainers.image.title"])
+	version := config.Config.Labels["org.opencontainers.image.version"]
+	if version == "" {
+		version = "latest"
+	}
+	return name + ":" + version
+}
+
+func ociBlobName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func hashDigest(h hash.Hash) string {
+	return digestPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+func bytesDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return digestPrefix + hex.EncodeToString(sum[:])
+}
@@ -469,6 +469,24 @@ func GetUnits(lxdServer lxd.InstanceServer, profileName string) (units []shared.
 	return units, nil
 }
 
+// GetUnitAddress returns the IPv4 address of a running unit, waiting for one to be assigned if needed
+func GetUnitAddress(lxdServer lxd.InstanceServer, name string) (address string, err error) {
+	err = retry(10, 2*time.Second, func() error {
+		state, _, err := lxdServer.GetInstanceState(name)
+		if err != nil {
+			return fmt.Errorf("failed to get unit %q: %s", name, err.Error())
+		}
+
+		address = unitIPv4Address(state)
+		if address == "" {
+			return fmt.Errorf("unit %q has no IPv4 address", name)
+		}
+		return nil
+	})
+
+	return address, err
+}
+
 // LaunchFromImage creates new unit based on image
 func LaunchFromImage(destServer lxd.InstanceServer, sourceServer lxd.ImageServer, imageName string, containerName string, profileName string, storagePool string) (fingerprint string, err error) {
 	operation := shared.Info("Launching " + containerName)
@@ -562,6 +580,17 @@ func isIPv4(ip string) bool {
 type ExecArgs struct {
 	env    map[string]string
 	detach bool
+	// output receives the command stdout and stderr - defaults to the terminal
+	output io.Writer
+}
+
+// nopWriteCloser lets exec output be written to writers that must not be closed when the command exits
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
 }
 
 // Exec runs command inside unit
@@ -590,7 +619,13 @@ func Exec(ctx context.Context, lxdServer lxd.InstanceServer, name string, comman
 		return 100, err
 	}
 
-	fmt.Println(shared.Info("["+name+"] "+"RUN: "), shared.Warn(command))
+	stdout, stderr := io.WriteCloser(os.Stdout), io.WriteCloser(os.Stderr)
+	if arg.output != nil {
+		stdout = nopWriteCloser{arg.output}
+		stderr = stdout
+	}
+
+	fmt.Fprintln(stdout, shared.Info("["+name+"] "+"RUN: "), shared.Warn(command))
 
 	req := api.ContainerExecPost{
 		Command:      command,
@@ -602,8 +637,8 @@ func Exec(ctx context.Context, lxdServer lxd.InstanceServer, name string, comman
 
 	args := lxd.ContainerExecArgs{
 		Stdin:    os.Stdin,
-		Stdout:   os.Stdout,
-		Stderr:   os.Stderr,
+		Stdout:   stdout,
+		Stderr:   stderr,
 		Control:  nil, // terminal non-interactive
 		DataDone: make(chan bool),
 	}
@@ -750,6 +785,13 @@ func Stop(lxdServer lxd.InstanceServer, name string) error {
 // Publish unit
 // lxc publish -f [remote]:[name] [remote]: --alias [image]
 func Publish(lxdServer lxd.InstanceServer, name string, image string) (fingerprint string, err error) {
+	return PublishWithProperties(lxdServer, name, image, nil, "")
+}
+
+// PublishWithProperties publishes unit and records the provided properties in the image metadata.
+// An empty compression uses the default compression algorithm of the LXD server.
+// lxc publish -f [remote]:[name] [remote]: --alias [image] --compression [compression] [key=value...]
+func PublishWithProperties(lxdServer lxd.InstanceServer, name string, image string, properties map[string]string, compression string) (fingerprint string, err error) {
 	operation := shared.Info("Publishing " + name)
 	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
 	s.Suffix = " " + operation
@@ -787,6 +829,8 @@ func Publish(lxdServer lxd.InstanceServer, name string, image string) (fingerpri
 			Name: name,
 		},
 	}
+	req.Properties = properties
+	req.CompressionAlgorithm = compression
 
 	op, err := lxdServer.CreateImage(req, nil)
 	if err != nil {
@@ -803,7 +847,7 @@ func Publish(lxdServer lxd.InstanceServer, name string, image string) (fingerpri
 
 	aliasPost := api.ImageAliasesPost{}
 	aliasPost.Name = image
-	if aliasPost.Name != "" {
+	if aliasPost.Name == "" {
 		aliasPost.Name = name
 	}
 	aliasPost.Target = fingerprint
@@ -952,6 +996,11 @@ func FilePush(lxdServer lxd.InstanceServer, name string, sourceFile string, targ
 
 // ImportImage imports image from current directory
 func ImportImage(lxdServer lxd.InstanceServer, imageTar string, nameAndVersion string) (fingerprint string, err error) {
+	return ImportImageWithProperties(lxdServer, imageTar, nameAndVersion, nil)
+}
+
+// ImportImageWithProperties imports image from disk and records the provided properties in the image metadata
+func ImportImageWithProperties(lxdServer lxd.InstanceServer, imageTar string, nameAndVersion string, properties map[string]string) (fingerprint string, err error) {
 	operation := shared.Info("Importing " + filepath.Base(imageTar))
 	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
 	s.Suffix = " " + operation
@@ -966,6 +1015,7 @@ func ImportImage(lxdServer lxd.InstanceServer, imageTar string, nameAndVersion s
 	defer meta.Close()
 
 	image := api.ImagesPost{}
+	image.Properties = properties
 
 	createArgs := &lxd.ImageCreateArgs{
 		MetaFile: meta,
@@ -1420,6 +1470,14 @@ func GetLXDImageSever(remote Remote) (lxd.ImageServer, error) {
 	}
 }
 
+// connectImageRemote connects to a remote to read its images - public and simplestreams remotes are read anonymously
+func connectImageRemote(remote Remote) (lxd.ImageServer, error) {
+	if remote.Public || remote.Protocol == "simplestreams" {
+		return GetLXDImageSever(remote)
+	}
+	return GetLXDInstanceServer(remote)
+}
+
 func GetSimplestreamsLXDSever(url string, args *lxd.ConnectionArgs) (lxd.ImageServer, error) {
 	return lxd.ConnectSimpleStreams(url, args)
 }
@@ -0,0 +1,364 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/db"
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+)
+
+// deployImageProperty marks images InitUnit imported into a remote's LXD image store to launch a unit
+const deployImageProperty = "bravetools.deploy.image"
+
+// leftoverGracePeriod protects deploy images imported recently, which a deploy still in progress may be about to launch
+const leftoverGracePeriod = time.Hour
+
+// ImagePruneFilter selects images to prune. Images must match every filter that is set.
+type ImagePruneFilter struct {
+	// OlderThan selects images created longer ago than the duration
+	OlderThan time.Duration
+	// KeepLast keeps the newest N images of each name
+	KeepLast int
+	// Untagged selects images without an explicit version
+	Untagged bool
+	// Unused selects images not used by any unit in the unit database or on any remote
+	Unused bool
+	// Leftovers also selects images left in remote LXD image stores by interrupted deploys
+	Leftovers bool
+}
+
+// selectsLocalImages reports whether any filter on local images is set
+func (filter ImagePruneFilter) selectsLocalImages() bool {
+	return filter.OlderThan > 0 || filter.KeepLast > 0 || filter.Untagged || filter.Unused
+}
+
+// ImagePrunePlan lists the images a prune would delete and the space it would reclaim
+type ImagePrunePlan struct {
+	images      []imageRecord
+	reclaimable int64
+	leftovers   []leftoverImage
+	warnings    []string
+}
+
+// leftoverImage is an image imported into a remote by InitUnit that was not cleaned up after deploying
+type leftoverImage struct {
+	remote      Remote
+	fingerprint string
+	image       string
+	size        int64
+}
+
+// Empty reports whether the prune would delete nothing
+func (plan *ImagePrunePlan) Empty() bool {
+	return len(plan.images) == 0 && len(plan.leftovers) == 0
+}
+
+// ParseAge parses a duration that can also be expressed in days (d) or weeks (w), e.g. 30d or 2w
+func ParseAge(age string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(age, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(age, suffix))
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q - use a duration such as 30d, 2w or 12h", age)
+	}
+	return d, nil
+}
+
+// selectPruneImages returns the records matching every filter. Images in use are identified by digest.
+// Aliases created by tagging are not counted by --keep-last and are kept when it is set, since they share the blob of their target.
+func selectPruneImages(records []imageRecord, filter ImagePruneFilter, inUse map[string]bool, now time.Time) (selected []imageRecord) {
+	// Rank images of each name from newest to oldest for --keep-last
+	byName := make(map[string][]imageRecord)
+	for _, record := range records {
+		if record.Target != "" {
+			continue
+		}
+		byName[record.Name] = append(byName[record.Name], record)
+	}
+	rank := make(map[string]int)
+	for _, named := range byName {
+		sort.SliceStable(named, func(i, j int) bool {
+			return named[i].Created.After(named[j].Created)
+		})
+		for i, record := range named {
+			rank[record.image().ToBasename()] = i
+		}
+	}
+
+	for _, record := range records {
+		if filter.OlderThan > 0 && now.Sub(record.Created) < filter.OlderThan {
+			continue
+		}
+		if filter.KeepLast > 0 && (record.Target != "" || rank[record.image().ToBasename()] < filter.KeepLast) {
+			continue
+		}
+		if filter.Untagged && record.Version != defaultImageVersion {
+			continue
+		}
+		if filter.Unused && (inUse[record.Digest] || inUse[record.image().String()]) {
+			continue
+		}
+		selected = append(selected, record)
+	}
+
+	return selected
+}
+
+// reclaimableSize returns the size of blobs no longer referenced once the selected images are removed
+func reclaimableSize(records []imageRecord, selected []imageRecord) (size int64) {
+	remaining := make(map[string]int)
+	for _, record := range records {
+		remaining[record.Digest]++
+	}
+
+	for _, record := range selected {
+		remaining[record.Digest]--
+		if remaining[record.Digest] == 0 {
+			size += record.Size
+		}
+	}
+
+	return size
+}
+
+// imagesInUse collects digests of images used by units on all reachable remotes and image names recorded in the unit database.
+// Remotes that cannot be reached are reported as warnings.
+func imagesInUse() (inUse map[string]bool, warnings []string, err error) {
+	inUse = make(map[string]bool)
+
+	remotes, err := ListRemotes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range remotes {
+		remote, err := LoadRemoteSettings(name)
+		if err != nil || remote.Public || remote.Protocol == "simplestreams" {
+			continue
+		}
+
+		lxdServer, err := GetLXDInstanceServer(remote)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not reach remote %q - images used by its units may be pruned", name))
+			continue
+		}
+
+		instances, err := lxdServer.GetInstances("")
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not list units on remote %q - images used by its units may be pruned", name))
+			continue
+		}
+
+		// Digests of image archives match the fingerprints LXD records for units launched from them
+		for _, instance := range instances {
+			if fingerprint := instance.Config["volatile.base_image"]; fingerprint != "" {
+				inUse[digestPrefix+fingerprint] = true
+			}
+		}
+	}
+
+	units, err := getDatabaseUnits()
+	if err != nil {
+		warnings = append(warnings, "could not read unit database: "+err.Error())
+	}
+	for _, unit := range units {
+		if image, err := ParseImageString(unit.Data.Image); err == nil {
+			inUse[image.String()] = true
+		}
+	}
+
+	return inUse, warnings, nil
+}
+
+func getDatabaseUnits() ([]db.Unit, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.New("failed to get home directory")
+	}
+
+	dbPath := path.Join(userHome, shared.BraveDB)
+	if !shared.FileExists(dbPath) {
+		return nil, nil
+	}
+
+	database, err := db.OpenDB(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s", dbPath)
+	}
+
+	return db.GetAllUnitsDB(database)
+}
+
+// findLeftoverImages lists images InitUnit imported into a remote that no unit was launched from.
+// Images imported within the grace period are skipped, as a concurrent deploy may not have launched its unit yet.
+func findLeftoverImages(remote Remote, lxdServer lxd.InstanceServer, now time.Time) ([]leftoverImage, error) {
+	images, err := GetImages(lxdServer)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := lxdServer.GetInstances("")
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, instance := range instances {
+		used[instance.Config["volatile.base_image"]] = true
+	}
+
+	var leftovers []leftoverImage
+	for _, image := range images {
+		source, ok := image.Properties[deployImageProperty]
+		if !ok || used[image.Fingerprint] || now.Sub(image.UploadedAt) < leftoverGracePeriod {
+			continue
+		}
+		leftovers = append(leftovers, leftoverImage{
+			remote:      remote,
+			fingerprint: image.Fingerprint,
+			image:       source,
+			size:        image.Size,
+		})
+	}
+
+	return leftovers, nil
+}
+
+// PlanImagePrune works out which local images match the filter, along with leftover deploy images on reachable remotes if requested
+func (bh *BraveHost) PlanImagePrune(filter ImagePruneFilter) (*ImagePrunePlan, error) {
+	if !filter.selectsLocalImages() && !filter.Leftovers {
+		return nil, errors.New("no prune filter provided - use --older-than, --keep-last, --untagged, --unused or --leftovers")
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ImagePrunePlan{}
+
+	var inUse map[string]bool
+	if filter.Unused {
+		inUse, plan.warnings, err = imagesInUse()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if filter.selectsLocalImages() {
+		plan.images = selectPruneImages(store.index.Images, filter, inUse, time.Now())
+		plan.reclaimable = reclaimableSize(store.index.Images, plan.images)
+	}
+
+	if !filter.Leftovers {
+		return plan, nil
+	}
+
+	remotes, err := ListRemotes()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range remotes {
+		remote, err := LoadRemoteSettings(name)
+		if err != nil || remote.Public || remote.Protocol == "simplestreams" {
+			continue
+		}
+		lxdServer, err := GetLXDInstanceServer(remote)
+		if err != nil {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("could not reach remote %q - its leftover deploy images are not pruned", name))
+			continue
+		}
+		leftovers, err := findLeftoverImages(remote, lxdServer, time.Now())
+		if err != nil {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("could not list images on remote %q: %s", name, err))
+			continue
+		}
+		plan.leftovers = append(plan.leftovers, leftovers...)
+	}
+
+	return plan, nil
+}
+
+// PrintImagePrunePlan prints the images a prune would delete and the space reclaimed
+func (bh *BraveHost) PrintImagePrunePlan(plan *ImagePrunePlan) {
+	for _, warning := range plan.warnings {
+		fmt.Println(shared.Warn("Warning: " + warning))
+	}
+
+	if plan.Empty() {
+		fmt.Println("Nothing to prune")
+		return
+	}
+
+	if len(plan.images) > 0 {
+		table := newPlainTable(os.Stdout, []string{"Image", "Version", "Arch", "Created", "Size"})
+		for _, record := range plan.images {
+			table.Append([]string{record.Name, record.Version, record.Architecture, record.Created.Local().Format("2006-01-02 15:04"), shared.FormatByteCountSI(record.Size)})
+		}
+		table.Render()
+		fmt.Printf("\n%d local images, %s reclaimable\n", len(plan.images), shared.FormatByteCountSI(plan.reclaimable))
+	}
+
+	if len(plan.leftovers) > 0 {
+		var leftoverSize int64
+		fmt.Println()
+		table := newPlainTable(os.Stdout, []string{"Remote", "Fingerprint", "Image", "Size"})
+		for _, leftover := range plan.leftovers {
+			table.Append([]string{leftover.remote.Name, shortDigest(leftover.fingerprint), leftover.image, shared.FormatByteCountSI(leftover.size)})
+			leftoverSize += leftover.size
+		}
+		table.Render()
+		fmt.Printf("\n%d leftover deploy images on remotes, %s reclaimable\n", len(plan.leftovers), shared.FormatByteCountSI(leftoverSize))
+	}
+}
+
+// PruneImages deletes the images in a prune plan
+func (bh *BraveHost) PruneImages(plan *ImagePrunePlan) error {
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	for _, pruned := range plan.images {
+		record, err := store.exact(pruned.image())
+		if err != nil {
+			// Already removed
+			continue
+		}
+		err = store.remove(record)
+		if err != nil {
+			return fmt.Errorf("failed to delete image %q: %s", pruned.image(), err)
+		}
+	}
+
+	for _, leftover := range plan.leftovers {
+		lxdServer, err := GetLXDInstanceServer(leftover.remote)
+		if err != nil {
+			return err
+		}
+		err = DeleteImageByFingerprint(lxdServer, leftover.fingerprint)
+		if err != nil {
+			return fmt.Errorf("failed to delete image %s on remote %q: %s", shortDigest(leftover.fingerprint), leftover.remote.Name, err)
+		}
+	}
+
+	fmt.Printf("Deleted %d images and %d leftover deploy images\n", len(plan.images), len(plan.leftovers))
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectPruneImages(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	records := []imageRecord{
+		{Name: "app", Version: "1.0", Digest: "sha256:a", Size: 10, Created: now.Add(-30 * day)},
+		{Name: "app", Version: "1.1", Digest: "sha256:b", Size: 10, Created: now.Add(-20 * day)},
+		{Name: "app", Version: "1.2", Digest: "sha256:c", Size: 10, Created: now.Add(-1 * day)},
+		{Name: "db", Version: "untagged", Digest: "sha256:d", Size: 10, Created: now.Add(-40 * day)},
+		{Name: "db", Version: "2.0", Digest: "sha256:a", Size: 10, Created: now.Add(-10 * day)},
+		// A fresh tag of an old image must not push real builds out of --keep-last
+		{Name: "app", Version: "stable", Digest: "sha256:a", Size: 10, Created: now, Target: "app/1.0"},
+	}
+
+	names := func(selected []imageRecord) (names []string) {
+		for _, record := range selected {
+			names = append(names, record.image().String())
+		}
+		return names
+	}
+
+	cases := []struct {
+		filter   ImagePruneFilter
+		inUse    map[string]bool
+		expected []string
+	}{
+		{ImagePruneFilter{KeepLast: 1}, nil, []string{"app/1.0", "app/1.1", "db/untagged"}},
+		{ImagePruneFilter{OlderThan: 25 * day}, nil, []string{"app/1.0", "db/untagged"}},
+		{ImagePruneFilter{Untagged: true}, nil, []string{"db/untagged"}},
+		{ImagePruneFilter{KeepLast: 2}, nil, []string{"app/1.0"}},
+		{ImagePruneFilter{KeepLast: 1, OlderThan: 25 * day}, nil, []string{"app/1.0", "db/untagged"}},
+		{ImagePruneFilter{Unused: true}, map[string]bool{"sha256:a": true, "app/1.2": true}, []string{"app/1.1", "db/untagged"}},
+	}
+
+	for _, c := range cases {
+		selected := names(selectPruneImages(records, c.filter, c.inUse, now))
+		if !reflect.DeepEqual(selected, c.expected) {
+			t.Errorf("filter %+v: expected %v, found %v", c.filter, c.expected, selected)
+		}
+	}
+
+	// Shared blobs are only reclaimed once every image referencing them is pruned
+	if size := reclaimableSize(records, records[:1]); size != 0 {
+		t.Errorf("expected no space reclaimed for a shared blob, found %d", size)
+	}
+	if size := reclaimableSize(records, []imageRecord{records[0], records[4], records[5]}); size != 10 {
+		t.Errorf("expected shared blob to be reclaimed, found %d", size)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+	}
+	for age, expected := range cases {
+		d, err := ParseAge(age)
+		if err != nil || d != expected {
+			t.Errorf("expected %q to parse to %s, found %s (%v)", age, expected, d, err)
+		}
+	}
+
+	if _, err := ParseAge("soon"); err == nil {
+		t.Error("expected err for invalid age")
+	}
+}
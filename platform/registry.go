@@ -0,0 +1,439 @@
+package platform
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// RegistryProtocol is the protocol of remotes that are bravetools image registries
+const RegistryProtocol = "bravetools"
+
+// RegistryTokenEnv names the environment variable holding the token of `brave registry serve`
+const RegistryTokenEnv = "BRAVETOOLS_REGISTRY_TOKEN"
+
+// DefaultRegistryAddress is the address a registry listens on unless another is given. Only local clients can connect
+// to it, so serving to other hosts must be asked for explicitly.
+const DefaultRegistryAddress = "127.0.0.1:8700"
+
+// DefaultRegistryMaxUploadSize is the largest image archive a registry accepts unless another limit is given
+const DefaultRegistryMaxUploadSize = "10GB"
+
+// Registry API. Images are addressed as /v1/images/NAME[/VERSION[/ARCH]] and their archives as /v1/images/NAME/VERSION/ARCH/archive.
+const (
+	registryImagesPath    = "/v1/images"
+	registryArchivePath   = "archive"
+	registryDigestHeader  = "X-Bravetools-Digest"
+	registrySearchParam   = "search"
+	registryAuthScheme    = "Bearer "
+	registryMaxErrorBytes = 4096
+)
+
+// registryError is the body of failed registry responses
+type registryError struct {
+	Error string `json:"error"`
+}
+
+// RegistryServer serves an image store directory over HTTP. If a token is set every request must present it,
+// otherwise images can be read by anyone and uploads are disabled.
+type RegistryServer struct {
+	root  string
+	token string
+	// maxUploadSize is the largest archive accepted in bytes
+	maxUploadSize int64
+	// simplestreams serves the store to LXD as a simplestreams remote. LXD cannot present a token, so these paths are public.
+	simplestreams bool
+}
+
+// RegistryConfig configures a registry started with ServeRegistry
+type RegistryConfig struct {
+	// Root is the image store directory - defaults to the local image store
+	Root    string
+	Address string
+	Token   string
+	// TLSCert and TLSKey serve HTTPS when set
+	TLSCert string
+	TLSKey  string
+	// PublicSimplestreams additionally serves the images to LXD as a simplestreams remote, which cannot present a token
+	PublicSimplestreams bool
+	// AllowPublic confirms that PublicSimplestreams makes every image readable without the token
+	AllowPublic bool
+	// MaxUploadSize is the largest archive accepted in bytes - defaults to DefaultRegistryMaxUploadSize
+	MaxUploadSize int64
+}
+
+// NewRegistryServer returns a registry serving the image store in root, creating it if needed
+func NewRegistryServer(root string, token string) (*RegistryServer, error) {
+	store, err := openImageStoreAt(root)
+	if err != nil {
+		return nil, err
+	}
+	if err = store.update(func() error { return nil }); err != nil {
+		return nil, fmt.Errorf("failed to initialize image store %q: %s", root, err)
+	}
+
+	maxUploadSize, err := shared.SizeCountToInt(DefaultRegistryMaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegistryServer{root: root, token: token, maxUploadSize: maxUploadSize}, nil
+}
+
+// ServeRegistry serves an image store as a registry until the server fails
+func ServeRegistry(config RegistryConfig) error {
+	if config.Root == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		config.Root = filepath.Join(homeDir, shared.ImageStore)
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return errors.New("serving over TLS requires both a certificate and a key")
+	}
+	if config.PublicSimplestreams && config.Token != "" && !config.AllowPublic {
+		return errors.New("public simplestreams paths make every image readable without the registry token - add --allow-public to confirm")
+	}
+
+	registry, err := NewRegistryServer(config.Root, config.Token)
+	if err != nil {
+		return err
+	}
+	registry.simplestreams = config.PublicSimplestreams
+	if config.MaxUploadSize > 0 {
+		registry.maxUploadSize = config.MaxUploadSize
+	}
+
+	server := &http.Server{Addr: config.Address, Handler: registry}
+
+	scheme := "http"
+	if config.TLSCert != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Serving image store %s on %s://%s\n", config.Root, scheme, config.Address)
+	if config.Token == "" {
+		fmt.Println("No token set - images are readable by anyone and uploads are disabled")
+	}
+	if config.PublicSimplestreams {
+		fmt.Printf("Serving simplestreams index at %s://%s/%s - images are readable without a token\n", scheme, config.Address, simplestreamsIndexPath)
+	}
+
+	if scheme == "https" {
+		return server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+	}
+	return server.ListenAndServe()
+}
+
+func writeRegistryJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, err error) {
+	writeRegistryJSON(w, status, registryError{Error: err.Error()})
+}
+
+// authorized checks the bearer token of a request
+func (registry *RegistryServer) authorized(r *http.Request) bool {
+	if registry.token == "" {
+		return true
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, registryAuthScheme) {
+		return false
+	}
+	token := strings.TrimPrefix(header, registryAuthScheme)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(registry.token)) == 1
+}
+
+func (registry *RegistryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if registry.simplestreams && registry.serveSimplestreams(w, r) {
+		return
+	}
+
+	if !registry.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bravetools"`)
+		writeRegistryError(w, http.StatusUnauthorized, errors.New("missing or invalid registry token"))
+		return
+	}
+
+	if r.URL.Path == registryImagesPath {
+		if r.Method != http.MethodGet {
+			writeRegistryError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		registry.listImages(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, registryImagesPath+"/") {
+		writeRegistryError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, registryImagesPath+"/"), "/")
+	archive := len(parts) == 4 && parts[3] == registryArchivePath
+	if archive {
+		parts = parts[:3]
+	}
+	if len(parts) > 3 || parts[0] == "" {
+		writeRegistryError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	var image BravetoolsImage
+	for i, field := range []*string{&image.Name, &image.Version, &image.Architecture} {
+		if i < len(parts) {
+			*field = parts[i]
+		}
+	}
+
+	switch {
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && archive:
+		registry.serveArchive(w, r, image)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		registry.serveImage(w, image)
+	case r.Method == http.MethodPut && !archive:
+		registry.uploadImage(w, r, image)
+	default:
+		writeRegistryError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// findImage looks up an image, treating missing version and architecture as wildcards
+func (registry *RegistryServer) findImage(image BravetoolsImage) (*imageRecord, int, error) {
+	store, err := openImageStoreAt(registry.root)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	record, err := store.match(image)
+	if errors.As(err, &multipleImageMatches{}) {
+		return nil, http.StatusConflict, err
+	}
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("image %q not found", image)
+	}
+	return record, http.StatusOK, nil
+}
+
+// listImages returns the images of the store whose name contains the search parameter
+func (registry *RegistryServer) listImages(w http.ResponseWriter, r *http.Request) {
+	store, err := openImageStoreAt(registry.root)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	search := r.URL.Query().Get(registrySearchParam)
+	records := []imageRecord{}
+	for _, record := range store.index.Images {
+		if strings.Contains(record.image().String(), search) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].image().String() < records[j].image().String()
+	})
+
+	writeRegistryJSON(w, http.StatusOK, records)
+}
+
+func (registry *RegistryServer) serveImage(w http.ResponseWriter, image BravetoolsImage) {
+	record, status, err := registry.findImage(image)
+	if err != nil {
+		writeRegistryError(w, status, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, record)
+}
+
+func (registry *RegistryServer) serveArchive(w http.ResponseWriter, r *http.Request, image BravetoolsImage) {
+	record, status, err := registry.findImage(image)
+	if err != nil {
+		writeRegistryError(w, status, err)
+		return
+	}
+
+	store := imageStore{root: registry.root}
+	blob, err := os.Open(store.blobPath(record.Digest))
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, fmt.Errorf("archive of image %q is missing", image))
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set(registryDigestHeader, record.Digest)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, record.image().ToBasename(), record.Created, blob)
+}
+
+// uploadImage stores the archive in the request body under a name, version and architecture.
+// The digest header must match the archive. Uploading an existing image with the same digest succeeds without changes.
+func (registry *RegistryServer) uploadImage(w http.ResponseWriter, r *http.Request, image BravetoolsImage) {
+	if registry.token == "" {
+		writeRegistryError(w, http.StatusForbidden, errors.New("uploads are disabled - start the registry with a token to allow them"))
+		return
+	}
+	if image.Version == "" || image.Architecture == "" {
+		writeRegistryError(w, http.StatusBadRequest, fmt.Errorf("image %q must include a version and architecture", image))
+		return
+	}
+	if err := validateImage(image); err != nil {
+		writeRegistryError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	digest := r.Header.Get(registryDigestHeader)
+	if !strings.HasPrefix(digest, digestPrefix) {
+		writeRegistryError(w, http.StatusBadRequest, fmt.Errorf("missing %s header", registryDigestHeader))
+		return
+	}
+
+	tooLarge := fmt.Errorf("image %q is larger than the upload limit of %s", image, shared.FormatByteCountSI(registry.maxUploadSize))
+	if r.ContentLength > registry.maxUploadSize {
+		writeRegistryError(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(registry.root, ".upload-*")
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, registry.maxUploadSize))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && size >= registry.maxUploadSize {
+		writeRegistryError(w, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, fmt.Errorf("failed to receive image %q: %s", image, err))
+		return
+	}
+
+	// Refuse truncated or corrupted archives before they reach the store
+	received, err := checkImageArchive(tmp.Name())
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, fmt.Errorf("received image %q is not a valid image archive: %s", image, err))
+		return
+	}
+	if received != digest {
+		writeRegistryError(w, http.StatusBadRequest, fmt.Errorf("received image %q has digest %s, expected %s", image, received, digest))
+		return
+	}
+
+	store, err := openImageStoreAt(registry.root)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Check and record the upload under the store lock shared with bravetools commands using the same store
+	var record *imageRecord
+	status := http.StatusInternalServerError
+	err = store.update(func() error {
+		if existing, err := store.exact(image); err == nil {
+			if existing.Digest != digest {
+				status = http.StatusConflict
+				return &ImageExistsError{Name: image.String()}
+			}
+			record, status = existing, http.StatusOK
+			return nil
+		}
+
+		if store.references(digest) > 0 {
+			record, err = store.link(image, digest)
+		} else {
+			record, err = store.add(tmp.Name(), image, nil)
+		}
+		if err == nil {
+			status = http.StatusCreated
+		}
+		return err
+	})
+	if err != nil {
+		writeRegistryError(w, status, err)
+		return
+	}
+	if status == http.StatusOK {
+		writeRegistryJSON(w, status, record)
+		return
+	}
+
+	fmt.Printf("Received image %q (%s)\n", image, shortDigest(digest))
+	writeRegistryJSON(w, http.StatusCreated, record)
+}
+
+// serveSimplestreams serves the simplestreams index of the store and the blobs it references.
+// It returns false for requests outside the simplestreams layout.
+func (registry *RegistryServer) serveSimplestreams(w http.ResponseWriter, r *http.Request) bool {
+	requestPath := strings.TrimPrefix(r.URL.Path, "/")
+	isIndex := requestPath == simplestreamsIndexPath || requestPath == simplestreamsImagesPath
+	isBlob := strings.HasPrefix(requestPath, imageBlobDir+"/")
+	if !isIndex && !isBlob {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeRegistryError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return true
+	}
+
+	store, err := openImageStoreAt(registry.root)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, err)
+		return true
+	}
+
+	if isIndex {
+		products, _ := simplestreamsProducts(store)
+		if requestPath == simplestreamsIndexPath {
+			writeRegistryJSON(w, http.StatusOK, simplestreamsStream(products))
+		} else {
+			writeRegistryJSON(w, http.StatusOK, products)
+		}
+		return true
+	}
+
+	// Only blobs of indexed images are served
+	digest := digestPrefix + strings.TrimPrefix(requestPath, imageBlobDir+"/")
+	var record *imageRecord
+	for i := range store.index.Images {
+		if store.index.Images[i].Digest == digest {
+			record = &store.index.Images[i]
+			break
+		}
+	}
+	if record == nil {
+		writeRegistryError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return true
+	}
+
+	blob, err := os.Open(store.blobPath(digest))
+	if err != nil {
+		writeRegistryError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return true
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, path.Base(requestPath), record.Created, blob)
+	return true
+}
@@ -0,0 +1,133 @@
+package platform
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistryPushPull(t *testing.T) {
+	newTestImageStore(t)
+	registryDir := t.TempDir()
+
+	registry, err := NewRegistryServer(registryDir, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	remote := Remote{Name: "team", URL: server.URL, Protocol: RegistryProtocol, Token: "secret"}
+
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	writeTestFile(t, archive, string(writeTestTar(t, []testTarEntry{{"metadata.yaml", tar.TypeReg, "architecture: x86_64\n"}})))
+	image := BravetoolsImage{Name: "api", Version: "1.0", Architecture: "x86_64"}
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.add(archive, image, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = PingRegistry(Remote{Name: "team", URL: server.URL, Protocol: RegistryProtocol, Token: "wrong"}); err == nil {
+		t.Fatal("expected an invalid token to be rejected")
+	}
+
+	if err = pushRegistryImage(remote, record.image(), store.blobPath(record.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	// Pushing the same image again is a no-op
+	if err = pushRegistryImage(remote, record.image(), store.blobPath(record.Digest)); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newRegistryClient(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := client.images("ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Digest != record.Digest {
+		t.Fatalf("unexpected search results %+v", records)
+	}
+
+	// Pull into a fresh local store, resolving the version and architecture on the registry
+	newTestImageStore(t)
+	if err = pullRegistryImage(remote, BravetoolsImage{Name: "api"}); err != nil {
+		t.Fatal(err)
+	}
+	path, err := localImagePath(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, strings.TrimPrefix(record.Digest, digestPrefix)) {
+		t.Fatalf("pulled image stored at unexpected path %s", path)
+	}
+}
+
+func TestRegistryUploadWithoutToken(t *testing.T) {
+	registry, err := NewRegistryServer(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	archive := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, archive, "content")
+
+	client, err := newRegistryClient(Remote{Name: "open", URL: server.URL, Protocol: RegistryProtocol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.upload(BravetoolsImage{Name: "api", Version: "1.0", Architecture: "x86_64"}, archive, digestPrefix+"00", "Pushing")
+	if err == nil || !strings.Contains(err.Error(), "uploads are disabled") {
+		t.Fatalf("expected uploads to be refused without a token, got %v", err)
+	}
+}
+
+func TestRegistryUploadChecks(t *testing.T) {
+	registry, err := NewRegistryServer(t.TempDir(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.maxUploadSize = 4096
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	client, err := newRegistryClient(Remote{Name: "team", URL: server.URL, Protocol: RegistryProtocol, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := BravetoolsImage{Name: "api", Version: "1.0", Architecture: "x86_64"}
+
+	upload := func(content string) error {
+		archive := filepath.Join(t.TempDir(), "image.tar")
+		writeTestFile(t, archive, content)
+		return client.upload(image, archive, digestPrefix+fmt.Sprintf("%x", sha256.Sum256([]byte(content))), "Pushing")
+	}
+
+	if err = upload("not an image archive"); err == nil || !strings.Contains(err.Error(), "not a valid image archive") {
+		t.Errorf("expected invalid archive to be refused, found %v", err)
+	}
+	if err = upload(strings.Repeat("x", 8192)); err == nil || !strings.Contains(err.Error(), "upload limit") {
+		t.Errorf("expected oversized archive to be refused, found %v", err)
+	}
+
+	store, err := openImageStoreAt(registry.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.index.Images) != 0 {
+		t.Fatalf("expected refused uploads not to be stored, found %+v", store.index.Images)
+	}
+}
@@ -0,0 +1,264 @@
+package platform
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lxc/lxd/shared/ioprogress"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// registryClient talks to a bravetools image registry
+type registryClient struct {
+	remote Remote
+	client *http.Client
+}
+
+func newRegistryClient(remote Remote) (*registryClient, error) {
+	if remote.Protocol != RegistryProtocol {
+		return nil, fmt.Errorf("remote %q is not a bravetools registry", remote.Name)
+	}
+
+	client := &http.Client{}
+
+	// Trust a server certificate saved for the remote in addition to the system roots
+	if remote.servercert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(remote.servercert)) {
+			return nil, fmt.Errorf("invalid server certificate for remote %q", remote.Name)
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return &registryClient{remote: remote, client: client}, nil
+}
+
+// registryImagePath returns the API path of an image, omitting empty fields
+func registryImagePath(image BravetoolsImage) string {
+	imagePath := registryImagesPath
+	for _, field := range []string{image.Name, image.Version, image.Architecture} {
+		if field == "" {
+			break
+		}
+		imagePath += "/" + url.PathEscape(field)
+	}
+	return imagePath
+}
+
+// do sends a request to the registry and turns error responses into errors
+func (c *registryClient) do(method string, apiPath string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(c.remote.URL, "/")+apiPath, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.remote.Token != "" {
+		req.Header.Set("Authorization", registryAuthScheme+c.remote.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var regErr registryError
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, registryMaxErrorBytes))
+	if json.Unmarshal(content, &regErr) != nil || regErr.Error == "" {
+		regErr.Error = resp.Status
+	}
+	return nil, fmt.Errorf("registry %q: %s", c.remote.Name, regErr.Error)
+}
+
+// getJSON decodes the JSON response of a GET request
+func (c *registryClient) getJSON(apiPath string, value interface{}) error {
+	resp, err := c.do(http.MethodGet, apiPath, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(value)
+	if err != nil {
+		return fmt.Errorf("invalid response from registry %q: %s", c.remote.Name, err)
+	}
+	return nil
+}
+
+// images lists the images of the registry whose name contains search
+func (c *registryClient) images(search string) (records []imageRecord, err error) {
+	apiPath := registryImagesPath
+	if search != "" {
+		apiPath += "?" + url.Values{registrySearchParam: {search}}.Encode()
+	}
+	return records, c.getJSON(apiPath, &records)
+}
+
+// image looks up an image, treating missing version and architecture as wildcards
+func (c *registryClient) image(image BravetoolsImage) (record imageRecord, err error) {
+	return record, c.getJSON(registryImagePath(image), &record)
+}
+
+// registryProgress returns a tracker printing transfer progress like LXD transfers
+func registryProgress(operation string, length int64) *ioprogress.ProgressTracker {
+	handler := transferProgress(operation)
+	return &ioprogress.ProgressTracker{
+		Length: length,
+		Handler: func(percent int64, speed int64) {
+			handler(ioprogress.ProgressData{Text: fmt.Sprintf("%d%% (%s/s)", percent, shared.FormatByteCountSI(speed))})
+		},
+	}
+}
+
+// download writes the archive of an image to destPath
+func (c *registryClient) download(record imageRecord, destPath string, operation string) error {
+	resp, err := c.do(http.MethodGet, registryImagePath(record.image())+"/"+registryArchivePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	body := &ioprogress.ProgressReader{ReadCloser: resp.Body, Tracker: registryProgress(operation, resp.ContentLength)}
+	_, err = io.Copy(dest, body)
+	endTransferProgress()
+	return err
+}
+
+// upload sends an image archive to the registry
+func (c *registryClient) upload(image BravetoolsImage, imagePath string, digest string, operation string) error {
+	archive, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+
+	body := &ioprogress.ProgressReader{ReadCloser: archive, Tracker: registryProgress(operation, info.Size())}
+	header := http.Header{}
+	header.Set(registryDigestHeader, digest)
+	header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.do(http.MethodPut, registryImagePath(image), body, header)
+	endTransferProgress()
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PingRegistry checks that a registry remote is reachable and accepts its token
+func PingRegistry(remote Remote) error {
+	client, err := newRegistryClient(remote)
+	if err != nil {
+		return err
+	}
+	_, err = client.images("")
+	return err
+}
+
+// SearchRegistry prints the images of a registry remote whose name contains search
+func (bh *BraveHost) SearchRegistry(remoteName string, search string) error {
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return fmt.Errorf("failed to load remote %q: %s", remoteName, err)
+	}
+
+	client, err := newRegistryClient(remote)
+	if err != nil {
+		return err
+	}
+
+	records, err := client.images(search)
+	if err != nil {
+		return err
+	}
+
+	table := newPlainTable(os.Stdout, []string{"Image", "Digest", "Size", "Created"})
+	for _, record := range records {
+		table.Append([]string{
+			record.image().String(),
+			shortDigest(record.Digest),
+			shared.FormatByteCountSI(record.Size),
+			record.Created.Local().Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// pushRegistryImage uploads a local image to a registry remote unless the registry already has it
+func pushRegistryImage(remote Remote, image BravetoolsImage, imagePath string) error {
+	client, err := newRegistryClient(remote)
+	if err != nil {
+		return err
+	}
+
+	alias := image.String()
+	if record, err := client.image(image); err == nil && record.Name == image.Name && record.Version == image.Version {
+		if record.Digest != image.hashString {
+			return fmt.Errorf("image %q already exists on registry %q with digest %s", alias, remote.Name, record.Digest)
+		}
+		fmt.Printf("Image %q already exists on registry %q - skipping transfer\n", alias, remote.Name)
+		return nil
+	}
+
+	err = client.upload(image, imagePath, image.hashString, shared.Info(fmt.Sprintf("Pushing %q to %q", alias, remote.Name)))
+	if err != nil {
+		return fmt.Errorf("failed to push image %q to registry %q: %s", alias, remote.Name, err)
+	}
+
+	fmt.Printf("Pushed image %q to registry %q\n", alias, remote.Name)
+	return nil
+}
+
+// pullRegistryImage downloads an image from a registry remote into the local image store
+func pullRegistryImage(remote Remote, image BravetoolsImage) error {
+	client, err := newRegistryClient(remote)
+	if err != nil {
+		return err
+	}
+
+	record, err := client.image(image)
+	if err != nil {
+		return fmt.Errorf("image %q not found on registry %q: %s", image, remote.Name, err)
+	}
+	if record.Digest == "" {
+		return errors.New("registry did not return the digest of image " + image.String())
+	}
+
+	resolved := BravetoolsImage{Name: record.Name, Version: record.Version, Architecture: record.Architecture}
+	return pullIntoStore(resolved, record.Digest, remote.Name, func(destPath string) error {
+		return client.download(record, destPath, shared.Info(fmt.Sprintf("Pulling %q from %q", resolved, remote.Name)))
+	})
+}
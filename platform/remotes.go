@@ -16,13 +16,17 @@ import (
 
 // Remote represents a configuration of the remote
 type Remote struct {
-	Name       string `json:"name"`
-	URL        string `json:"url"`
-	Protocol   string `json:"protocol"`
-	Public     bool   `json:"public"`
-	Profile    string `json:"profile"`
-	Network    string `json:"network"`
-	Storage    string `json:"storage"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Public   bool   `json:"public"`
+	Profile  string `json:"profile"`
+	Network  string `json:"network"`
+	Storage  string `json:"storage"`
+	// SignaturePolicy controls whether unsigned or untrusted images may be deployed to the remote
+	SignaturePolicy string `json:"signature_policy,omitempty"`
+	// Token authenticates requests to bravetools registry remotes
+	Token      string `json:"token,omitempty"`
 	key        string
 	cert       string
 	servercert string
@@ -110,8 +114,8 @@ func LoadRemoteSettings(remoteName string) (Remote, error) {
 	serverCertPath := path.Join(userHome, shared.BraveServerCertStore, remoteName+".crt")
 	remote.servercert, _ = loadServerCert(serverCertPath)
 
-	// Public Image server doesn't need client auth
-	if remote.Public || remote.Protocol == "simplestreams" {
+	// Public Image servers and bravetools registries don't need client certs
+	if remote.Public || remote.Protocol == "simplestreams" || remote.Protocol == RegistryProtocol {
 		return remote, nil
 	}
 
@@ -135,6 +139,11 @@ func SaveRemote(remote Remote) error {
 		return errors.New("remote " + remote.Name + " already exists")
 	}
 
+	return writeRemote(remote)
+}
+
+// writeRemote writes the settings of a remote to the remote store, replacing any saved settings. The file is only readable by the user.
+func writeRemote(remote Remote) error {
 	userHome, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to save remote %q: %s", remote.Name, err.Error())
@@ -146,7 +155,27 @@ func SaveRemote(remote Remote) error {
 		return err
 	}
 
-	return os.WriteFile(path, remoteJson, 0666)
+	// Remotes may hold registry tokens, so files saved before tokens were supported are made private before writing
+	if err = os.Chmod(path, 0600); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(path, remoteJson, 0600)
+}
+
+// SetRemoteSignaturePolicy updates the image signature policy of a saved remote
+func SetRemoteSignaturePolicy(remoteName string, policy string) error {
+	err := ValidateSignaturePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	remote, err := loadRemoteConfig(remoteName)
+	if err != nil {
+		return err
+	}
+	remote.SignaturePolicy = policy
+
+	return writeRemote(remote)
 }
 
 func ListRemotes() (names []string, err error) {
@@ -0,0 +1,470 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	lxd "github.com/lxc/lxd/client"
+)
+
+// Package databases queried inside a build unit
+const (
+	dpkgStatusFile   = "/var/lib/dpkg/status"
+	apkInstalledFile = "/lib/apk/db/installed"
+	osReleaseFile    = "/etc/os-release"
+)
+
+// SBOM files are stored next to the image archive
+const sbomExtension = ".sbom.json"
+
+// sbomPackage is a system package installed in an image
+type sbomPackage struct {
+	Name         string
+	Version      string
+	Architecture string
+	License      string
+}
+
+// CycloneDX JSON document - only the fields bravetools records are modelled
+type cycloneDX struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     []cycloneDXTool    `json:"tools,omitempty"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Vendor string `json:"vendor"`
+	Name   string `json:"name"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License cycloneDXLicenseName `json:"license"`
+}
+
+type cycloneDXLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// parseDpkgStatus lists installed packages from a dpkg status database
+func parseDpkgStatus(status string) (packages []sbomPackage) {
+	for _, stanza := range strings.Split(status, "\n\n") {
+		fields := parseControlFields(stanza)
+		if fields["Package"] == "" || !strings.HasSuffix(fields["Status"], " installed") {
+			continue
+		}
+		packages = append(packages, sbomPackage{
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Architecture: fields["Architecture"],
+		})
+	}
+	return packages
+}
+
+// parseControlFields parses "Key: value" lines of a dpkg stanza, ignoring continuation lines
+func parseControlFields(stanza string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(stanza, "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return fields
+}
+
+// parseApkInstalled lists installed packages from an apk installed database
+func parseApkInstalled(installed string) (packages []sbomPackage) {
+	for _, stanza := range strings.Split(installed, "\n\n") {
+		var pkg sbomPackage
+		for _, line := range strings.Split(stanza, "\n") {
+			if len(line) < 2 || line[1] != ':' {
+				continue
+			}
+			switch line[0] {
+			case 'P':
+				pkg.Name = line[2:]
+			case 'V':
+				pkg.Version = line[2:]
+			case 'A':
+				pkg.Architecture = line[2:]
+			case 'L':
+				pkg.License = line[2:]
+			}
+		}
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+// rpmQueryFormat is the query format parsed by parseRpmQuery
+const rpmQueryFormat = `%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`
+
+// parseRpmQuery lists installed packages from the output of rpm -qa with rpmQueryFormat
+func parseRpmQuery(output string) (packages []sbomPackage) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || fields[0] == "" {
+			continue
+		}
+		packages = append(packages, sbomPackage{
+			Name:         fields[0],
+			Version:      fields[1],
+			Architecture: fields[2],
+			License:      fields[3],
+		})
+	}
+	return packages
+}
+
+// readUnitFile returns the content of a file inside a unit
+func readUnitFile(lxdServer lxd.InstanceServer, unitName string, filePath string) (string, error) {
+	content, _, err := lxdServer.GetInstanceFile(unitName, filePath)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// collectPackages queries the package database inside a unit. The package type and distribution ID are returned for package URLs.
+func collectPackages(ctx context.Context, lxdServer lxd.InstanceServer, unitName string) (packages []sbomPackage, pkgType string, distro string, err error) {
+	if osRelease, err := readUnitFile(lxdServer, unitName, osReleaseFile); err == nil {
+		for _, line := range strings.Split(osRelease, "\n") {
+			if strings.HasPrefix(line, "ID=") {
+				distro = strings.Trim(strings.TrimPrefix(line, "ID="), `"'`)
+			}
+		}
+	}
+
+	if status, err := readUnitFile(lxdServer, unitName, dpkgStatusFile); err == nil {
+		return parseDpkgStatus(status), "deb", distro, nil
+	}
+
+	if installed, err := readUnitFile(lxdServer, unitName, apkInstalledFile); err == nil {
+		return parseApkInstalled(installed), "apk", distro, nil
+	}
+
+	var output bytes.Buffer
+	status, err := Exec(ctx, lxdServer, unitName, []string{"rpm", "-qa", "--qf", rpmQueryFormat}, ExecArgs{output: &output})
+	if err == nil && status == 0 {
+		return parseRpmQuery(output.String()), "rpm", distro, nil
+	}
+
+	return nil, "", distro, fmt.Errorf("no dpkg, apk or rpm package database found in unit %q", unitName)
+}
+
+// newCycloneDX creates a CycloneDX document listing the packages installed in an image
+func newCycloneDX(image BravetoolsImage, packages []sbomPackage, pkgType string, distro string) cycloneDX {
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+
+	bom := cycloneDX{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     []cycloneDXTool{{Vendor: "bravetools", Name: "brave"}},
+			Component: cycloneDXComponent{
+				Type:    "container",
+				Name:    image.Name,
+				Version: image.Version,
+				Properties: []cycloneDXProperty{
+					{Name: "bravetools:architecture", Value: image.Architecture},
+				},
+			},
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	for _, pkg := range packages {
+		component := cycloneDXComponent{
+			Type:    "library",
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    packageURL(pkgType, distro, pkg),
+		}
+		if pkg.License != "" {
+			component.Licenses = []cycloneDXLicense{{License: cycloneDXLicenseName{Name: pkg.License}}}
+		}
+		if pkg.Architecture != "" {
+			component.Properties = []cycloneDXProperty{{Name: "bravetools:architecture", Value: pkg.Architecture}}
+		}
+		bom.Components = append(bom.Components, component)
+	}
+
+	return bom
+}
+
+// packageURL returns the purl identifying a distribution package
+func packageURL(pkgType string, distro string, pkg sbomPackage) string {
+	purl := "pkg:" + pkgType + "/"
+	if distro != "" {
+		purl += distro + "/"
+	}
+	purl += pkg.Name + "@" + pkg.Version
+	if pkg.Architecture != "" {
+		purl += "?arch=" + pkg.Architecture
+	}
+	return purl
+}
+
+// sbomPath returns the path of the SBOM stored next to an image archive
+func sbomPath(imagePath string) string {
+	return trimImageExtension(imagePath) + sbomExtension
+}
+
+// writeSBOM stores the SBOM of an image in the local image store
+func writeSBOM(image BravetoolsImage, bom cycloneDX) error {
+	imagePath, err := localImagePath(image)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(bom, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(sbomPath(imagePath), content, 0644)
+}
+
+// loadSBOM reads the SBOM of an image from the local image store
+func loadSBOM(imageName string) (bom cycloneDX, image BravetoolsImage, err error) {
+	image, err = ParseImageString(imageName)
+	if err != nil {
+		return bom, image, err
+	}
+
+	imagePath, err := matchLocalImagePath(image)
+	if err != nil {
+		return bom, image, err
+	}
+
+	content, err := ioutil.ReadFile(sbomPath(imagePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bom, image, fmt.Errorf("no SBOM recorded for image %q - rebuild it to generate one", imageName)
+		}
+		return bom, image, err
+	}
+
+	err = json.Unmarshal(content, &bom)
+	if err != nil {
+		return bom, image, fmt.Errorf("failed to parse SBOM for image %q: %s", imageName, err)
+	}
+
+	return bom, image, nil
+}
+
+// PrintSBOM prints the packages recorded for an image, or the raw CycloneDX document if asJSON is set
+func (bh *BraveHost) PrintSBOM(imageName string, asJSON bool) error {
+	bom, _, err := loadSBOM(imageName)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		content, err := json.MarshalIndent(bom, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+
+	table := newPlainTable(os.Stdout, []string{"Package", "Version", "License"})
+	for _, c := range bom.Components {
+		var licenses []string
+		for _, l := range c.Licenses {
+			licenses = append(licenses, l.License.Name)
+		}
+		table.Append([]string{c.Name, c.Version, strings.Join(licenses, ", ")})
+	}
+	table.Render()
+
+	fmt.Printf("\nTotal: %d packages\n", len(bom.Components))
+
+	return nil
+}
+
+// packageChange is a package that differs between two images
+type packageChange struct {
+	name     string
+	versionA string
+	versionB string
+}
+
+// diffPackages compares the package versions of two SBOMs.
+// Packages are matched by their purl without the version, so packages installed for several architectures are compared
+// per architecture, and several installed versions of one package are compared together.
+func diffPackages(a cycloneDX, b cycloneDX) (changes []packageChange) {
+	versionsA := packageVersions(a)
+	versionsB := packageVersions(b)
+
+	// Architectures are only shown for packages installed for more than one
+	names := map[string]map[string]bool{}
+	for _, versions := range []map[string]installedPackage{versionsA, versionsB} {
+		for key, pkg := range versions {
+			if names[pkg.name] == nil {
+				names[pkg.name] = map[string]bool{}
+			}
+			names[pkg.name][key] = true
+		}
+	}
+	displayName := func(pkg installedPackage) string {
+		if len(names[pkg.name]) > 1 && pkg.arch != "" {
+			return pkg.name + ":" + pkg.arch
+		}
+		return pkg.name
+	}
+
+	for key, pkgA := range versionsA {
+		if pkgB := versionsB[key]; pkgA.version != pkgB.version {
+			changes = append(changes, packageChange{name: displayName(pkgA), versionA: pkgA.version, versionB: pkgB.version})
+		}
+	}
+	for key, pkgB := range versionsB {
+		if _, ok := versionsA[key]; !ok {
+			changes = append(changes, packageChange{name: displayName(pkgB), versionB: pkgB.version})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].name < changes[j].name
+	})
+
+	return changes
+}
+
+// installedPackage is a package in an SBOM with all of its installed versions
+type installedPackage struct {
+	name    string
+	arch    string
+	version string
+}
+
+// packageVersions maps the packages of an SBOM by their key, joining the versions of packages installed more than once
+func packageVersions(bom cycloneDX) map[string]installedPackage {
+	versions := map[string][]string{}
+	packages := map[string]installedPackage{}
+	for _, c := range bom.Components {
+		key, arch := packageKey(c)
+		versions[key] = append(versions[key], c.Version)
+		packages[key] = installedPackage{name: c.Name, arch: arch}
+	}
+
+	for key, pkg := range packages {
+		sort.Strings(versions[key])
+		pkg.version = strings.Join(versions[key], ", ")
+		packages[key] = pkg
+	}
+	return packages
+}
+
+// packageKey identifies a package regardless of its version - the purl without the version, keeping qualifiers such as
+// the architecture. Components without a purl are identified by name.
+func packageKey(c cycloneDXComponent) (key string, arch string) {
+	if c.PURL == "" {
+		return c.Name, ""
+	}
+
+	purl, qualifiers := c.PURL, ""
+	if i := strings.IndexAny(purl, "?#"); i >= 0 {
+		purl, qualifiers = purl[:i], purl[i:]
+	}
+	if i := strings.LastIndex(purl, "@"); i >= 0 {
+		purl = purl[:i]
+	}
+
+	if strings.HasPrefix(qualifiers, "?") {
+		query, _ := url.ParseQuery(strings.SplitN(qualifiers[1:], "#", 2)[0])
+		arch = query.Get("arch")
+	}
+	return purl + qualifiers, arch
+}
+
+// DiffPackages prints packages added, removed or changed between two images
+func (bh *BraveHost) DiffPackages(imageA string, imageB string) error {
+	bomA, a, err := loadSBOM(imageA)
+	if err != nil {
+		return err
+	}
+	bomB, b, err := loadSBOM(imageB)
+	if err != nil {
+		return err
+	}
+
+	changes := diffPackages(bomA, bomB)
+	if len(changes) == 0 {
+		fmt.Println("No package differences")
+		return nil
+	}
+
+	table := newPlainTable(os.Stdout, []string{"", "Package", a.String(), b.String()})
+	var added, removed, changed int
+	for _, c := range changes {
+		var marker string
+		switch {
+		case c.versionA == "":
+			marker = "+"
+			added++
+		case c.versionB == "":
+			marker = "-"
+			removed++
+		default:
+			marker = "~"
+			changed++
+		}
+		table.Append([]string{marker, c.name, c.versionA, c.versionB})
+	}
+	table.Render()
+
+	fmt.Printf("\n%d added, %d removed, %d changed\n", added, removed, changed)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.1-6
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: zlib1g
+Status: install ok installed
+Architecture: amd64
+Version: 1:1.2.11.dfsg-2
+`
+
+	expected := []sbomPackage{
+		{Name: "bash", Version: "5.1-6", Architecture: "amd64"},
+		{Name: "zlib1g", Version: "1:1.2.11.dfsg-2", Architecture: "amd64"},
+	}
+	if packages := parseDpkgStatus(status); !reflect.DeepEqual(packages, expected) {
+		t.Fatalf("expected %+v, found %+v", expected, packages)
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := "C:Q1abc=\nP:musl\nV:1.2.3-r4\nA:x86_64\nL:MIT\n\nP:busybox\nV:1.35.0-r17\nA:x86_64\nL:GPL-2.0-only\n"
+
+	expected := []sbomPackage{
+		{Name: "musl", Version: "1.2.3-r4", Architecture: "x86_64", License: "MIT"},
+		{Name: "busybox", Version: "1.35.0-r17", Architecture: "x86_64", License: "GPL-2.0-only"},
+	}
+	if packages := parseApkInstalled(installed); !reflect.DeepEqual(packages, expected) {
+		t.Fatalf("expected %+v, found %+v", expected, packages)
+	}
+}
+
+func TestDiffPackages(t *testing.T) {
+	image := BravetoolsImage{Name: "app", Version: "1.0"}
+	a := newCycloneDX(image, []sbomPackage{{Name: "bash", Version: "5.0"}, {Name: "curl", Version: "7.0"}}, "deb", "debian")
+	b := newCycloneDX(image, []sbomPackage{{Name: "bash", Version: "5.1"}, {Name: "jq", Version: "1.6"}}, "deb", "debian")
+
+	expected := []packageChange{
+		{name: "bash", versionA: "5.0", versionB: "5.1"},
+		{name: "curl", versionA: "7.0"},
+		{name: "jq", versionB: "1.6"},
+	}
+	if changes := diffPackages(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %+v, found %+v", expected, changes)
+	}
+
+	if purl := a.Components[0].PURL; purl != "pkg:deb/debian/bash@5.0" {
+		t.Fatalf("unexpected package URL %q", purl)
+	}
+}
+
+func TestDiffPackagesMultiArch(t *testing.T) {
+	image := BravetoolsImage{Name: "app", Version: "1.0"}
+	a := newCycloneDX(image, []sbomPackage{
+		{Name: "libc6", Version: "2.31", Architecture: "amd64"},
+		{Name: "libc6", Version: "2.31", Architecture: "i386"},
+		{Name: "kernel", Version: "5.14.0-1", Architecture: "x86_64"},
+	}, "rpm", "")
+	b := newCycloneDX(image, []sbomPackage{
+		{Name: "libc6", Version: "2.31", Architecture: "amd64"},
+		{Name: "kernel", Version: "5.14.0-1", Architecture: "x86_64"},
+		{Name: "kernel", Version: "5.14.0-2", Architecture: "x86_64"},
+	}, "rpm", "")
+
+	// Only the i386 libc6 was removed, and both installed kernels are compared together
+	expected := []packageChange{
+		{name: "kernel", versionA: "5.14.0-1", versionB: "5.14.0-1, 5.14.0-2"},
+		{name: "libc6:i386", versionA: "2.31"},
+	}
+	if changes := diffPackages(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %+v, found %+v", expected, changes)
+	}
+}
@@ -0,0 +1,409 @@
+package platform
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// Signatures are stored next to the image archive they sign
+const signatureExtension = ".sig"
+
+// signaturePayloadPrefix domain-separates image signatures from other uses of a key
+const signaturePayloadPrefix = "bravetools-image-signature-v1\n"
+
+// Signature policies of a remote
+const (
+	// SignaturePolicyPermissive deploys unsigned images and warns about images signed by untrusted keys
+	SignaturePolicyPermissive = "permissive"
+	// SignaturePolicyEnforce refuses to deploy images not signed by a trusted key
+	SignaturePolicyEnforce = "enforce"
+)
+
+// imageSignatures is the detached signature file of an image archive
+type imageSignatures struct {
+	Digest     string           `json:"digest"`
+	Signatures []imageSignature `json:"signatures"`
+}
+
+type imageSignature struct {
+	KeyID     string `json:"key_id"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// signatureStatus is the outcome of verifying an image against the trusted keys
+type signatureStatus int
+
+const (
+	signatureMissing signatureStatus = iota
+	signatureUntrusted
+	signatureTrusted
+)
+
+// signaturePath returns the path of the detached signature of an image archive
+func signaturePath(imagePath string) string {
+	return imagePath + signatureExtension
+}
+
+// keyID identifies a public key by a short hash
+func keyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func signaturePayload(digest string) []byte {
+	return []byte(signaturePayloadPrefix + digest)
+}
+
+// GenerateSigningKey creates an ed25519 key pair under the key store and trusts its public key
+func GenerateSigningKey(name string) (privatePath string, publicPath string, err error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	keyDir := filepath.Join(homeDir, shared.BraveKeyStore)
+	privatePath = filepath.Join(keyDir, name+".key")
+	publicPath = filepath.Join(keyDir, name+".pub")
+	if shared.FileExists(privatePath) {
+		return "", "", fmt.Errorf("signing key %q already exists at %s", name, privatePath)
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", "", err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.MkdirAll(filepath.Join(homeDir, shared.BraveTrustedKeyStore), 0700)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	err = ioutil.WriteFile(publicPath, publicPEM, 0644)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(homeDir, shared.BraveTrustedKeyStore, keyID(publicKey)+".pub"), publicPEM, 0644)
+	if err != nil {
+		return "", "", err
+	}
+
+	return privatePath, publicPath, nil
+}
+
+// TrustKey adds a public key to the set of keys trusted to sign images
+func TrustKey(publicKeyPath string) error {
+	publicKey, err := loadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	trustedDir := filepath.Join(homeDir, shared.BraveTrustedKeyStore)
+	err = os.MkdirAll(trustedDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(trustedDir, keyID(publicKey)+".pub"), content, 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Trusted key %s\n", keyID(publicKey))
+	return nil
+}
+
+// loadPrivateKey reads a PEM encoded ed25519 private key. A bare name refers to a key in the key store.
+func loadPrivateKey(keyPath string) (ed25519.PrivateKey, error) {
+	keyPath, err := resolveKeyPath(keyPath, ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := readPEM(keyPath, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %s", keyPath, err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", keyPath)
+	}
+	return privateKey, nil
+}
+
+// loadPublicKey reads a PEM encoded ed25519 public key
+func loadPublicKey(keyPath string) (ed25519.PublicKey, error) {
+	block, err := readPEM(keyPath, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %s", keyPath, err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", keyPath)
+	}
+	return publicKey, nil
+}
+
+func readPEM(keyPath string, blockType string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %s", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded %s", keyPath, strings.ToLower(blockType))
+	}
+	return block, nil
+}
+
+func resolveKeyPath(keyPath string, extension string) (string, error) {
+	if shared.FileExists(keyPath) {
+		return keyPath, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	storePath := filepath.Join(homeDir, shared.BraveKeyStore, keyPath+extension)
+	if shared.FileExists(storePath) {
+		return storePath, nil
+	}
+
+	return "", fmt.Errorf("key %q not found", keyPath)
+}
+
+// loadTrustedKeys reads all public keys in the trusted key store
+func loadTrustedKeys() (keys []ed25519.PublicKey, err error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	keyFiles, err := filepath.Glob(filepath.Join(homeDir, shared.BraveTrustedKeyStore, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, keyFile := range keyFiles {
+		key, err := loadPublicKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func loadSignatures(sigPath string) (*imageSignatures, error) {
+	content, err := ioutil.ReadFile(sigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var signatures imageSignatures
+	err = json.Unmarshal(content, &signatures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signature file %s: %s", sigPath, err)
+	}
+	return &signatures, nil
+}
+
+// signImage adds a signature by the private key to the signature file of an image archive
+func signImage(imagePath string, digest string, privateKey ed25519.PrivateKey) (string, error) {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	signatures, err := loadSignatures(signaturePath(imagePath))
+	if err != nil || signatures.Digest != digest {
+		signatures = &imageSignatures{Digest: digest}
+	}
+
+	signature := imageSignature{
+		KeyID:     keyID(publicKey),
+		PublicKey: publicKey,
+		Signature: ed25519.Sign(privateKey, signaturePayload(digest)),
+	}
+
+	// Re-signing with the same key replaces its signature
+	replaced := false
+	for i := range signatures.Signatures {
+		if signatures.Signatures[i].KeyID == signature.KeyID {
+			signatures.Signatures[i] = signature
+			replaced = true
+		}
+	}
+	if !replaced {
+		signatures.Signatures = append(signatures.Signatures, signature)
+	}
+
+	content, err := json.MarshalIndent(signatures, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return signature.KeyID, ioutil.WriteFile(signaturePath(imagePath), content, 0644)
+}
+
+// verifySignatures checks the signatures of an image archive with the provided digest.
+// An error is returned if any signature is invalid or was made for different content.
+func verifySignatures(signatures *imageSignatures, digest string, trusted []ed25519.PublicKey) (status signatureStatus, signer string, err error) {
+	if signatures == nil || len(signatures.Signatures) == 0 {
+		return signatureMissing, "", nil
+	}
+
+	if signatures.Digest != digest {
+		return signatureMissing, "", fmt.Errorf("signature was made for %s but image digest is %s", signatures.Digest, digest)
+	}
+
+	status = signatureUntrusted
+	for _, signature := range signatures.Signatures {
+		if len(signature.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(signature.PublicKey, signaturePayload(digest), signature.Signature) {
+			return signatureMissing, "", fmt.Errorf("invalid signature by key %s", signature.KeyID)
+		}
+
+		signer = signature.KeyID
+		for _, key := range trusted {
+			if bytes.Equal(key, signature.PublicKey) {
+				return signatureTrusted, signer, nil
+			}
+		}
+	}
+
+	return status, signer, nil
+}
+
+// checkImageSignature verifies the signature of an image archive against the trusted keys and applies a signature policy
+func checkImageSignature(image BravetoolsImage, imagePath string, digest string, policy string) error {
+	var signatures *imageSignatures
+	if shared.FileExists(signaturePath(imagePath)) {
+		var err error
+		signatures, err = loadSignatures(signaturePath(imagePath))
+		if err != nil {
+			return err
+		}
+	}
+
+	trusted, err := loadTrustedKeys()
+	if err != nil {
+		return fmt.Errorf("failed to load trusted keys: %s", err)
+	}
+
+	status, signer, err := verifySignatures(signatures, digest, trusted)
+	if err != nil {
+		return fmt.Errorf("image %q failed signature verification: %s", image, err)
+	}
+
+	switch status {
+	case signatureTrusted:
+		return nil
+	case signatureUntrusted:
+		if policy == SignaturePolicyEnforce {
+			return fmt.Errorf("image %q is signed by untrusted key %s", image, signer)
+		}
+		fmt.Println(shared.Warn(fmt.Sprintf("Warning: image %q is signed by untrusted key %s", image, signer)))
+	case signatureMissing:
+		if policy == SignaturePolicyEnforce {
+			return fmt.Errorf("image %q is not signed", image)
+		}
+	}
+
+	return nil
+}
+
+// ValidateSignaturePolicy checks a signature policy name
+func ValidateSignaturePolicy(policy string) error {
+	switch policy {
+	case "", SignaturePolicyPermissive, SignaturePolicyEnforce:
+		return nil
+	}
+	return fmt.Errorf("unknown signature policy %q - expected %q or %q", policy, SignaturePolicyPermissive, SignaturePolicyEnforce)
+}
+
+// SignImage signs a local image with an ed25519 private key. The detached signature is stored next to the image.
+func (bh *BraveHost) SignImage(imageName string, keyPath string) error {
+	if keyPath == "" {
+		return errors.New("no signing key provided")
+	}
+
+	privateKey, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	record, err := store.match(image)
+	if err != nil {
+		return err
+	}
+
+	signer, err := signImage(store.blobPath(record.Digest), record.Digest, privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign image %q: %s", record.image(), err)
+	}
+
+	fmt.Printf("Signed image %q with key %s\n", record.image(), signer)
+	return nil
+}
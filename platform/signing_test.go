@@ -0,0 +1,76 @@
+package platform
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestImageSignatures(t *testing.T) {
+	storeDir := newTestImageStore(t)
+	image := BravetoolsImage{Name: "app", Version: "1.0"}
+
+	imagePath := filepath.Join(storeDir, "app_1.0.tar.gz")
+	writeTestFile(t, imagePath, "app")
+	digest := "sha256:0123"
+
+	// Unsigned images are only refused by the enforce policy
+	if err := checkImageSignature(image, imagePath, digest, SignaturePolicyPermissive); err != nil {
+		t.Fatalf("unsigned image refused by permissive policy: %s", err)
+	}
+	if err := checkImageSignature(image, imagePath, digest, SignaturePolicyEnforce); err == nil {
+		t.Fatal("unsigned image accepted by enforce policy")
+	}
+
+	privatePath, publicPath, err := GenerateSigningKey("release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := loadPrivateKey(privatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signImage(imagePath, digest, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkImageSignature(image, imagePath, digest, SignaturePolicyEnforce); err != nil {
+		t.Fatalf("image signed by trusted key refused: %s", err)
+	}
+	if err = checkImageSignature(image, imagePath, "sha256:4567", SignaturePolicyPermissive); err == nil {
+		t.Fatal("signature accepted for different image content")
+	}
+
+	// Signing again with the same key replaces the signature
+	if _, err = signImage(imagePath, digest, privateKey); err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := loadSignatures(signaturePath(imagePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures.Signatures) != 1 {
+		t.Fatalf("expected 1 signature, got %d", len(signatures.Signatures))
+	}
+
+	// Tampered signatures are rejected regardless of policy
+	signatures.Signatures[0].Signature[0] ^= 0xff
+	if _, _, err = verifySignatures(signatures, digest, nil); err == nil {
+		t.Fatal("tampered signature accepted")
+	}
+
+	// Keys not in the trusted store are refused by the enforce policy
+	t.Setenv("HOME", t.TempDir())
+	if err = checkImageSignature(image, imagePath, digest, SignaturePolicyPermissive); err != nil {
+		t.Fatalf("untrusted signature refused by permissive policy: %s", err)
+	}
+	if err = checkImageSignature(image, imagePath, digest, SignaturePolicyEnforce); err == nil {
+		t.Fatal("untrusted signature accepted by enforce policy")
+	}
+
+	if err = TrustKey(publicPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkImageSignature(image, imagePath, digest, SignaturePolicyEnforce); err != nil {
+		t.Fatalf("image signed by newly trusted key refused: %s", err)
+	}
+}
@@ -0,0 +1,217 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+	"github.com/lxc/lxd/shared/simplestreams"
+)
+
+// defaultPublicImageServer is the simplestreams server of public base images unless public_image_server is configured
+const defaultPublicImageServer = "https://images.linuxcontainers.org"
+
+// Simplestreams layout generated in an image store. Blobs are referenced where they are stored.
+const (
+	simplestreamsIndexPath     = "streams/v1/index.json"
+	simplestreamsImagesPath    = "streams/v1/images.json"
+	simplestreamsFileType      = "lxd_combined.tar.gz"
+	simplestreamsVersionLayout = "20060102_1504"
+	simplestreamsUpdatedLayout = time.RFC1123Z
+	simplestreamsDefaultOS     = "Bravetools"
+)
+
+// publicImageServerURL returns the simplestreams server used for public base images
+func publicImageServerURL() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return defaultPublicImageServer
+	}
+	settings, err := loadHostSettings(homeDir)
+	if err != nil || settings.PublicImageServer == "" {
+		return defaultPublicImageServer
+	}
+	return settings.PublicImageServer
+}
+
+// simplestreamsProducts describes the images of a store as simplestreams products. Images sharing a blob and architecture,
+// such as tags, become aliases of a single product. Images without an architecture cannot be described and are skipped.
+func simplestreamsProducts(store *imageStore) (products simplestreams.Products, skipped []string) {
+	products = simplestreams.Products{
+		ContentID: "images",
+		DataType:  "image-downloads",
+		Format:    "products:1.0",
+		Products:  map[string]simplestreams.Product{},
+		Updated:   time.Now().UTC().Format(simplestreamsUpdatedLayout),
+	}
+
+	// Group images by blob and architecture, listing original images before their tags
+	records := append([]imageRecord{}, store.index.Images...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Target == "" && records[j].Target != ""
+	})
+
+	type product struct {
+		key     string
+		aliases []string
+	}
+	grouped := map[string]*product{}
+	var order []string
+
+	for _, record := range records {
+		if record.Architecture == "" {
+			skipped = append(skipped, record.image().String())
+			continue
+		}
+
+		group := record.Digest + "/" + record.Architecture
+		if _, ok := grouped[group]; !ok {
+			grouped[group] = &product{key: strings.Join([]string{record.Name, record.Version, record.Architecture}, ":")}
+			order = append(order, group)
+
+			hash := strings.TrimPrefix(record.Digest, digestPrefix)
+			osName := simplestreamsDefaultOS
+			if metadata, err := readImageMetadata(store.blobPath(record.Digest)); err == nil && metadata.Properties["os"] != "" {
+				osName = metadata.Properties["os"]
+			}
+
+			products.Products[grouped[group].key] = simplestreams.Product{
+				Architecture:    record.Architecture,
+				OperatingSystem: osName,
+				Release:         record.Version,
+				ReleaseTitle:    record.Version,
+				Versions: map[string]simplestreams.ProductVersion{
+					record.Created.UTC().Format(simplestreamsVersionLayout): {
+						Items: map[string]simplestreams.ProductVersionItem{
+							simplestreamsFileType: {
+								FileType:   simplestreamsFileType,
+								Path:       path.Join(imageBlobDir, hash),
+								HashSha256: hash,
+								Size:       record.Size,
+							},
+						},
+					},
+				},
+			}
+		}
+
+		image := BravetoolsImage{Name: record.Name, Version: record.Version}
+		grouped[group].aliases = append(grouped[group].aliases, image.String(), record.image().String())
+	}
+
+	for _, group := range order {
+		product := products.Products[grouped[group].key]
+		product.Aliases = strings.Join(grouped[group].aliases, ",")
+		products.Products[grouped[group].key] = product
+	}
+
+	return products, skipped
+}
+
+// simplestreamsStream returns the index pointing at the products of an image store
+func simplestreamsStream(products simplestreams.Products) simplestreams.Stream {
+	keys := []string{}
+	for key := range products.Products {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return simplestreams.Stream{
+		Format:  "index:1.0",
+		Updated: products.Updated,
+		Index: map[string]simplestreams.StreamIndex{
+			"images": {
+				DataType: products.DataType,
+				Path:     simplestreamsImagesPath,
+				Format:   products.Format,
+				Updated:  products.Updated,
+				Products: keys,
+			},
+		},
+	}
+}
+
+// writeSimplestreamsFile atomically writes a simplestreams JSON file below the store root
+func writeSimplestreamsFile(root string, name string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(root, filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// GenerateSimplestreams writes simplestreams index files describing the image store in root, or the local image store if
+// root is empty, and makes the indexed blobs readable by all users. Serving root with any web server makes its images
+// available to LXD as a simplestreams remote.
+func GenerateSimplestreams(root string) error {
+	if root == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		root = filepath.Join(homeDir, shared.ImageStore)
+	}
+
+	store, err := openImageStoreAt(root)
+	if err != nil {
+		return err
+	}
+
+	products, skipped := simplestreamsProducts(store)
+	for _, image := range skipped {
+		fmt.Println(shared.Warn("skipping image " + image + " without an architecture"))
+	}
+
+	// Indexed blobs must be readable by the web server serving the store
+	for _, record := range store.index.Images {
+		if record.Architecture == "" {
+			continue
+		}
+		err = os.Chmod(store.blobPath(record.Digest), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeSimplestreamsFile(root, simplestreamsImagesPath, products)
+	if err != nil {
+		return err
+	}
+	err = writeSimplestreamsFile(root, simplestreamsIndexPath, simplestreamsStream(products))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote simplestreams index of %d images to %s\n", len(products.Products), filepath.Join(root, "streams"))
+	return nil
+}
@@ -0,0 +1,139 @@
+package platform
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bravetools/bravetools/shared"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/simplestreams"
+)
+
+// newTestSimplestreamsStore creates an image store holding one image and a tag of it
+func newTestSimplestreamsStore(t *testing.T) (*imageStore, *imageRecord) {
+	root := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "app.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err = gz.Write(writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: x86_64\ncreation_date: 0\nproperties:\n  os: alpine\n"},
+		{"rootfs/", tar.TypeDir, ""},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	f.Close()
+
+	store, err := openImageStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.add(archive, BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordCopy := *record
+	if _, err = store.tag(recordCopy.image(), BravetoolsImage{Name: "app", Version: "stable"}); err != nil {
+		t.Fatal(err)
+	}
+	return store, &recordCopy
+}
+
+func TestGenerateSimplestreams(t *testing.T) {
+	store, record := newTestSimplestreamsStore(t)
+
+	// Blobs only become readable by other users once they are indexed for a web server
+	blobMode := func() os.FileMode {
+		info, err := os.Stat(store.blobPath(record.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Mode().Perm()
+	}
+	if mode := blobMode(); mode&0044 != 0 {
+		t.Fatalf("expected stored blob to be private, found mode %o", mode)
+	}
+
+	if err := GenerateSimplestreams(store.root); err != nil {
+		t.Fatal(err)
+	}
+	if mode := blobMode(); mode != 0644 {
+		t.Fatalf("expected indexed blob to be readable, found mode %o", mode)
+	}
+
+	content, err := os.ReadFile(filepath.Join(store.root, filepath.FromSlash(simplestreamsImagesPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var products simplestreams.Products
+	if err = json.Unmarshal(content, &products); err != nil {
+		t.Fatal(err)
+	}
+
+	images, downloads := products.ToLXD()
+	if len(images) != 1 {
+		t.Fatalf("expected the image and its tag to be one product, found %d images", len(images))
+	}
+	image := images[0]
+	if "sha256:"+image.Fingerprint != record.Digest || image.Properties["os"] != "alpine" {
+		t.Fatalf("unexpected image %+v", image)
+	}
+
+	aliases := map[string]bool{}
+	for _, alias := range image.Aliases {
+		aliases[alias.Name] = true
+	}
+	for _, alias := range []string{"app/1.0", "app/1.0/x86_64", "app/stable", "app/stable/x86_64"} {
+		if !aliases[alias] {
+			t.Errorf("expected alias %q, found %v", alias, image.Aliases)
+		}
+	}
+
+	files := downloads[image.Fingerprint]
+	if len(files) != 1 || !shared.FileExists(filepath.Join(store.root, filepath.FromSlash(files[0][0]))) {
+		t.Fatalf("expected download path of the blob, found %v", files)
+	}
+}
+
+func TestRegistrySimplestreams(t *testing.T) {
+	store, record := newTestSimplestreamsStore(t)
+
+	registry, err := NewRegistryServer(store.root, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.simplestreams = true
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	lxdServer, err := lxd.ConnectSimpleStreams(server.URL, &lxd.ConnectionArgs{TLSServerCert: string(cert)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint, err := GetFingerprintByAlias(lxdServer, "app/stable", "x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digestPrefix+fingerprint != record.Digest {
+		t.Fatalf("alias resolved to %s, expected %s", fingerprint, record.Digest)
+	}
+}
+
+func TestServeRegistryPublicSimplestreamsWithToken(t *testing.T) {
+	err := ServeRegistry(RegistryConfig{Root: t.TempDir(), Address: "127.0.0.1:0", Token: "secret", PublicSimplestreams: true})
+	if err == nil || !strings.Contains(err.Error(), "--allow-public") {
+		t.Fatalf("expected public simplestreams with a token to require confirmation, found %v", err)
+	}
+}
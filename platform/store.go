@@ -0,0 +1,654 @@
+package platform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// Layout of the content-addressed image store under shared.ImageStore
+const (
+	imageIndexFile    = "index.json"
+	imageLockFile     = "index.json.lock"
+	imageBlobDir      = "blobs/sha256"
+	imageIndexVersion = 1
+	digestPrefix      = "sha256:"
+)
+
+// imageRecord is an entry of the image store index pointing a name, version and architecture at an image blob
+type imageRecord struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Architecture string            `json:"architecture"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Created      time.Time         `json:"created"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// Target is set on aliases created by tagging and names the image the alias was created from
+	Target string `json:"target,omitempty"`
+}
+
+func (record imageRecord) image() BravetoolsImage {
+	return BravetoolsImage{
+		Name:         record.Name,
+		Version:      record.Version,
+		Architecture: record.Architecture,
+		size:         record.Size,
+		modTime:      record.Created,
+		hashString:   record.Digest,
+	}
+}
+
+// imageIndex is the JSON index of the image store
+type imageIndex struct {
+	Version int           `json:"version"`
+	Images  []imageRecord `json:"images"`
+}
+
+// imageStore is the local image store. Image archives are stored once per content digest and looked up through the index.
+type imageStore struct {
+	root  string
+	index imageIndex
+	// locked is set while an update holds the store lock, so updates can nest
+	locked bool
+}
+
+// openImageStore loads the image store index, migrating a legacy flat image store if no index exists yet
+func openImageStore() (*imageStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bravetools image store: %s", err)
+	}
+
+	return openImageStoreAt(filepath.Join(homeDir, shared.ImageStore))
+}
+
+// openImageStoreAt loads the index of the image store in root
+func openImageStoreAt(root string) (*imageStore, error) {
+	store := &imageStore{
+		root:  root,
+		index: imageIndex{Version: imageIndexVersion},
+	}
+
+	exists, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		var migrated map[string]string
+		err = store.update(func() (err error) {
+			migrated, err = store.migrate()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate image store: %s", err)
+		}
+		store.removeLegacyFiles(migrated)
+	}
+
+	return store, nil
+}
+
+// load reads the index from disk, reporting whether it exists. A missing index leaves the store empty.
+func (store *imageStore) load() (bool, error) {
+	store.index = imageIndex{Version: imageIndexVersion}
+
+	content, err := ioutil.ReadFile(store.indexPath())
+	switch {
+	case err == nil:
+		err = json.Unmarshal(content, &store.index)
+		if err != nil {
+			return true, fmt.Errorf("failed to parse image store index %q: %s", store.indexPath(), err)
+		}
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to read image store index: %s", err)
+	}
+}
+
+// update runs fn on a freshly loaded index while holding a lock on the store, then saves the index. Other bravetools
+// processes take the same lock, so concurrent builds, pulls, prunes and registry uploads do not lose each other's records.
+// Updates started from within fn run as part of the outer update.
+func (store *imageStore) update(fn func() error) error {
+	if store.locked {
+		return fn()
+	}
+
+	err := os.MkdirAll(store.root, 0755)
+	if err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(store.root, imageLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to lock image store: %s", err)
+	}
+	defer lock.Close()
+
+	if err = lockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock image store: %s", err)
+	}
+	defer unlockFile(lock)
+
+	store.locked = true
+	defer func() {
+		store.locked = false
+	}()
+
+	if _, err = store.load(); err != nil {
+		return err
+	}
+	if err = fn(); err != nil {
+		return err
+	}
+	return store.save()
+}
+
+func (store *imageStore) indexPath() string {
+	return filepath.Join(store.root, imageIndexFile)
+}
+
+// blobPath returns the path of the image archive with the provided digest
+func (store *imageStore) blobPath(digest string) string {
+	return filepath.Join(store.root, filepath.FromSlash(imageBlobDir), strings.TrimPrefix(digest, digestPrefix))
+}
+
+// save atomically writes the index. Changes to the index must be made within update.
+func (store *imageStore) save() error {
+	content, err := json.MarshalIndent(store.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(store.root, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(store.root, imageIndexFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.indexPath())
+}
+
+// exact returns the record matching an image exactly. Legacy images without an architecture match any architecture.
+func (store *imageStore) exact(image BravetoolsImage) (*imageRecord, error) {
+	for i, record := range store.index.Images {
+		if record.Name == image.Name && record.Version == image.Version &&
+			(record.Architecture == image.Architecture || record.Architecture == "") {
+			return &store.index.Images[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to retrieve path for image %s, version: %s, arch: %s ", image.Name, image.Version, image.Architecture)
+}
+
+// match returns the record matching an image, treating empty version and architecture fields as wildcards.
+// If more than one record matches a formatted error of type 'multipleImageMatches' is returned.
+func (store *imageStore) match(image BravetoolsImage) (*imageRecord, error) {
+	// Before querying candidates, attempt to exactly match the provided image definition
+	if record, err := store.exact(image); err == nil {
+		return record, nil
+	}
+
+	// Resolve version constraints to the best matching version before matching
+	if isVersionConstraint(image.Version) {
+		version, err := store.resolveVersion(image)
+		if err != nil {
+			return nil, err
+		}
+		image.Version = version
+		if record, err := store.exact(image); err == nil {
+			return record, nil
+		}
+	}
+
+	var matches []int
+	for i, record := range store.index.Images {
+		if record.Name != image.Name {
+			continue
+		}
+		if image.Version != "" && record.Version != image.Version {
+			continue
+		}
+		if image.Architecture != "" && !sameArchitecture(record.Architecture, image.Architecture) {
+			continue
+		}
+		matches = append(matches, i)
+	}
+
+	switch {
+	case len(matches) == 1:
+		return &store.index.Images[matches[0]], nil
+	case len(matches) > 1:
+		// Multiple matches - ambiguous result. Return formatted error with the options.
+		candidates := make([]BravetoolsImage, len(matches))
+		for i, match := range matches {
+			candidates[i] = store.index.Images[match].image()
+		}
+		return nil, multipleImageMatches{image: image, matches: candidates}
+	}
+
+	return nil, fmt.Errorf("failed to retrieve path for image %s, version: %s, arch: %s ", image.Name, image.Version, image.Architecture)
+}
+
+// resolveVersion resolves the version constraint of an image against the versions in the store
+func (store *imageStore) resolveVersion(image BravetoolsImage) (string, error) {
+	var candidates []versionCandidate
+	for _, record := range store.index.Images {
+		if record.Name != image.Name {
+			continue
+		}
+		if image.Architecture != "" && record.Architecture != "" && !sameArchitecture(record.Architecture, image.Architecture) {
+			continue
+		}
+		candidates = append(candidates, versionCandidate{version: record.Version, created: record.Created})
+	}
+
+	version, err := selectVersion(image, candidates)
+	if err != nil {
+		return "", fmt.Errorf("%s in local image store", err)
+	}
+	return version, nil
+}
+
+// add copies an image archive into the store and records it in the index.
+// Archives with identical content are only stored once.
+func (store *imageStore) add(sourcePath string, image BravetoolsImage, labels map[string]string) (*imageRecord, error) {
+	if err := checkConcreteVersion(image); err != nil {
+		return nil, err
+	}
+
+	err := store.update(func() error {
+		if _, err := store.exact(image); err == nil {
+			return &ImageExistsError{Name: image.String()}
+		}
+
+		digest, size, err := store.writeBlob(sourcePath)
+		if err != nil {
+			return err
+		}
+
+		store.index.Images = append(store.index.Images, imageRecord{
+			Name:         image.Name,
+			Version:      image.Version,
+			Architecture: image.Architecture,
+			Digest:       digest,
+			Size:         size,
+			Created:      time.Now().UTC(),
+			Labels:       labels,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.index.Images[len(store.index.Images)-1], nil
+}
+
+// link adds an image record for a blob already in the store without copying any data
+func (store *imageStore) link(image BravetoolsImage, digest string) (*imageRecord, error) {
+	if err := checkConcreteVersion(image); err != nil {
+		return nil, err
+	}
+
+	err := store.update(func() error {
+		if _, err := store.exact(image); err == nil {
+			return &ImageExistsError{Name: image.String()}
+		}
+
+		var size int64 = -1
+		for _, record := range store.index.Images {
+			if record.Digest == digest {
+				size = record.Size
+				break
+			}
+		}
+		if size < 0 {
+			return fmt.Errorf("no image with digest %s in local image store", digest)
+		}
+
+		store.index.Images = append(store.index.Images, imageRecord{
+			Name:         image.Name,
+			Version:      image.Version,
+			Architecture: image.Architecture,
+			Digest:       digest,
+			Size:         size,
+			Created:      time.Now().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.index.Images[len(store.index.Images)-1], nil
+}
+
+// writeBlob copies a file into the blob directory, hashing it on the way
+func (store *imageStore) writeBlob(sourcePath string) (digest string, size int64, err error) {
+	blobDir := filepath.Join(store.root, filepath.FromSlash(imageBlobDir))
+	err = os.MkdirAll(blobDir, 0755)
+	if err != nil {
+		return "", 0, err
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer source.Close()
+
+	tmp, err := ioutil.TempFile(blobDir, ".incoming-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, hasher), source)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	digest = digestPrefix + hex.EncodeToString(hasher.Sum(nil))
+
+	// Deduplicate - an identical blob is already stored
+	if shared.FileExists(store.blobPath(digest)) {
+		return digest, size, nil
+	}
+
+	return digest, size, os.Rename(tmp.Name(), store.blobPath(digest))
+}
+
+// remove deletes an image from the index. The blob and its side files are deleted once no image references them.
+func (store *imageStore) remove(record *imageRecord) error {
+	// The index is reloaded by the update, so the record is found again by its contents
+	removed := *record
+
+	return store.update(func() error {
+		for i, record := range store.index.Images {
+			if record.Name == removed.Name && record.Version == removed.Version &&
+				record.Architecture == removed.Architecture && record.Digest == removed.Digest {
+				store.index.Images = append(store.index.Images[:i], store.index.Images[i+1:]...)
+				break
+			}
+		}
+
+		// Save before deleting the blob so the index never references a missing blob
+		err := store.save()
+		if err != nil {
+			return err
+		}
+
+		return store.removeUnreferencedBlob(removed.Digest)
+	})
+}
+
+// removeUnreferencedBlob deletes a blob and its side files if no image references it
+func (store *imageStore) removeUnreferencedBlob(digest string) error {
+	if store.references(digest) > 0 {
+		return nil
+	}
+
+	blob := store.blobPath(digest)
+	err := os.Remove(blob)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(sbomPath(blob))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(signaturePath(blob))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// tag creates aliases for the images matching src under the name and version of dst. Aliases share the blob of their
+// target so no data is copied. If src matches several architectures an alias is created for each. Existing aliases are moved.
+func (store *imageStore) tag(src BravetoolsImage, dst BravetoolsImage) (aliases []imageRecord, err error) {
+	if dst.Version == "" {
+		return nil, fmt.Errorf("alias %q must include a version, e.g. %s/stable", dst, dst.Name)
+	}
+	if err := checkConcreteVersion(dst); err != nil {
+		return nil, err
+	}
+
+	err = store.update(func() error {
+		aliases, err = store.tagLocked(src, dst)
+		return err
+	})
+	return aliases, err
+}
+
+// tagLocked creates the aliases of tag within an update of the index
+func (store *imageStore) tagLocked(src BravetoolsImage, dst BravetoolsImage) (aliases []imageRecord, err error) {
+	var targets []imageRecord
+	if record, err := store.match(src); err == nil {
+		targets = []imageRecord{*record}
+	} else if errors.As(err, &multipleImageMatches{}) && src.Version != "" && src.Architecture == "" {
+		// Tag every architecture of a multi-architecture image
+		for _, record := range store.index.Images {
+			if record.Name == src.Name && record.Version == src.Version {
+				targets = append(targets, record)
+			}
+		}
+	} else {
+		return nil, err
+	}
+
+	if dst.Architecture != "" && (len(targets) > 1 || targets[0].Architecture != dst.Architecture) {
+		return nil, fmt.Errorf("alias %q cannot change the architecture of %q", dst, src)
+	}
+
+	for _, target := range targets {
+		alias := imageRecord{
+			Name:         dst.Name,
+			Version:      dst.Version,
+			Architecture: target.Architecture,
+			Digest:       target.Digest,
+			Size:         target.Size,
+			Created:      target.Created,
+			Labels:       target.Labels,
+			Target:       target.image().String(),
+		}
+		// Aliases of aliases point at the original image
+		if target.Target != "" {
+			alias.Target = target.Target
+		}
+
+		if existing, err := store.exact(alias.image()); err == nil {
+			if existing.Architecture != alias.Architecture || existing.Target == "" {
+				return nil, &ImageExistsError{Name: alias.image().String()}
+			}
+			*existing = alias
+		} else {
+			store.index.Images = append(store.index.Images, alias)
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, nil
+}
+
+// untag removes the aliases matching an image. Images that are not aliases cannot be untagged.
+func (store *imageStore) untag(image BravetoolsImage) (removed []imageRecord, err error) {
+	err = store.update(func() error {
+		removed, err = store.untagLocked(image)
+		return err
+	})
+	return removed, err
+}
+
+// untagLocked removes the aliases of untag within an update of the index
+func (store *imageStore) untagLocked(image BravetoolsImage) (removed []imageRecord, err error) {
+	for {
+		record, err := store.exactAlias(image)
+		if err != nil {
+			break
+		}
+		removed = append(removed, *record)
+		if err = store.remove(record); err != nil {
+			return removed, err
+		}
+	}
+
+	if len(removed) == 0 {
+		if _, err := store.match(image); err == nil {
+			return nil, fmt.Errorf("image %q is not an alias - delete it with `brave remove -i %s`", image, image)
+		}
+		return nil, fmt.Errorf("alias %q does not exist", image)
+	}
+
+	return removed, nil
+}
+
+// exactAlias returns an alias with the name and version of an image, and its architecture if provided
+func (store *imageStore) exactAlias(image BravetoolsImage) (*imageRecord, error) {
+	for i, record := range store.index.Images {
+		if record.Target != "" && record.Name == image.Name && record.Version == image.Version &&
+			(image.Architecture == "" || record.Architecture == image.Architecture) {
+			return &store.index.Images[i], nil
+		}
+	}
+	return nil, fmt.Errorf("alias %q does not exist", image)
+}
+
+// references counts the images pointing at a blob
+func (store *imageStore) references(digest string) (count int) {
+	for _, record := range store.index.Images {
+		if record.Digest == digest {
+			count++
+		}
+	}
+	return count
+}
+
+// images returns all images in the store sorted by name, version and architecture
+func (store *imageStore) images() []BravetoolsImage {
+	images := make([]BravetoolsImage, len(store.index.Images))
+	for i, record := range store.index.Images {
+		images[i] = record.image()
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ToBasename() < images[j].ToBasename()
+	})
+
+	return images
+}
+
+// migrate copies image archives from the legacy flat layout into the blob store and indexes them. It runs within an update
+// and returns the digests of the migrated legacy files, which are only removed once the index is saved.
+// Legacy files are named name_version_arch.tar.gz or name-version.tar.gz with md5 side files.
+func (store *imageStore) migrate() (migrated map[string]string, err error) {
+	var legacyFiles []string
+	for _, extension := range imageExtensions {
+		files, err := filepath.Glob(filepath.Join(store.root, "*"+extension))
+		if err != nil {
+			return nil, err
+		}
+		legacyFiles = append(legacyFiles, files...)
+	}
+
+	if len(legacyFiles) > 0 {
+		fmt.Printf("Migrating %d images to the content-addressed image store\n", len(legacyFiles))
+	}
+
+	migrated = make(map[string]string)
+	for _, legacyFile := range legacyFiles {
+		filename := filepath.Base(legacyFile)
+		// Ignore "hidden" files starting with a full-stop
+		if strings.HasPrefix(filename, ".") {
+			continue
+		}
+
+		var image BravetoolsImage
+		var err error
+		if strings.Contains(filename, "_") {
+			image, err = ImageFromFilename(filename)
+		} else {
+			image, err = ImageFromLegacyFilename(filename)
+		}
+		if err != nil {
+			fmt.Printf("Skipping %q: %s\n", filename, err)
+			continue
+		}
+
+		info, err := os.Stat(legacyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := store.add(legacyFile, image, nil)
+		if err != nil {
+			var existsErr *ImageExistsError
+			if errors.As(err, &existsErr) {
+				fmt.Printf("Skipping duplicate image %q from %q\n", image, filename)
+				continue
+			}
+			return nil, fmt.Errorf("failed to migrate %q: %s", filename, err)
+		}
+		record.Created = info.ModTime().UTC()
+		migrated[legacyFile] = record.Digest
+	}
+
+	return migrated, nil
+}
+
+// removeLegacyFiles removes migrated legacy archives and their md5 side files, moving SBOMs recorded next to them to their blobs
+func (store *imageStore) removeLegacyFiles(migrated map[string]string) {
+	for legacyFile, digest := range migrated {
+		if legacySBOM := sbomPath(legacyFile); shared.FileExists(legacySBOM) {
+			if err := os.Rename(legacySBOM, sbomPath(store.blobPath(digest))); err != nil {
+				fmt.Printf("Failed to move SBOM of %q: %s\n", filepath.Base(legacyFile), err)
+				continue
+			}
+		}
+
+		os.Remove(legacyFile)
+		os.Remove(legacyFile + ".md5")
+	}
+}
+
+// shortDigest abbreviates a digest for display
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, digestPrefix)
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
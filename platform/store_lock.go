@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package platform
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on an open file, blocking until it is available
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
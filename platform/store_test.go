@@ -0,0 +1,286 @@
+package platform
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// newTestImageStore points the image store at an empty temporary home directory
+func newTestImageStore(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	storeDir := filepath.Join(home, shared.ImageStore)
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return storeDir
+}
+
+func writeTestFile(t *testing.T, filePath string, content string) {
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImageStoreMigration(t *testing.T) {
+	storeDir := newTestImageStore(t)
+
+	writeTestFile(t, filepath.Join(storeDir, "alpine_1.0_x86_64.tar.gz"), "alpine")
+	writeTestFile(t, filepath.Join(storeDir, "alpine_1.0_x86_64.tar.gz.md5"), "hash")
+	writeTestFile(t, filepath.Join(storeDir, "legacy-app-2.0.tar.gz"), "legacy")
+
+	images, err := GetLocalImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 migrated images, found %d", len(images))
+	}
+
+	if shared.FileExists(filepath.Join(storeDir, "alpine_1.0_x86_64.tar.gz")) || shared.FileExists(filepath.Join(storeDir, "alpine_1.0_x86_64.tar.gz.md5")) {
+		t.Fatal("expected legacy files to be removed after migration")
+	}
+
+	// Legacy images have no architecture and match any
+	if _, err = localImagePath(BravetoolsImage{Name: "legacy-app", Version: "2.0", Architecture: "aarch64"}); err != nil {
+		t.Fatalf("expected legacy image to be found: %s", err)
+	}
+	if _, err = matchLocalImagePath(BravetoolsImage{Name: "alpine"}); err != nil {
+		t.Fatalf("expected wildcard match for alpine: %s", err)
+	}
+}
+
+func TestImageStoreMigrationFailure(t *testing.T) {
+	storeDir := newTestImageStore(t)
+
+	legacyFile := filepath.Join(storeDir, "alpine_1.0_x86_64.tar.gz")
+	writeTestFile(t, legacyFile, "alpine")
+	writeTestFile(t, legacyFile+".md5", "hash")
+	writeTestFile(t, sbomPath(legacyFile), "sbom")
+
+	// A directory named like an archive cannot be read, so migration fails after alpine is already in the blob store
+	brokenFile := filepath.Join(storeDir, "zz_1.0_x86_64.tar.gz")
+	if err := os.Mkdir(brokenFile, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openImageStore(); err == nil {
+		t.Fatal("expected migration to fail")
+	}
+	for _, file := range []string{legacyFile, legacyFile + ".md5", sbomPath(legacyFile)} {
+		if !shared.FileExists(file) {
+			t.Fatalf("expected %q to be kept after a failed migration", filepath.Base(file))
+		}
+	}
+
+	// Migration is retried on the next open
+	if err := os.Remove(brokenFile); err != nil {
+		t.Fatal(err)
+	}
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.exact(BravetoolsImage{Name: "alpine", Version: "1.0", Architecture: "x86_64"})
+	if err != nil {
+		t.Fatalf("expected alpine to be migrated: %s", err)
+	}
+	if shared.FileExists(legacyFile) || !shared.FileExists(sbomPath(store.blobPath(record.Digest))) {
+		t.Fatal("expected legacy archive to be removed and its SBOM moved after migration")
+	}
+}
+
+func TestImageStoreDeduplication(t *testing.T) {
+	storeDir := newTestImageStore(t)
+	archive := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, archive, "content")
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}
+	copied := BravetoolsImage{Name: "app", Version: "1.1", Architecture: "x86_64"}
+	a, err := store.add(archive, amd64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := a.Digest
+	if _, err = store.add(archive, copied, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.add(archive, amd64, nil); !errors.As(err, new(*ImageExistsError)) {
+		t.Fatalf("expected ImageExistsError when adding an existing image, found %v", err)
+	}
+
+	blobs, _ := filepath.Glob(filepath.Join(storeDir, filepath.FromSlash(imageBlobDir), "*"))
+	if len(blobs) != 1 {
+		t.Fatalf("expected identical archives to share one blob, found %d", len(blobs))
+	}
+
+	_, err = store.match(BravetoolsImage{Name: "app"})
+	if !errors.As(err, &multipleImageMatches{}) {
+		t.Fatalf("expected multiple matches for app, found %v", err)
+	}
+
+	// The blob is kept until the last image referencing it is removed
+	record, _ := store.exact(amd64)
+	if err = store.remove(record); err != nil {
+		t.Fatal(err)
+	}
+	if !shared.FileExists(store.blobPath(digest)) {
+		t.Fatal("expected shared blob to be kept")
+	}
+
+	record, _ = store.exact(copied)
+	if err = store.remove(record); err != nil {
+		t.Fatal(err)
+	}
+	if shared.FileExists(store.blobPath(digest)) {
+		t.Fatal("expected unreferenced blob to be deleted")
+	}
+}
+
+func TestImageStoreLink(t *testing.T) {
+	newTestImageStore(t)
+	archive := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, archive, "content")
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := store.add(archive, BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pulled := BravetoolsImage{Name: "api", Version: "2.0", Architecture: "x86_64"}
+	linked, err := store.link(pulled, local.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.Size != local.Size {
+		t.Fatalf("expected linked image to share size %d, found %d", local.Size, linked.Size)
+	}
+
+	if _, err = store.link(pulled, local.Digest); !errors.As(err, new(*ImageExistsError)) {
+		t.Fatalf("expected ImageExistsError when linking an existing image, found %v", err)
+	}
+	if _, err = store.link(BravetoolsImage{Name: "db", Version: "1.0"}, digestPrefix+"missing"); err == nil {
+		t.Fatal("expected error linking a digest not in the store")
+	}
+}
+
+func TestImageStoreTag(t *testing.T) {
+	newTestImageStore(t)
+	archive := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, archive, "content")
+	newArchive := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, newArchive, "new content")
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		if _, err = store.add(archive, BravetoolsImage{Name: "api", Version: "1.4.2", Architecture: arch}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newest, err := store.add(newArchive, BravetoolsImage{Name: "api", Version: "1.5.0", Architecture: "x86_64"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newestDigest := newest.Digest
+
+	// Tagging a multi-architecture image aliases every architecture
+	aliases, err := store.tag(BravetoolsImage{Name: "api", Version: "1.4.2"}, BravetoolsImage{Name: "api", Version: "stable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 {
+		t.Fatalf("expected 2 aliases, found %d", len(aliases))
+	}
+
+	// Aliases resolve like any other image reference
+	path, err := matchLocalImagePath(BravetoolsImage{Name: "api", Version: "stable", Architecture: "aarch64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != store.blobPath(aliases[0].Digest) {
+		t.Fatalf("expected alias to share the blob of its target, found %q", path)
+	}
+
+	// Moving the alias to a new version
+	if _, err = store.tag(BravetoolsImage{Name: "api", Version: "1.5.0", Architecture: "x86_64"}, BravetoolsImage{Name: "api", Version: "stable"}); err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.exact(BravetoolsImage{Name: "api", Version: "stable", Architecture: "x86_64"})
+	if err != nil || record.Digest != newestDigest {
+		t.Fatalf("expected moved alias to point at api/1.5.0, found %+v (%v)", record, err)
+	}
+
+	// Real images cannot be replaced by or removed as aliases
+	if _, err = store.tag(BravetoolsImage{Name: "api", Version: "stable", Architecture: "x86_64"}, BravetoolsImage{Name: "api", Version: "1.4.2"}); err == nil {
+		t.Fatal("expected err when tagging over an existing image")
+	}
+	if _, err = store.untag(BravetoolsImage{Name: "api", Version: "1.4.2"}); err == nil {
+		t.Fatal("expected err when untagging an image that is not an alias")
+	}
+
+	removed, err := store.untag(BravetoolsImage{Name: "api", Version: "stable"})
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("expected both aliases to be removed, found %d (%v)", len(removed), err)
+	}
+	if !shared.FileExists(store.blobPath(newestDigest)) {
+		t.Fatal("expected untag to keep the target image")
+	}
+}
+
+func TestImageStoreConcurrentUpdates(t *testing.T) {
+	storeDir := newTestImageStore(t)
+	source := filepath.Join(t.TempDir(), "image.tar.gz")
+	writeTestFile(t, source, "image")
+
+	// Stores opened before each other's changes must not overwrite them
+	first, err := openImageStoreAt(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := openImageStoreAt(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = first.add(source, BravetoolsImage{Name: "one", Version: "1.0", Architecture: "x86_64"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = second.add(source, BravetoolsImage{Name: "two", Version: "1.0", Architecture: "x86_64"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing through a stale store keeps the blob referenced by the other image
+	if err = first.remove(&first.index.Images[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openImageStoreAt(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.index.Images) != 1 || store.index.Images[0].Name != "two" {
+		t.Fatalf("expected only image two to remain, found %+v", store.index.Images)
+	}
+	if !shared.FileExists(store.blobPath(store.index.Images[0].Digest)) {
+		t.Error("expected blob referenced by image two to be kept")
+	}
+}
@@ -0,0 +1,286 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/ioprogress"
+
+	"github.com/bravetools/bravetools/shared"
+)
+
+// transferProgress returns a progress handler printing LXD transfer progress on a single terminal line
+func transferProgress(operation string) func(ioprogress.ProgressData) {
+	return func(progress ioprogress.ProgressData) {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s: %s", operation, progress.Text)
+	}
+}
+
+// endTransferProgress moves past the line used by a progress handler
+func endTransferProgress() {
+	fmt.Fprintln(os.Stderr)
+}
+
+// setImageAlias points an alias on an LXD image server at the provided fingerprint, creating it if needed
+func setImageAlias(lxdServer lxd.InstanceServer, alias string, fingerprint string) error {
+	existing, etag, err := lxdServer.GetImageAlias(alias)
+	if err != nil {
+		aliasPost := api.ImageAliasesPost{}
+		aliasPost.Name = alias
+		aliasPost.Target = fingerprint
+		return lxdServer.CreateImageAlias(aliasPost)
+	}
+
+	if existing.Target == fingerprint {
+		return nil
+	}
+
+	return lxdServer.UpdateImageAlias(alias, api.ImageAliasesEntryPut{Target: fingerprint, Description: existing.Description}, etag)
+}
+
+// uploadImage uploads a unified image archive to an LXD image server, reporting progress
+func uploadImage(lxdServer lxd.InstanceServer, imagePath string, properties map[string]string, operation string) (fingerprint string, err error) {
+	meta, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer meta.Close()
+
+	image := api.ImagesPost{}
+	image.Properties = properties
+
+	createArgs := &lxd.ImageCreateArgs{
+		MetaFile:        meta,
+		MetaName:        filepath.Base(imagePath),
+		ProgressHandler: transferProgress(operation),
+	}
+	image.Filename = createArgs.MetaName
+
+	op, err := lxdServer.CreateImage(image, createArgs)
+	endTransferProgress()
+	if err != nil {
+		return "", err
+	}
+
+	err = op.Wait()
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, ok := op.Get().Metadata["fingerprint"].(string)
+	if !ok {
+		return "", errors.New("LXD did not return the fingerprint of the uploaded image")
+	}
+	return fingerprint, nil
+}
+
+// downloadImage downloads a unified image archive from an LXD image server into a file, reporting progress
+func downloadImage(lxdServer lxd.ImageServer, fingerprint string, destPath string, operation string) error {
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	rootfs, err := ioutil.TempFile(filepath.Dir(destPath), "rootfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(rootfs.Name())
+	defer rootfs.Close()
+
+	resp, err := lxdServer.GetImageFile(fingerprint, lxd.ImageFileRequest{
+		MetaFile:        dest,
+		RootfsFile:      rootfs,
+		ProgressHandler: transferProgress(operation),
+	})
+	endTransferProgress()
+	if err != nil {
+		return err
+	}
+
+	// Bravetools images are unified archives - split images (metadata and rootfs) are only usable as build bases
+	if resp.RootfsSize > 0 {
+		return fmt.Errorf("image %s is a split image - use it as a base image in a Bravefile instead", fingerprint)
+	}
+
+	return dest.Truncate(resp.MetaSize)
+}
+
+// PushImage copies a local image to the LXD image store of a remote. The image is aliased by its full name
+// and the upload is skipped if an image with the same fingerprint already exists on the remote.
+func (bh *BraveHost) PushImage(imageName string, remoteName string) error {
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+
+	resolvedImage, imagePath, err := resolveLocalImage(image)
+	if err != nil {
+		return err
+	}
+
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return fmt.Errorf("failed to load remote %q: %s", remoteName, err)
+	}
+	if remote.Protocol == RegistryProtocol {
+		return pushRegistryImage(remote, resolvedImage, imagePath)
+	}
+	if remote.Public || remote.Protocol == "simplestreams" {
+		return fmt.Errorf("remote %q is a read-only image server", remoteName)
+	}
+
+	lxdServer, err := GetLXDInstanceServer(remote)
+	if err != nil {
+		return err
+	}
+
+	alias := resolvedImage.String()
+	fingerprint := strings.TrimPrefix(resolvedImage.hashString, digestPrefix)
+
+	if _, _, err := lxdServer.GetImage(fingerprint); err == nil {
+		fmt.Printf("Image %q already exists on remote %q - skipping transfer\n", alias, remoteName)
+	} else {
+		uploaded, err := uploadImage(lxdServer, imagePath, map[string]string{"description": alias}, shared.Info(fmt.Sprintf("Pushing %q to %q", alias, remoteName)))
+		if err != nil {
+			return fmt.Errorf("failed to push image %q to remote %q: %s", alias, remoteName, err)
+		}
+		if uploaded != fingerprint {
+			return fmt.Errorf("remote %q reported fingerprint %s for image %q with digest %s", remoteName, uploaded, alias, resolvedImage.hashString)
+		}
+	}
+
+	err = setImageAlias(lxdServer, alias, fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to alias image %q on remote %q: %s", alias, remoteName, err)
+	}
+
+	fmt.Printf("Pushed image %q to remote %q\n", alias, remoteName)
+	return nil
+}
+
+// PullImage copies an image from the LXD image store of a remote into the local image store without building.
+// The download is skipped if an image with the same fingerprint already exists locally.
+func (bh *BraveHost) PullImage(imageName string) error {
+	remoteName, _ := ParseRemoteName(imageName)
+	if !strings.Contains(imageName, ":") {
+		return fmt.Errorf("no remote provided in %q - expected REMOTE:IMAGE", imageName)
+	}
+
+	image, err := ParseImageString(imageName)
+	if err != nil {
+		return err
+	}
+	if image.Version == "" {
+		image.Version = defaultImageVersion
+	}
+	if image.Architecture == "" {
+		image.Architecture, err = localServerArch()
+		if err != nil {
+			return err
+		}
+	}
+
+	if isVersionConstraint(image.Version) {
+		resolved, err := resolveRemoteImage(remoteName, image)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Resolved image %q to %q\n", image, resolved)
+		image = resolved
+	}
+
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return fmt.Errorf("failed to load remote %q: %s", remoteName, err)
+	}
+	if remote.Protocol == RegistryProtocol {
+		return pullRegistryImage(remote, image)
+	}
+
+	lxdServer, err := GetLXDImageSever(remote)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := GetFingerprintByAlias(lxdServer, image.String(), image.Architecture)
+	if err != nil {
+		return fmt.Errorf("image %q not found on remote %q: %s", image, remoteName, err)
+	}
+	return pullIntoStore(image, digestPrefix+fingerprint, remoteName, func(destPath string) error {
+		return downloadImage(lxdServer, fingerprint, destPath, shared.Info(fmt.Sprintf("Pulling %q from %q", image, remoteName)))
+	})
+}
+
+// localServerArch returns the architecture of the LXD server of the local remote, which runs the units images are pulled for
+func localServerArch() (string, error) {
+	remote, err := LoadRemoteSettings(shared.BravetoolsRemote)
+	if err != nil {
+		return "", err
+	}
+	lxdServer, err := GetLXDInstanceServer(remote)
+	if err != nil {
+		return "", err
+	}
+	arch, err := GetLXDServerArch(lxdServer)
+	if err != nil {
+		return "", errors.New("failed to determine LXD server CPU architecture")
+	}
+	return normalizeArchitecture(arch), nil
+}
+
+// pullIntoStore adds an image with a known digest from a remote to the local image store, calling download
+// to fetch the archive unless the store already has a blob with that digest
+func pullIntoStore(image BravetoolsImage, digest string, remoteName string, download func(destPath string) error) error {
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	if record, err := store.exact(image); err == nil {
+		if record.Digest == digest {
+			fmt.Printf("Image %q is up to date\n", image)
+			return nil
+		}
+		return &ImageExistsError{Name: image.String()}
+	}
+
+	if store.references(digest) > 0 {
+		_, err = store.link(image, digest)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Image %q already exists locally with fingerprint %s - skipping transfer\n", image, shortDigest(digest))
+		return nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "bravetools-pull-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imagePath := filepath.Join(tmpDir, image.ToBasename())
+	err = download(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %q from remote %q: %s", image, remoteName, err)
+	}
+
+	record, err := store.add(imagePath, image, nil)
+	if err != nil {
+		return err
+	}
+	if record.Digest != digest {
+		return shared.CollectErrors(fmt.Errorf("downloaded image %q has digest %s but remote reported %s", image, record.Digest, digest), store.remove(record))
+	}
+
+	fmt.Printf("Pulled image %q from remote %q\n", image, remoteName)
+	return nil
+}
@@ -0,0 +1,146 @@
+package platform
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Results of verifying an image archive
+const (
+	verifyOK        = "OK"
+	verifyCorrupted = "CORRUPTED"
+	verifyMissing   = "MISSING"
+)
+
+// checkImageArchive reads an image archive to the end in a single pass, returning the digest of the file.
+// Truncated or corrupted archives, and archives without metadata.yaml, fail to read.
+func checkImageArchive(imagePath string) (digest string, err error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	content, closer, err := decompressReader(io.TeeReader(f, hasher))
+	if err != nil {
+		return "", err
+	}
+
+	hasMetadata := false
+	err = walkTar(tar.NewReader(content), func(name string, hdr *tar.Header, tr *tar.Reader) error {
+		hasMetadata = hasMetadata || name == "metadata.yaml"
+		return nil
+	})
+	if err == nil {
+		// Drain the compressed stream so its checksum is verified
+		_, err = io.Copy(ioutil.Discard, content)
+	}
+	closeArchive(closer, &err)
+	if err != nil {
+		return "", fmt.Errorf("archive is truncated or corrupted: %s", err)
+	}
+	if !hasMetadata {
+		return "", errors.New("archive has no metadata.yaml")
+	}
+
+	// The decompressor has exited, so hash anything left after the compressed stream
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+
+	return digestPrefix + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyImageFile checks that the archive of an image is readable and still matches the digest recorded for it
+func verifyImageFile(image BravetoolsImage, imagePath string) error {
+	digest, err := checkImageArchive(imagePath)
+	if err != nil {
+		return fmt.Errorf("image %q is corrupted: %s", image, err)
+	}
+	if digest != image.hashString {
+		return fmt.Errorf("image %q is corrupted: digest is %s, expected %s", image, digest, image.hashString)
+	}
+	return nil
+}
+
+// VerifyImages recomputes the digests of images in the local image store and reads their archives in full,
+// printing the result for each image. An error is returned if any image fails verification.
+func (bh *BraveHost) VerifyImages(names []string, all bool) error {
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+
+	var records []imageRecord
+	if all {
+		records = append(records, store.index.Images...)
+	}
+	for _, name := range names {
+		image, err := ParseImageString(name)
+		if err != nil {
+			return err
+		}
+		record, err := store.match(image)
+		if err != nil {
+			return err
+		}
+		records = append(records, *record)
+	}
+	if len(records) == 0 {
+		return errors.New("no images to verify - provide an image name or --all")
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].image().String() < records[j].image().String()
+	})
+
+	// Images sharing a blob are verified once
+	results := map[string]string{}
+	details := map[string]string{}
+	for _, record := range records {
+		if _, ok := results[record.Digest]; ok {
+			continue
+		}
+
+		blob := store.blobPath(record.Digest)
+		if _, err := os.Stat(blob); err != nil {
+			results[record.Digest] = verifyMissing
+			continue
+		}
+
+		digest, err := checkImageArchive(blob)
+		switch {
+		case err != nil:
+			results[record.Digest] = verifyCorrupted
+			details[record.Digest] = err.Error()
+		case digest != record.Digest:
+			results[record.Digest] = verifyCorrupted
+			details[record.Digest] = "digest is " + shortDigest(digest)
+		default:
+			results[record.Digest] = verifyOK
+		}
+	}
+
+	failed := 0
+	table := newPlainTable(os.Stdout, []string{"Image", "Digest", "Status", ""})
+	for _, record := range records {
+		result := results[record.Digest]
+		if result != verifyOK {
+			failed++
+		}
+		table.Append([]string{record.image().String(), shortDigest(record.Digest), result, details[record.Digest]})
+	}
+	table.Render()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed verification - remove them with `brave remove -i` and rebuild, import or pull them again", failed, len(records))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package platform
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckImageArchive(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(writeTestTar(t, []testTarEntry{
+		{"metadata.yaml", tar.TypeReg, "architecture: x86_64\n"},
+		{"rootfs/", tar.TypeDir, ""},
+		{"rootfs/etc/app.conf", tar.TypeReg, strings.Repeat("config\n", 1000)},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	content := buf.Bytes()
+
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.tar.gz")
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	flipped := filepath.Join(dir, "flipped.tar.gz")
+	writeTestFile(t, valid, string(content))
+	writeTestFile(t, truncated, string(content[:len(content)/2]))
+	corrupt := append([]byte{}, content...)
+	corrupt[len(corrupt)-12] ^= 0xff
+	writeTestFile(t, flipped, string(corrupt))
+
+	digest, err := checkImageArchive(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := BravetoolsImage{Name: "app", Version: "1.0", hashString: digest}
+	if err = verifyImageFile(image, valid); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, archive := range []string{truncated, flipped} {
+		if err = verifyImageFile(image, archive); err == nil {
+			t.Errorf("expected %s to fail verification", filepath.Base(archive))
+		}
+	}
+
+	noMetadata := filepath.Join(dir, "rootfs.tar")
+	writeTestFile(t, noMetadata, string(writeTestTar(t, []testTarEntry{{"rootfs/", tar.TypeDir, ""}})))
+	if _, err = checkImageArchive(noMetadata); err == nil {
+		t.Error("expected an archive without metadata.yaml to be rejected")
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	newTestImageStore(t)
+	archive := filepath.Join(t.TempDir(), "app.tar")
+	writeTestFile(t, archive, string(writeTestTar(t, []testTarEntry{{"metadata.yaml", tar.TypeReg, "architecture: x86_64\n"}})))
+
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.add(archive, BravetoolsImage{Name: "app", Version: "1.0", Architecture: "x86_64"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bh := &BraveHost{}
+	if err = bh.VerifyImages(nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(store.blobPath(record.Digest), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = bh.VerifyImages([]string{"app/1.0"}, false); err == nil {
+		t.Fatal("expected a corrupted blob to fail verification")
+	}
+}
@@ -0,0 +1,393 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	lxd "github.com/lxc/lxd/client"
+)
+
+// versionLatest resolves to the newest version of an image unless an image is explicitly tagged "latest"
+const versionLatest = "latest"
+
+// semanticVersion is a version of the form [v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD]. Missing fields are zero.
+type semanticVersion struct {
+	major      int
+	minor      int
+	patch      int
+	prerelease string
+	// fields counts the numeric fields given, distinguishing ^1 from ^1.0.0
+	fields int
+}
+
+// parseSemanticVersion parses a semantic version, reporting false for versions that do not follow the scheme
+func parseSemanticVersion(version string) (v semanticVersion, ok bool) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.Index(version, "-"); i >= 0 {
+		v.prerelease = version[i+1:]
+		version = version[:i]
+		if v.prerelease == "" {
+			return v, false
+		}
+	}
+
+	fields := strings.Split(version, ".")
+	if len(fields) > 3 {
+		return v, false
+	}
+	numbers := []*int{&v.major, &v.minor, &v.patch}
+	for i, field := range fields {
+		// Signs are split off above, so a field is either empty, as in 1..2, or must be digits only
+		if field == "" {
+			return v, false
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return v, false
+		}
+		*numbers[i] = n
+	}
+	v.fields = len(fields)
+
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v sorts before, equal to or after other by semantic version precedence
+func (v semanticVersion) compare(other semanticVersion) int {
+	for _, pair := range [][2]int{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		switch {
+		case pair[0] < pair[1]:
+			return -1
+		case pair[0] > pair[1]:
+			return 1
+		}
+	}
+	return comparePrerelease(v.prerelease, other.prerelease)
+}
+
+// comparePrerelease orders pre-release identifiers - a release sorts after any of its pre-releases
+func comparePrerelease(a string, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	aFields := strings.Split(a, ".")
+	bFields := strings.Split(b, ".")
+	for i := 0; i < len(aFields) && i < len(bFields); i++ {
+		if aFields[i] == bFields[i] {
+			continue
+		}
+		aNum, aErr := strconv.Atoi(aFields[i])
+		bNum, bErr := strconv.Atoi(bFields[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			// Numeric identifiers sort before alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		case aFields[i] < bFields[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(aFields) < len(bFields):
+		return -1
+	case len(aFields) > len(bFields):
+		return 1
+	}
+	return 0
+}
+
+// isVersionRange reports whether a version is a caret or tilde range such as ^1.4 or ~2.0
+func isVersionRange(version string) bool {
+	return strings.HasPrefix(version, "^") || strings.HasPrefix(version, "~")
+}
+
+// isVersionConstraint reports whether a version is resolved against the available versions of an image
+func isVersionConstraint(version string) bool {
+	return version == versionLatest || isVersionRange(version)
+}
+
+// checkConcreteVersion rejects version ranges as the version of a new image
+func checkConcreteVersion(image BravetoolsImage) error {
+	if isVersionRange(image.Version) {
+		return fmt.Errorf("version %q of image %q is a version range - images must be named with a concrete version", image.Version, image.Name)
+	}
+	return nil
+}
+
+// versionConstraint selects versions within a caret or tilde range, or any version for latest
+type versionConstraint struct {
+	latest bool
+	lower  semanticVersion
+	upper  semanticVersion
+}
+
+// parseVersionConstraint parses latest, ^MAJOR[.MINOR[.PATCH]] or ~MAJOR[.MINOR[.PATCH]].
+// Caret ranges allow changes that do not modify the left-most non-zero field, tilde ranges allow patch changes
+// if a minor version is given and minor changes otherwise.
+func parseVersionConstraint(version string) (constraint versionConstraint, err error) {
+	if version == versionLatest {
+		return versionConstraint{latest: true}, nil
+	}
+	if !isVersionRange(version) {
+		return constraint, fmt.Errorf("%q is not a version constraint", version)
+	}
+
+	lower, ok := parseSemanticVersion(version[1:])
+	if !ok || lower.prerelease != "" || strings.HasPrefix(version[1:], "v") {
+		return constraint, fmt.Errorf("invalid version constraint %q - expected latest, ^MAJOR[.MINOR[.PATCH]] or ~MAJOR[.MINOR[.PATCH]]", version)
+	}
+
+	upper := semanticVersion{}
+	switch {
+	case version[0] == '~' && lower.fields == 1:
+		upper.major = lower.major + 1
+	case version[0] == '~':
+		upper.major, upper.minor = lower.major, lower.minor+1
+	case lower.major > 0 || lower.fields == 1:
+		upper.major = lower.major + 1
+	case lower.minor > 0 || lower.fields == 2:
+		upper.minor = lower.minor + 1
+	default:
+		upper.patch = lower.patch + 1
+	}
+
+	return versionConstraint{lower: lower, upper: upper}, nil
+}
+
+// allows reports whether a semantic version is within the range. Pre-releases are never selected by a range.
+func (c versionConstraint) allows(v semanticVersion) bool {
+	if c.latest {
+		return true
+	}
+	return v.prerelease == "" && v.compare(c.lower) >= 0 && v.compare(c.upper) < 0
+}
+
+// versionCandidate is an available version of an image and the time its newest build was created
+type versionCandidate struct {
+	version string
+	created time.Time
+}
+
+// selectVersion resolves the version constraint of an image against the available versions.
+// Ranges select the highest matching semantic version. Latest selects the highest semantic version, preferring releases
+// over pre-releases. Versions that are not semantic versions are ordered by build date and only selected by latest
+// when no semantic version is available.
+func selectVersion(image BravetoolsImage, candidates []versionCandidate) (string, error) {
+	constraint, err := parseVersionConstraint(image.Version)
+	if err != nil {
+		return "", err
+	}
+
+	// Merge candidates sharing a version, such as builds for several architectures. An image tagged latest is used as is.
+	newest := map[string]time.Time{}
+	for _, candidate := range candidates {
+		if constraint.latest && candidate.version == versionLatest {
+			return versionLatest, nil
+		}
+		if created, ok := newest[candidate.version]; !ok || candidate.created.After(created) {
+			newest[candidate.version] = candidate.created
+		}
+	}
+
+	type ranked struct {
+		versionCandidate
+		semver   semanticVersion
+		isSemver bool
+	}
+	var matches []ranked
+	for version, created := range newest {
+		semver, isSemver := parseSemanticVersion(version)
+		if isSemver && !constraint.allows(semver) || !isSemver && !constraint.latest {
+			continue
+		}
+		matches = append(matches, ranked{versionCandidate{version, created}, semver, isSemver})
+	}
+	if len(matches) == 0 {
+		if len(newest) == 0 {
+			return "", fmt.Errorf("no versions of image %q found", image.Name)
+		}
+		return "", fmt.Errorf("no version of image %q matches %q", image.Name, image.Version)
+	}
+
+	// Rank releases above pre-releases above other versions, then by version and build date
+	group := func(r ranked) int {
+		switch {
+		case r.isSemver && r.semver.prerelease == "":
+			return 0
+		case r.isSemver:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if gi, gj := group(matches[i]), group(matches[j]); gi != gj {
+			return gi < gj
+		}
+		if matches[i].isSemver {
+			if c := matches[i].semver.compare(matches[j].semver); c != 0 {
+				return c > 0
+			}
+		}
+		if !matches[i].created.Equal(matches[j].created) {
+			return matches[i].created.After(matches[j].created)
+		}
+		return matches[i].version > matches[j].version
+	})
+
+	return matches[0].version, nil
+}
+
+// resolveRemoteVersion resolves the version constraint of an image against the image aliases of an LXD image server.
+// Aliases are expected to follow the bravetools image schema NAME/VERSION[/ARCH].
+func resolveRemoteVersion(server lxd.ImageServer, image BravetoolsImage) (BravetoolsImage, error) {
+	aliases, err := server.GetImageAliases()
+	if err != nil {
+		return image, err
+	}
+
+	var candidates []versionCandidate
+	created := map[string]time.Time{}
+	for _, alias := range aliases {
+		aliasImage, err := ParseImageString(alias.Name)
+		if err != nil || aliasImage.Name != image.Name || aliasImage.Version == "" || isVersionRange(aliasImage.Version) {
+			continue
+		}
+		if image.Architecture != "" && aliasImage.Architecture != "" && !sameArchitecture(image.Architecture, aliasImage.Architecture) {
+			continue
+		}
+
+		// Build dates are only needed to order versions that are not semantic versions
+		candidate := versionCandidate{version: aliasImage.Version}
+		if _, isSemver := parseSemanticVersion(aliasImage.Version); !isSemver {
+			if _, ok := created[alias.Target]; !ok {
+				if remoteImage, _, err := server.GetImage(alias.Target); err == nil {
+					created[alias.Target] = remoteImage.CreatedAt
+				}
+			}
+			candidate.created = created[alias.Target]
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	version, err := selectVersion(image, candidates)
+	if err != nil {
+		return image, err
+	}
+	image.Version = version
+	return image, nil
+}
+
+// resolveRemoteImage resolves the version constraint of an image against the images of a remote
+func resolveRemoteImage(remoteName string, image BravetoolsImage) (BravetoolsImage, error) {
+	remote, err := LoadRemoteSettings(remoteName)
+	if err != nil {
+		return image, fmt.Errorf("failed to load remote %q: %s", remoteName, err)
+	}
+
+	if remote.Protocol == RegistryProtocol {
+		client, err := newRegistryClient(remote)
+		if err != nil {
+			return image, err
+		}
+		records, err := client.images(image.Name)
+		if err != nil {
+			return image, err
+		}
+
+		var candidates []versionCandidate
+		for _, record := range records {
+			if record.Name != image.Name {
+				continue
+			}
+			if image.Architecture != "" && record.Architecture != "" && !sameArchitecture(image.Architecture, record.Architecture) {
+				continue
+			}
+			candidates = append(candidates, versionCandidate{version: record.Version, created: record.Created})
+		}
+
+		version, err := selectVersion(image, candidates)
+		if err != nil {
+			return image, fmt.Errorf("%s on remote %q", err, remoteName)
+		}
+		image.Version = version
+		return image, nil
+	}
+
+	server, err := connectImageRemote(remote)
+	if err != nil {
+		return image, err
+	}
+	resolved, err := resolveRemoteVersion(server, image)
+	if err != nil {
+		return image, fmt.Errorf("%s on remote %q", err, remoteName)
+	}
+	return resolved, nil
+}
+
+// resolveBaseImageVersion pins a version constraint in the base image of a Bravefile to the version it resolves to
+// at the base image location. Base images without a constraint are returned unchanged.
+func resolveBaseImageVersion(imageString string, location string, architecture string) (string, error) {
+	remoteName, name := ParseRemoteName(imageString)
+
+	image, err := ParseImageString(name)
+	if err != nil || !isVersionConstraint(image.Version) {
+		return imageString, nil
+	}
+	if image.Architecture == "" {
+		image.Architecture = architecture
+	}
+
+	var resolved BravetoolsImage
+	switch location {
+	case "local":
+		resolved, _, err = resolveLocalImage(image)
+	case "public":
+		var server lxd.ImageServer
+		server, err = GetSimplestreamsLXDSever(publicImageServerURL(), nil)
+		if err != nil {
+			return "", err
+		}
+		resolved, err = resolveRemoteVersion(server, image)
+	case "private":
+		resolved, err = resolveRemoteImage(remoteName, image)
+	default:
+		return "", errors.New("version constraints are not supported for base images from " + location)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the architecture of the reference as written - only the version is pinned
+	pinned := BravetoolsImage{Name: image.Name, Version: resolved.Version}
+	if strings.Count(name, "/") >= 2 {
+		pinned.Architecture = image.Architecture
+	}
+	fmt.Printf("Resolved base image %q to %q\n", name, pinned)
+
+	if strings.Contains(imageString, ":") {
+		return remoteName + ":" + pinned.String(), nil
+	}
+	return pinned.String(), nil
+}
@@ -0,0 +1,132 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionConstraints(t *testing.T) {
+	candidates := []versionCandidate{
+		{version: "1.3.9"},
+		{version: "1.4.0"},
+		{version: "1.4.3"},
+		{version: "1.5.0-rc.1"},
+		{version: "1.9"},
+		{version: "2.0.1"},
+		{version: "2.1.0"},
+		{version: "0.2.5"},
+		{version: "0.2.7"},
+		{version: "0.3.0"},
+		{version: "stable", created: time.Now()},
+	}
+
+	cases := map[string]string{
+		"latest": "2.1.0",
+		"^1.4":   "1.9",
+		"^1.4.0": "1.9",
+		"~1.4":   "1.4.3",
+		"~2.0":   "2.0.1",
+		"~2":     "2.1.0",
+		"^2":     "2.1.0",
+		"^0.2":   "0.2.7",
+		"^0.2.5": "0.2.7",
+		"~1.3.2": "1.3.9",
+	}
+	for constraint, expected := range cases {
+		version, err := selectVersion(BravetoolsImage{Name: "app", Version: constraint}, candidates)
+		if err != nil {
+			t.Fatalf("%s: %s", constraint, err)
+		}
+		if version != expected {
+			t.Errorf("%s resolved to %s, expected %s", constraint, version, expected)
+		}
+	}
+
+	for _, constraint := range []string{"^3", "~1.6"} {
+		if _, err := selectVersion(BravetoolsImage{Name: "app", Version: constraint}, candidates); err == nil {
+			t.Errorf("expected no match for %s", constraint)
+		}
+	}
+	for _, constraint := range []string{"^", "~x.1", "^1.2.3.4", "^1.0-beta", "^1..2", "~1.", "^.1"} {
+		if _, err := parseVersionConstraint(constraint); err == nil {
+			t.Errorf("expected %q to be rejected", constraint)
+		}
+	}
+}
+
+func TestLatestVersionByBuildDate(t *testing.T) {
+	now := time.Now()
+	candidates := []versionCandidate{
+		{version: "untagged", created: now.Add(-time.Hour)},
+		{version: "nightly", created: now},
+		{version: "blue", created: now.Add(-2 * time.Hour)},
+	}
+
+	version, err := selectVersion(BravetoolsImage{Name: "app", Version: versionLatest}, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "nightly" {
+		t.Errorf("expected latest to resolve to the newest build, got %s", version)
+	}
+
+	// Pre-releases are only selected by latest if no release exists
+	candidates = append(candidates, versionCandidate{version: "1.0.0-beta"})
+	if version, _ = selectVersion(BravetoolsImage{Name: "app", Version: versionLatest}, candidates); version != "1.0.0-beta" {
+		t.Errorf("expected latest to prefer semantic versions, got %s", version)
+	}
+	candidates = append(candidates, versionCandidate{version: "0.9.0"})
+	if version, _ = selectVersion(BravetoolsImage{Name: "app", Version: versionLatest}, candidates); version != "0.9.0" {
+		t.Errorf("expected latest to prefer releases, got %s", version)
+	}
+}
+
+func TestImageStoreVersionResolution(t *testing.T) {
+	newTestImageStore(t)
+	store, err := openImageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := t.TempDir() + "/image.tar.gz"
+	writeTestFile(t, archive, "image")
+	for _, version := range []string{"1.4.1", "1.4.3", "1.5.0", "2.0.0"} {
+		if _, err = store.add(archive, BravetoolsImage{Name: "app", Version: version, Architecture: "x86_64"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	image, err := ParseImageString("app/~1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, _, err := resolveLocalImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Version != "1.4.3" {
+		t.Errorf("expected app/~1.4 to resolve to 1.4.3, got %s", resolved)
+	}
+
+	// Architecture aliases select the same images
+	if resolved, _, err = resolveLocalImage(BravetoolsImage{Name: "app", Version: "~1.4", Architecture: "amd64"}); err != nil || resolved.String() != "app/1.4.3/x86_64" {
+		t.Errorf("expected app/~1.4/amd64 to resolve to app/1.4.3/x86_64, got %s: %v", resolved, err)
+	}
+
+	if resolved, _, err = resolveLocalImage(BravetoolsImage{Name: "app", Version: versionLatest}); err != nil || resolved.Version != "2.0.0" {
+		t.Errorf("expected app/latest to resolve to 2.0.0, got %s: %v", resolved, err)
+	}
+
+	// An image tagged latest takes precedence over resolution
+	if _, err = store.tag(BravetoolsImage{Name: "app", Version: "1.5.0"}, BravetoolsImage{Name: "app", Version: versionLatest}); err != nil {
+		t.Fatal(err)
+	}
+	if resolved, _, err = resolveLocalImage(BravetoolsImage{Name: "app", Version: versionLatest}); err != nil || resolved.Version != versionLatest {
+		t.Errorf("expected the latest tag to be used, got %s: %v", resolved, err)
+	}
+
+	// Version ranges cannot name images
+	if _, err = store.tag(BravetoolsImage{Name: "app", Version: "2.0.0"}, BravetoolsImage{Name: "app", Version: "^2"}); err == nil {
+		t.Error("expected tagging with a version range to fail")
+	}
+}
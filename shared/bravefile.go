@@ -41,17 +41,19 @@ type CopyCommand struct {
 
 // Service defines command to install app
 type Service struct {
-	Name       string     `yaml:"name,omitempty"`
-	Image      string     `yaml:"image,omitempty"`
-	Version    string     `yaml:"version,omitempty"`
-	Profile    string     `yaml:"profile,omitempty"`
-	Storage    string     `yaml:"storage,omitempty"`
-	Network    string     `yaml:"network,omitempty"`
-	Docker     string     `yaml:"docker,omitempty"`
-	IP         string     `yaml:"ip"`
-	Ports      []string   `yaml:"ports"`
-	Resources  Resources  `yaml:"resources"`
-	Postdeploy Postdeploy `yaml:"postdeploy,omitempty"`
+	Name        string            `yaml:"name,omitempty"`
+	Image       string            `yaml:"image,omitempty"`
+	Version     string            `yaml:"version,omitempty"`
+	Profile     string            `yaml:"profile,omitempty"`
+	Storage     string            `yaml:"storage,omitempty"`
+	Network     string            `yaml:"network,omitempty"`
+	Docker      string            `yaml:"docker,omitempty"`
+	IP          string            `yaml:"ip"`
+	Ports       []string          `yaml:"ports"`
+	Resources   Resources         `yaml:"resources"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Logs        []string          `yaml:"logs,omitempty"`
+	Postdeploy  Postdeploy        `yaml:"postdeploy,omitempty"`
 }
 
 // Postdeploy defines operations to perform after service deployment finish
@@ -213,6 +215,17 @@ func (s *Service) Merge(service *Service) {
 	if s.Resources.RAM == "" {
 		s.Resources.RAM = service.Resources.RAM
 	}
+	for key, value := range service.Environment {
+		if _, exists := s.Environment[key]; !exists {
+			if s.Environment == nil {
+				s.Environment = make(map[string]string, len(service.Environment))
+			}
+			s.Environment[key] = value
+		}
+	}
+	if len(s.Logs) == 0 {
+		s.Logs = append(s.Logs, service.Logs...)
+	}
 	if len(s.Postdeploy.Copy) == 0 {
 		s.Postdeploy.Copy = append(s.Postdeploy.Copy, service.Postdeploy.Copy...)
 	}
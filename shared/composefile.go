@@ -5,29 +5,35 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	ComposefileName  = "brave-compose.yaml"
-	ComposefileAlias = "brave-compose.yml"
+	ComposefileName          = "brave-compose.yaml"
+	ComposefileAlias         = "brave-compose.yml"
+	ComposefileOverrideName  = "brave-compose.override.yaml"
+	ComposefileOverrideAlias = "brave-compose.override.yml"
 )
 
 // ComposeService defines a service
 type ComposeService struct {
 	Service        `yaml:",inline"`
-	BravefileBuild *Bravefile
-	Bravefile      string   `yaml:"bravefile,omitempty"`
-	Build          bool     `yaml:"build,omitempty"`
-	Base           bool     `yaml:"base,omitempty"`
-	Context        string   `yaml:"context,omitempty"`
-	Depends        []string `yaml:"depends_on,omitempty"`
+	BravefileBuild *Bravefile `yaml:"-"`
+	Bravefile      string     `yaml:"bravefile,omitempty"`
+	Build          bool       `yaml:"build,omitempty"`
+	Base           bool       `yaml:"base,omitempty"`
+	Context        string     `yaml:"context,omitempty"`
+	Depends        []string   `yaml:"depends_on,omitempty"`
+	Aliases        []string   `yaml:"aliases,omitempty"`
+	Remote         string     `yaml:"remote,omitempty"`
 }
 
 // A ComposeFile maps service names to services
 type ComposeFile struct {
-	Path     string
+	Path     string                     `yaml:"-"`
 	Services map[string]*ComposeService `yaml:"services"`
 }
 
@@ -36,20 +42,24 @@ func NewComposeFile() *ComposeFile {
 	return &ComposeFile{}
 }
 
-// Load reads a compose file from disk and loads its settings into the composeFile struct
-func (composeFile *ComposeFile) Load(file string) error {
-	buf, err := ReadFile(file)
-	if err != nil {
-		return err
+// Load reads one or more compose files from disk and loads their settings into the composeFile struct.
+// Files are layered in the order provided, with settings in later files overriding those in earlier ones.
+// Relative paths in all files are resolved against the directory of the first file.
+func (composeFile *ComposeFile) Load(files ...string) error {
+	if len(files) == 0 {
+		return errors.New("no compose file provided")
 	}
 
-	err = yaml.Unmarshal(buf.Bytes(), &composeFile)
-	if err != nil {
-		return err
+	for _, file := range files {
+		layer, err := readComposeFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read compose file %q: %s", file, err)
+		}
+		composeFile.Merge(layer)
 	}
 
 	// Record composefile path (later used for deploy context)
-	composeFile.Path = file
+	composeFile.Path = files[0]
 
 	// Check for empty compose file
 	if len(composeFile.Services) == 0 {
@@ -98,6 +108,104 @@ func (composeFile *ComposeFile) Load(file string) error {
 	return nil
 }
 
+// readComposeFile reads a single compose file from disk, interpolating environment variables before parsing
+func readComposeFile(file string) (*ComposeFile, error) {
+	buf, err := ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	content, missing := interpolate(buf.String())
+	if len(missing) > 0 {
+		fmt.Println(Warn(fmt.Sprintf("Warning: environment variables referenced in %s are not set and default to an empty string: %s",
+			file, strings.Join(missing, ", "))))
+	}
+
+	layer := NewComposeFile()
+	err = yaml.Unmarshal([]byte(content), layer)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer, nil
+}
+
+// Merge layers the services of override on top of composeFile.
+// Services present in both are deep-merged with the values in override taking priority, new services are added as-is.
+func (composeFile *ComposeFile) Merge(override *ComposeFile) {
+	if composeFile.Services == nil {
+		composeFile.Services = make(map[string]*ComposeService, len(override.Services))
+	}
+
+	for serviceName, service := range override.Services {
+		// A service key with an empty body is valid YAML - treat it as an empty service
+		if service == nil {
+			service = &ComposeService{}
+		}
+		if base, exists := composeFile.Services[serviceName]; exists && base != nil {
+			service.Merge(base)
+		}
+		composeFile.Services[serviceName] = service
+	}
+}
+
+// Merges two ComposeService structs, prioritizing the values present in first struct.
+// Service fields follow the rules of Service.Merge - scalar fields are only taken from the second struct if empty in the first,
+// while lists such as ports, postdeploy steps, dependencies and aliases are replaced as a whole rather than appended to.
+// Environment variables are merged key by key.
+// Boolean flags cannot be unset by an override, so build and base are enabled if set in either struct.
+func (s *ComposeService) Merge(service *ComposeService) {
+	s.Service.Merge(&service.Service)
+
+	if s.Bravefile == "" {
+		s.Bravefile = service.Bravefile
+	}
+	if s.Context == "" {
+		s.Context = service.Context
+	}
+	if s.Remote == "" {
+		s.Remote = service.Remote
+	}
+	if !s.Build {
+		s.Build = service.Build
+	}
+	if !s.Base {
+		s.Base = service.Base
+	}
+	if len(s.Depends) == 0 {
+		s.Depends = append(s.Depends, service.Depends...)
+	}
+	if len(s.Aliases) == 0 {
+		s.Aliases = append(s.Aliases, service.Aliases...)
+	}
+}
+
+// interpolationPattern matches ${VAR} and ${VAR:-default} references, and $${ escapes writing a literal ${
+var interpolationPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate substitutes environment variables referenced as ${VAR} or ${VAR:-default} in a compose file.
+// Bare $VAR references and shell parameters such as $1 or $? are left untouched so shell commands keep working,
+// and $${ writes a literal ${. Unset variables without a default become empty and are returned in missing.
+func interpolate(content string) (result string, missing []string) {
+	result = interpolationPattern.ReplaceAllStringFunc(content, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		groups := interpolationPattern.FindStringSubmatch(match)
+		value, set := os.LookupEnv(groups[1])
+		if groups[2] != "" && (!set || value == "") {
+			return groups[3]
+		}
+		if !set {
+			missing = append(missing, groups[1])
+		}
+		return value
+	})
+
+	return result, missing
+}
+
 // TopologicalOrdering returns a string array of service names that are ordered
 // so that each service comes after the services it depends on.
 // If a valid ordering cannot be found due to cycles in the graph an error will be returned.
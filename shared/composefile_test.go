@@ -1,6 +1,9 @@
 package shared
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
 
 //
 //                  ┌───────┐
@@ -265,3 +268,127 @@ func TestTopologicalOrdering_noServices(t *testing.T) {
 		t.Errorf("expected no errors in composeFile with no services")
 	}
 }
+
+func TestComposeFileMerge_override(t *testing.T) {
+	composeFile := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": {
+				Service: Service{
+					Image:     "api/1.0",
+					IP:        "10.0.0.10",
+					Ports:     []string{"80:8080"},
+					Resources: Resources{CPU: "1", RAM: "1GB"},
+				},
+				Depends: []string{"db"},
+			},
+			"db": {Service: Service{Image: "db/1.0"}},
+		},
+	}
+
+	override := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": {
+				Service: Service{
+					IP:        "10.0.0.20",
+					Resources: Resources{RAM: "4GB"},
+				},
+			},
+			"cache": {Service: Service{Image: "cache/1.0"}},
+		},
+	}
+
+	composeFile.Merge(&override)
+
+	if len(composeFile.Services) != 3 {
+		t.Fatalf("expected 3 services after merge, found %d", len(composeFile.Services))
+	}
+
+	api := composeFile.Services["api"]
+	if api.IP != "10.0.0.20" {
+		t.Errorf("expected override IP %q, found %q", "10.0.0.20", api.IP)
+	}
+	if api.Image != "api/1.0" {
+		t.Errorf("expected base image %q to be kept, found %q", "api/1.0", api.Image)
+	}
+	if api.Resources.RAM != "4GB" || api.Resources.CPU != "1" {
+		t.Errorf("expected resources to be merged field by field, found %+v", api.Resources)
+	}
+	if len(api.Ports) != 1 || len(api.Depends) != 1 {
+		t.Errorf("expected base ports and dependencies to be kept, found %v and %v", api.Ports, api.Depends)
+	}
+}
+
+func TestComposeFileMerge_replaceLists(t *testing.T) {
+	composeFile := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": {Service: Service{Ports: []string{"80:8080", "443:8443"}}},
+		},
+	}
+
+	override := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": {Service: Service{Ports: []string{"8080:8080"}}},
+		},
+	}
+
+	composeFile.Merge(&override)
+
+	ports := composeFile.Services["api"].Ports
+	if len(ports) != 1 || ports[0] != "8080:8080" {
+		t.Errorf("expected override ports to replace base ports, found %v", ports)
+	}
+}
+
+func TestComposeFileMerge_emptyService(t *testing.T) {
+	composeFile := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": {Service: Service{Image: "api/1.0"}},
+		},
+	}
+
+	override := ComposeFile{
+		Services: map[string]*ComposeService{
+			"api": nil,
+		},
+	}
+
+	composeFile.Merge(&override)
+
+	if composeFile.Services["api"].Image != "api/1.0" {
+		t.Errorf("expected empty override service to keep base settings")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("BRAVE_TEST_IMAGE", "api/1.0")
+	defer os.Unsetenv("BRAVE_TEST_IMAGE")
+
+	cases := map[string]string{
+		"image: ${BRAVE_TEST_IMAGE}":             "image: api/1.0",
+		"image: $BRAVE_TEST_IMAGE":               "image: $BRAVE_TEST_IMAGE",
+		"image: ${BRAVE_TEST_UNSET:-db/2.0}":     "image: db/2.0",
+		"run: echo $${BRAVE_TEST_IMAGE}":         "run: echo ${BRAVE_TEST_IMAGE}",
+		"env: ${service.db.address}":             "env: ${service.db.address}",
+		"image: ${BRAVE_TEST_IMAGE:-unused/0.1}": "image: api/1.0",
+		// Shell syntax in postdeploy commands is left for the shell
+		`run: awk '{print $1}' /etc/hosts`:   `run: awk '{print $1}' /etc/hosts`,
+		"run: test $? -eq 0 && echo $$":      "run: test $? -eq 0 && echo $$",
+		"run: cp app.conf $HOME/.config/app": "run: cp app.conf $HOME/.config/app",
+		"run: echo ${#PATH} ${HOME%/}":       "run: echo ${#PATH} ${HOME%/}",
+	}
+
+	for input, expected := range cases {
+		actual, missing := interpolate(input)
+		if len(missing) > 0 {
+			t.Errorf("unexpected unset variables %v interpolating %q", missing, input)
+		} else if actual != expected {
+			t.Errorf("expected %q to interpolate to %q, found %q", input, expected, actual)
+		}
+	}
+
+	// Unset variables without a default are substituted with an empty string and reported
+	actual, missing := interpolate("image: ${BRAVE_TEST_UNSET}")
+	if actual != "image: " || len(missing) != 1 || missing[0] != "BRAVE_TEST_UNSET" {
+		t.Errorf("expected unset variable to be empty and reported, found %q and %v", actual, missing)
+	}
+}
@@ -13,12 +13,21 @@ const PlatformConfig = BraveHome + "/config.yml"
 // ImageStore ..
 const ImageStore = BraveHome + "/images/"
 
+// BuildLogStore is path to build logs dir
+const BuildLogStore = BraveHome + "/builds"
+
 // Bravetools local remote name
 const BravetoolsRemote = "local"
 
 // BraveRemoteStore is path to remotes dir
 const BraveRemoteStore = BraveHome + "/remotes"
 
+// BraveKeyStore is path to image signing keys dir
+const BraveKeyStore = BraveHome + "/keys"
+
+// BraveTrustedKeyStore is path to dir of public keys trusted to sign images
+const BraveTrustedKeyStore = BraveKeyStore + "/trusted"
+
 // BraveClientKey ..
 const BraveClientKey = BraveCertStore + "/client.key"
 
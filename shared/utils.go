@@ -2,7 +2,6 @@ package shared
 
 import (
 	"bytes"
-	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -18,7 +17,6 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/briandowns/spinner"
 	"github.com/lxc/lxd/shared"
 )
 
@@ -279,35 +277,6 @@ func SizeCountToInt(s string) (int64, error) {
 
 }
 
-// FileHash creates MD5 for a given file
-func FileHash(filePath string) (string, error) {
-	operation := Info("Getting hash")
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
-	s.Suffix = " " + operation
-	s.Start()
-	var MD5String string
-
-	file, err := os.Open(filePath)
-	if err != nil {
-		return MD5String, errors.New("failed to open disk file: " + err.Error())
-	}
-
-	defer file.Close()
-	hash := md5.New()
-
-	//Copy the file in the hash interface and check for errors
-	if _, err := io.Copy(hash, file); err != nil {
-		return MD5String, errors.New("failed to copy a file: " + err.Error())
-	}
-
-	hashInBytes := hash.Sum(nil)[:16]
-	MD5String = hex.EncodeToString(hashInBytes)
-
-	s.Stop()
-
-	return MD5String, nil
-}
-
 func FileSha256Hash(path string) (fingerprint string, err error) {
 	f, err := os.Open(path)
 	if err != nil {